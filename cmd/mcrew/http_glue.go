@@ -32,14 +32,7 @@ func (s *Service) toHTTP(ctx context.Context, msg interface{}) error {
 		return fmt.Errorf("HTTP error no 'request' in %s", JS(m))
 	}
 
-	var replyTo interface{}
-
-	bss, err := match.Match(Dwimjs(`{"replyTo":"?mid"}`), msg, match.NewBindings())
-	if err == nil {
-		if 0 < len(bss) {
-			replyTo, _ = bss[0]["?mid"]
-		}
-	}
+	replyTo, _ := match.Bindings(m).Get("/request/replyTo")
 
 	var r HTTPRequest
 	{
@@ -54,7 +47,7 @@ func (s *Service) toHTTP(ctx context.Context, msg interface{}) error {
 		}
 	}
 
-	err = r.Do(ctx, func(ctx context.Context, resp *HTTPResponse) error {
+	err := r.Do(ctx, func(ctx context.Context, resp *HTTPResponse) error {
 		resp.From = "http" // me
 
 		// Again: sorry.
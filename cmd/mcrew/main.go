@@ -78,6 +78,11 @@ func main() {
 	}
 	monitor(ctx, s.Errors, "errors", false)
 
+	// Load any machines we had before and Boot them.
+	if err := s.Restore(ctx); err != nil {
+		panic(err)
+	}
+
 	// We need to boot before starting the WebSocketClient, which
 	// will send us a message we need to be ready to process.
 	if *bootFile != "" {
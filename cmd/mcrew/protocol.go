@@ -28,12 +28,14 @@ import (
 
 // SOp is a Service Operation.
 //
-// Only one of GetSpec, GetCrewOp, or COp should have value.
+// Only one of GetSpec, UpdateSpec, GetCrewOp, or COp should have value.
 type SOp struct {
 	// GetSpec is a utility that invokes the service's SpecProvider.
 	GetSpec *GetSpecOp `json:"getSpec,omitempty" yaml:",omitempty"`
 
-	// ToDo: UpdateSpec.
+	// UpdateSpec migrates machines to the current version of a
+	// spec.
+	UpdateSpec *UpdateSpecOp `json:"updateSpec,omitempty" yaml:"updateSpec,omitempty"`
 
 	// GetCrewOp that gets (a copy of) a Crew.
 	GetCrewOp *GetCrewOp `json:"getCrew,omitempty" yaml:",omitempty"`
@@ -70,6 +72,8 @@ func (o *SOp) Do(ctx context.Context, s *Service) error {
 	var err error
 	if o.GetSpec != nil {
 		err = o.GetSpec.Do(ctx, s)
+	} else if o.UpdateSpec != nil {
+		err = o.UpdateSpec.Do(ctx, s)
 	} else if o.GetCrewOp != nil {
 		err = o.GetCrewOp.Do(ctx, s)
 	} else if o.COp != nil {
@@ -103,6 +107,26 @@ func (o *GetSpecOp) Do(ctx context.Context, s *Service) error {
 	return err
 }
 
+// UpdateSpecOp migrates the crew's machines that run the given spec
+// to the spec's current version.  See Service.UpdateSpec.
+//
+// With DryRun, nothing changes, and the Report lists the persisted
+// machines that would break.
+type UpdateSpecOp struct {
+	Source *crew.SpecSource      `json:"source,omitempty" yaml:",omitempty"`
+	DryRun bool                  `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`
+	Report *crew.MigrationReport `json:"report,omitempty" yaml:",omitempty"`
+}
+
+func (o *UpdateSpecOp) Do(ctx context.Context, s *Service) error {
+	if o.Source == nil {
+		return fmt.Errorf("updateSpec needs a source")
+	}
+	r, err := s.UpdateSpec(ctx, o.Source, o.DryRun)
+	o.Report = r
+	return err
+}
+
 type GetCrewOp struct {
 	Crew *crew.Crew `json:"crew,omitempty" yaml:",omitempty"`
 }
@@ -170,22 +194,11 @@ func (o *OpAdd) Do(ctx context.Context, s *Service) error {
 			Bs:       match.NewBindings(),
 		}
 	}
-	// get spec and set default values if they are not provided by
-	// initial bindings
-	specter, err := s.GetSpec(ctx, o.Machine.SpecSource)
-	if err != nil {
-		return err
-	}
-	spec := specter.Spec()
-	for key, param := range spec.ParamSpecs {
-		if param.Default != nil {
-			_, ok := o.Machine.State.Bs[key]
-			if !ok {
-				o.Machine.State.Bs[key] = param.Default
-			}
-		}
+	if o.Machine.SpecSource == nil {
+		return fmt.Errorf("no spec given")
 	}
-	//
+	// AddMachine checks parameters and sets default values if
+	// they are not provided by initial bindings.
 	o.Error, o.Err = erred(s.AddMachine(ctx,
 		o.Machine.SpecSource.Name,
 		o.Machine.Id,
@@ -221,6 +234,12 @@ type OpProcess struct {
 	Oid string `json:"oid,omitempty" yaml:",omitempty"`
 
 	// Ctl specifies how the processing behaves.
+	//
+	// Ctl can include declarative breakpoints.  For example,
+	//
+	//   {"limit":100,"breakpoints":{"b1":{"node":"process"}}}
+	//
+	// See core.BreakpointSpec.
 	Ctl *core.Control `json:"ctl,omitempty" yaml:",omitempty"`
 
 	// Message is the message to process.
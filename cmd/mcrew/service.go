@@ -42,6 +42,7 @@ type Service struct {
 	interpreters core.InterpretersMap
 	crewName     string
 	crew         crew.Crew
+	subs         *crew.Subscriptions // Guarded by crew's lock.
 	specDir      string
 	store        *Storage
 	timers       *Timers
@@ -91,6 +92,7 @@ func NewService(ctx context.Context, specDir, dbFile, libDir string) (*Service,
 			Machines: make(map[string]*crew.Machine, 32),
 		},
 		store: store,
+		subs:  crew.NewSubscriptions(),
 	}
 
 	if store != nil {
@@ -124,6 +126,25 @@ func (s *Service) op(ctx context.Context, x interface{}) {
 
 func (s *Service) GetSpec(ctx context.Context, src *crew.SpecSource) (core.Specter, error) {
 
+	spec, err := s.readSpec(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = crew.ResolveChildren(ctx, spec, crew.SpecProviderFunc(s.readSpec)); err != nil {
+		return nil, err
+	}
+
+	if err = spec.Compile(ctx, s.interpreters, true); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// readSpec reads the (uncompiled) named spec from the spec directory.
+func (s *Service) readSpec(ctx context.Context, src *crew.SpecSource) (*core.Spec, error) {
+
 	if src.Name == "" {
 		return nil, fmt.Errorf("Unsupported SpecSource %s: needs name", JS(src))
 	}
@@ -136,10 +157,6 @@ func (s *Service) GetSpec(ctx context.Context, src *crew.SpecSource) (core.Spect
 		return nil, err
 	}
 
-	if err = spec.Compile(ctx, s.interpreters, true); err != nil {
-		return nil, err
-	}
-
 	return &spec, nil
 }
 
@@ -172,10 +189,9 @@ func (s *Service) Process(ctx context.Context, msg interface{}, ctl *core.Contro
 	defer c.Unlock()
 
 	if all {
-		mids = make([]string, 0, len(c.Machines))
-		for mid := range c.Machines {
-			mids = append(mids, mid)
-		}
+		// Only the machines that could care about this
+		// message.  The others would go nowhere.
+		mids = s.subs.Subscribers(msg)
 	}
 
 	specs := make(map[string]*core.Spec, len(mids))
@@ -213,22 +229,13 @@ func (s *Service) Process(ctx context.Context, msg interface{}, ctl *core.Contro
 		msgs = []interface{}{msg}
 	}
 
-	for _, mid := range mids {
-		m, have := c.Machines[mid]
-		if !have {
-			// Warn?
-			continue
-		}
-		spec, have := specs[mid]
-		if !have {
-			return nil, errors.New("internal error: lost spec for " + mid)
-		}
+	walk := func(mid string, spec *core.Spec, st *core.State, msgs []interface{}) {
 		props := core.StepProps{
 			"mid": mid,
 			"cid": c.Id,
 		}
 
-		walked, err := spec.Walk(ctx, m.State, msgs, ctl, props)
+		walked, err := spec.Walk(ctx, st, msgs, ctl, props)
 		if err != nil {
 			if walked.Error != nil {
 				walked.Error = NewWrappedError(err, walked.Error)
@@ -243,42 +250,257 @@ func (s *Service) Process(ctx context.Context, msg interface{}, ctl *core.Contro
 		}
 	}
 
+	for _, mid := range mids {
+		m, have := c.Machines[mid]
+		if !have {
+			// Warn?
+			continue
+		}
+		spec, have := specs[mid]
+		if !have {
+			return nil, errors.New("internal error: lost spec for " + mid)
+		}
+		walk(mid, spec, m.State, msgs)
+	}
+
+	// Make a machine for each spawned State (see
+	// core.Spec.FanOut).  A new machine gets its parent's spec,
+	// and then it takes whatever steps it can without a message.
+	// The crew only gets these machines if we can write them out.
+	var (
+		spawned = make(map[string]*crew.Machine)
+		machine = func(mid string) *crew.Machine {
+			if m, have := spawned[mid]; have {
+				return m
+			}
+			return c.Machines[mid]
+		}
+		parents = make([]string, 0, len(processed))
+	)
+	for mid := range processed {
+		parents = append(parents, mid)
+	}
+	for 0 < len(parents) {
+		pid := parents[0]
+		parents = parents[1:]
+		for _, st := range processed[pid].Spawned() {
+			mid := crew.SpawnedId(pid)
+			s.trf("Service.Process spawning %s from %s", mid, pid)
+			spawned[mid] = &crew.Machine{
+				Id:         mid,
+				SpecSource: machine(pid).SpecSource,
+				State:      st,
+			}
+			specs[mid] = specs[pid]
+			states[mid] = st.Copy()
+			walk(mid, specs[mid], st, nil)
+			parents = append(parents, mid)
+		}
+	}
+
 	// Gather and write out machine changes.
 	mss := AsMachinesStates(states)
 	for _, ms := range mss {
-		ms.SpecSource = c.Machines[ms.Mid].SpecSource
+		ms.setSpecSource(machine(ms.Mid).SpecSource)
 	}
 
 	if err = s.store.WriteState(ctx, s.crewName, mss); err != nil {
 		log.Printf("Service.Process warning for '%s' failed WriteState: %s", s.crewName, err)
+		for mid := range processed {
+			if _, have := spawned[mid]; !have {
+				s.subs.Set(mid, core.AnyInterest)
+			}
+		}
 	} else {
+		for mid, m := range spawned {
+			c.Machines[mid] = m
+		}
 		for mid, state := range states {
 			c.Machines[mid].State = state
 		}
+		for mid, walked := range processed {
+			s.subs.Set(mid, crew.Interest(specs[mid], c.Machines[mid].State, walked))
+		}
 	}
 
 	if Verbose {
 		Render(os.Stderr, "processed", processed)
 	}
 
-	// Recursively (and asynchronously) process the emitted
-	// msgs.
 	for _, walked := range processed {
-		for _, stride := range walked.Strides {
-			for _, msg := range stride.Emitted {
-				if s.Emitted != nil {
-					select {
-					case s.Emitted <- msg:
-					default:
-						log.Printf("Service.Process Emitted chan blocked")
-					}
+		s.emit(ctx, walked, ctl)
+	}
+
+	return processed, err
+}
+
+// emit recursively (and asynchronously) processes the msgs emitted
+// during the given walk.
+func (s *Service) emit(ctx context.Context, walked *core.Walked, ctl *core.Control) {
+	if walked == nil {
+		return
+	}
+	for _, stride := range walked.Strides {
+		for _, msg := range stride.Emitted {
+			if s.Emitted != nil {
+				select {
+				case s.Emitted <- msg:
+				default:
+					log.Printf("Service.Process Emitted chan blocked")
 				}
-				go s.Process(ctx, msg, ctl)
 			}
+			go s.Process(ctx, msg, ctl)
 		}
 	}
+}
 
-	return processed, err
+func (s *Service) stepProps(mid string) core.StepProps {
+	return core.StepProps{
+		"mid": mid,
+		"cid": s.crew.Id,
+	}
+}
+
+// Restore loads the crew's machines from storage and runs each
+// machine's spec's Boot (if any).
+//
+// A machine that's already in the crew is left alone, and a machine
+// whose spec can't be found is skipped.
+func (s *Service) Restore(ctx context.Context) error {
+	mss, err := s.store.GetCrew(ctx, s.crewName)
+	if err != nil {
+		return err
+	}
+
+	c := &s.crew
+
+	var (
+		walkeds = make([]*core.Walked, 0, len(mss))
+		states  = make(map[string]*core.State, len(mss))
+	)
+
+	c.Lock()
+	for mid, m := range AsMachines(mss) {
+		if _, have := c.Machines[mid]; have {
+			continue
+		}
+		if m.SpecSource == nil {
+			log.Printf("Service.Restore %s has no spec", mid)
+			continue
+		}
+		spec, err := s.GetSpec(ctx, m.SpecSource)
+		if err != nil {
+			log.Printf("Service.Restore %s spec error: %s", mid, err)
+			continue
+		}
+		m.Specter = spec
+		c.Machines[mid] = m
+		s.subs.Set(mid, core.AnyInterest)
+
+		if m.SpecChanged() {
+			log.Printf("Service.Restore %s spec changed from %s to %s", mid, m.SpecSource.Hash, m.SpecHash())
+			states[mid] = m.State.Copy()
+		}
+		m.RecordSpecHash()
+
+		walked, err := m.Boot(ctx, core.BootRestore, s.stepProps(mid))
+		if err != nil {
+			s.err(fmt.Errorf("Service.Restore %s Boot error: %s", mid, err))
+		}
+		if walked != nil {
+			walkeds = append(walkeds, walked)
+			states[mid] = m.State.Copy()
+		}
+	}
+
+	mss = AsMachinesStates(states)
+	for _, ms := range mss {
+		ms.setSpecSource(c.Machines[ms.Mid].SpecSource)
+	}
+	c.Unlock()
+
+	if err = s.store.WriteState(ctx, s.crewName, mss); err != nil {
+		return err
+	}
+
+	for _, walked := range walkeds {
+		s.emit(ctx, walked, s.ProcessCtl)
+	}
+
+	return nil
+}
+
+// UpdateSpec migrates the crew's machines that run the given spec to
+// the spec's current version.  See crew.Crew.UpdateSpec.
+//
+// With dryRun, nothing changes, and the report says which persisted
+// machines would break.
+func (s *Service) UpdateSpec(ctx context.Context, src *crew.SpecSource, dryRun bool) (*crew.MigrationReport, error) {
+	specter, err := s.GetSpec(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	spec := specter.Spec()
+
+	props := core.StepProps{
+		"cid": s.crew.Id,
+	}
+
+	if dryRun {
+		mss, err := s.store.GetCrew(ctx, s.crewName)
+		if err != nil {
+			return nil, err
+		}
+
+		ms := make(map[string]*crew.Machine, len(mss))
+		s.crew.RLock()
+		for mid, m := range s.crew.Machines {
+			ms[mid] = m.Copy()
+		}
+		for mid, m := range AsMachines(mss) {
+			if have, ok := ms[mid]; ok {
+				// Use the State from storage with the
+				// Spec that's in use.
+				m.Specter = have.Specter
+			}
+			ms[mid] = m
+		}
+		s.crew.RUnlock()
+
+		for mid, m := range ms {
+			if m.SpecSource == nil || m.SpecSource.Name != src.Name {
+				delete(ms, mid)
+			}
+		}
+
+		return crew.CheckMigration(ctx, spec, ms, props), nil
+	}
+
+	r, err := s.crew.UpdateSpec(ctx, spec, props, false)
+	if r == nil {
+		return nil, err
+	}
+	for mid, failure := range r.Failed {
+		log.Printf("Service.UpdateSpec %s failed: %s", mid, failure)
+	}
+
+	s.crew.Lock()
+	mss := AsMachinesStates(r.States)
+	for _, ms := range mss {
+		ms.setSpecSource(s.crew.Machines[ms.Mid].SpecSource)
+		s.subs.Set(ms.Mid, core.AnyInterest)
+	}
+	s.crew.Unlock()
+
+	if werr := s.store.WriteState(ctx, s.crewName, mss); werr != nil {
+		return r, werr
+	}
+
+	for _, walked := range r.Walkeds {
+		s.emit(ctx, walked, s.ProcessCtl)
+	}
+
+	return r, err
 }
 
 func (s *Service) AddMachine(ctx context.Context, specName, id, nodeName string, bs match.Bindings) error {
@@ -291,21 +513,54 @@ func (s *Service) AddMachine(ctx context.Context, specName, id, nodeName string,
 
 	c := &s.crew
 
+	src := &crew.SpecSource{
+		Name: specName,
+	}
+
+	spec, err := s.GetSpec(ctx, src)
+	if err != nil {
+		return err
+	}
+	src.Hash = spec.Spec().Id
+
+	bs, warnings, err := spec.Spec().InitialBindings(bs)
+	for _, w := range warnings {
+		log.Printf("Service.AddMachine %s warning: %s", id, w)
+	}
+	if err != nil {
+		return err
+	}
+
 	m := crew.Machine{
-		Id: id,
+		Id:      id,
+		Specter: spec,
 		State: &core.State{
 			NodeName: nodeName,
 			Bs:       bs,
 		},
-		SpecSource: &crew.SpecSource{
-			Name: specName,
-		},
+		SpecSource: src,
 	}
 
+	var (
+		walked  *core.Walked
+		bootErr error
+	)
+
 	c.Lock()
 	_, have := c.Machines[id]
 	if !have {
 		c.Machines[id] = &m
+		s.subs.Set(id, core.AnyInterest)
+		walked, bootErr = m.Boot(ctx, core.BootCreate, s.stepProps(id))
+	}
+	ms := MachineState{
+		Mid:        m.Id,
+		SpecSource: m.SpecSource,
+		SpecHash:   m.SpecSource.Hash,
+		NodeName:   m.State.NodeName,
+		Bs:         m.State.Bs,
+		Child:      m.State.Child,
+		Regions:    m.State.Regions,
 	}
 	c.Unlock()
 
@@ -313,14 +568,13 @@ func (s *Service) AddMachine(ctx context.Context, specName, id, nodeName string,
 		return Exists
 	}
 
-	ms := MachineState{
-		Mid:        m.Id,
-		SpecSource: m.SpecSource,
-		NodeName:   m.State.NodeName,
-		Bs:         m.State.Bs,
+	if err = s.store.WriteState(ctx, s.crewName, []*MachineState{&ms}); err != nil {
+		return err
 	}
 
-	return s.store.WriteState(ctx, s.crewName, []*MachineState{&ms})
+	s.emit(ctx, walked, s.ProcessCtl)
+
+	return bootErr
 }
 
 func (s *Service) RemMachine(ctx context.Context, mid string) error {
@@ -331,10 +585,29 @@ func (s *Service) RemMachine(ctx context.Context, mid string) error {
 
 	// ToDo: Remove timers?
 
+	var (
+		walked  *core.Walked
+		toobErr error
+	)
+
 	s.crew.Lock()
+	if m, have := s.crew.Machines[mid]; have {
+		if m.Specter == nil && m.SpecSource != nil {
+			if spec, err := s.GetSpec(ctx, m.SpecSource); err == nil {
+				m.Specter = spec
+			}
+		}
+		walked, toobErr = m.Toob(ctx, core.ToobDelete, s.stepProps(mid))
+	}
 	delete(s.crew.Machines, mid)
+	s.subs.Remove(mid)
 	s.crew.Unlock()
 
+	s.emit(ctx, walked, s.ProcessCtl)
+	if toobErr != nil {
+		s.err(fmt.Errorf("Service.RemMachine %s Toob error: %s", mid, toobErr))
+	}
+
 	return s.store.WriteState(ctx, s.crewName, []*MachineState{&ms})
 }
 
@@ -14,6 +14,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"os"
 	"time"
@@ -144,3 +145,212 @@ func TestServiceRemMachine(t *testing.T) {
 
 	s.store.Close(ctx) // ToDo: Check error.
 }
+
+func TestServiceBreakpoint(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := testServiceBasic(ctx, t)
+	defer s.store.Close(ctx) // ToDo: Check error.
+
+	var op COp
+	js := `{"process":{"ctl":{"limit":10,"breakpoints":{"b1":{"node":"process"}}},"message":{"double":3}}}`
+	if err := json.Unmarshal([]byte(js), &op); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := op.Do(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	walked, have := op.Process.Walked["double"]
+	if !have {
+		t.Fatal(JS(op.Process.Walked))
+	}
+	if walked.StoppedBecause != core.BreakpointReached {
+		t.Fatal(walked.StoppedBecause)
+	}
+	if walked.BreakpointId != "b1" {
+		t.Fatal(walked.BreakpointId)
+	}
+	if to := walked.To(); to == nil || to.NodeName != "process" {
+		t.Fatal(JS(walked))
+	}
+}
+
+func TestServiceUpdateSpec(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+
+	writeSpec := func(src string) {
+		if err := os.WriteFile(dir+"/toggle.yaml", []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeSpec(`
+name: toggle
+version: "1"
+nodes:
+  start: {}
+  old: {}
+`)
+
+	s, err := NewService(ctx, dir, dir+"/test.db", "lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.store.Close(ctx) // ToDo: Check error.
+
+	if err = s.AddMachine(ctx, "toggle", "m", "old", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(dryRun bool) *crew.MigrationReport {
+		op := SOp{
+			UpdateSpec: &UpdateSpecOp{
+				Source: crew.NewSpecSource("toggle"),
+				DryRun: dryRun,
+			},
+		}
+		if err := op.Do(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+		return op.UpdateSpec.Report
+	}
+
+	// Version 2 doesn't have the node "old".
+	writeSpec(`
+name: toggle
+version: "2"
+nodes:
+  start: {}
+  new: {}
+`)
+
+	if r := update(true); len(r.Failed) != 1 || r.Failed["m"] == "" {
+		t.Fatal(JS(r))
+	}
+
+	writeSpec(`
+name: toggle
+version: "2"
+migrations:
+- from: "1"
+  nodes:
+    old: new
+  defaults:
+    count: 0
+nodes:
+  start: {}
+  new: {}
+`)
+
+	if r := update(true); len(r.Failed) != 0 || JS(r.Migrated) != `["m"]` {
+		t.Fatal(JS(r))
+	}
+	if st := s.crew.Machines["m"].State; st.NodeName != "old" {
+		t.Fatal(JS(st))
+	}
+
+	if r := update(false); len(r.Failed) != 0 || r.DryRun {
+		t.Fatal(JS(r))
+	}
+
+	m := s.crew.Machines["m"]
+	if m.State.NodeName != "new" || m.State.Bs["count"] == nil {
+		t.Fatal(JS(m.State))
+	}
+
+	mss, err := s.store.GetCrew(ctx, s.crewName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mss) != 1 || mss[0].NodeName != "new" || mss[0].SpecHash != m.SpecHash() {
+		t.Fatal(JS(mss))
+	}
+}
+
+func TestServiceSpawn(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+
+	spec := `
+name: fan
+fanOut: true
+nodes:
+  start:
+    branching:
+      type: message
+      branches:
+      - pattern:
+          ids: ["?id"]
+        target: listening
+  listening: {}
+`
+	if err := os.WriteFile(dir+"/fan.yaml", []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewService(ctx, dir, dir+"/test.db", "lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.AddMachine(ctx, "fan", "m", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Process(ctx, Dwimjs(`{"ids":["a","b","c"]}`), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The machine took one id, and two new machines took the
+	// others.
+	if n := len(s.crew.Machines); n != 3 {
+		t.Fatal(JS(s.crew.Machines))
+	}
+	ids := make(map[interface{}]bool)
+	for mid, m := range s.crew.Machines {
+		if m.State.NodeName != "listening" {
+			t.Fatalf("%s at %s", mid, m.State.NodeName)
+		}
+		if m.SpecSource == nil || m.SpecSource.Name != "fan" {
+			t.Fatalf("%s has %s", mid, JS(m.SpecSource))
+		}
+		ids[m.State.Bs["?id"]] = true
+	}
+	if len(ids) != 3 {
+		t.Fatal(ids)
+	}
+
+	mss, err := s.store.GetCrew(ctx, s.crewName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mss) != 3 {
+		t.Fatal(JS(mss))
+	}
+
+	// If the new machines can't be written out, the crew doesn't
+	// get them.
+	if err = s.AddMachine(ctx, "fan", "n", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.store.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Process(ctx, Dwimjs(`{"ids":["d","e"]}`), nil); err == nil {
+		t.Fatal("expected a WriteState error")
+	}
+	if n := len(s.crew.Machines); n != 4 {
+		t.Fatal(JS(s.crew.Machines))
+	}
+}
@@ -38,12 +38,28 @@ type MachineState struct {
 	NodeName   string           `json:"node"`
 	Bs         match.Bindings   `json:"bs"`
 
+	// SpecHash is the Id (see core.Spec.Hash) of the Spec that
+	// the machine was running when this state was written.
+	SpecHash string `json:"specHash,omitempty" yaml:"specHash,omitempty"`
+
+	// Child and Regions are from core.State.
+	Child   *core.State       `json:"child,omitempty" yaml:",omitempty"`
+	Regions map[string]string `json:"regions,omitempty" yaml:",omitempty"`
+
 	// Deleted indicated that this machine has been deleted.
 	//
 	// Yes, this flag is a hack.
 	Deleted bool `json:"-" yaml:"-"`
 }
 
+// setSpecSource sets the SpecSource and the SpecHash.
+func (ms *MachineState) setSpecSource(src *crew.SpecSource) {
+	ms.SpecSource = src
+	if src != nil {
+		ms.SpecHash = src.Hash
+	}
+}
+
 // AsMachinesStates is a function, naturally, and it takes in changes
 // to a MachineState and record them each as a new state. In return it will
 // give you back those states to do with as you please
@@ -54,6 +70,8 @@ func AsMachinesStates(changes map[string]*core.State) []*MachineState {
 			Mid:      mid,
 			NodeName: s.NodeName,
 			Bs:       s.Bs,
+			Child:    s.Child,
+			Regions:  s.Regions,
 		}
 		acc = append(acc, ms)
 	}
@@ -71,9 +89,15 @@ func AsMachines(mss []*MachineState) map[string]*crew.Machine {
 			State: &core.State{
 				NodeName: ms.NodeName,
 				Bs:       ms.Bs,
+				Child:    ms.Child,
+				Regions:  ms.Regions,
 			},
 			SpecSource: ms.SpecSource,
 		}
+		if ms.SpecHash != "" && ms.SpecSource != nil && ms.SpecSource.Hash != ms.SpecHash {
+			m.SpecSource = ms.SpecSource.Copy()
+			m.SpecSource.Hash = ms.SpecHash
+		}
 		acc[ms.Mid] = m
 	}
 	return acc
@@ -215,8 +239,11 @@ func (s *Storage) WriteState(ctx context.Context, pid string, mss []*MachineStat
 			// To save some space, remove id.
 			ms = &MachineState{
 				SpecSource: ms.SpecSource,
+				SpecHash:   ms.SpecHash,
 				NodeName:   ms.NodeName,
 				Bs:         ms.Bs,
+				Child:      ms.Child,
+				Regions:    ms.Regions,
 			}
 			js, err := json.Marshal(&ms)
 			if err != nil {
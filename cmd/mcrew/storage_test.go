@@ -0,0 +1,98 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Comcast/sheens/core"
+	"github.com/Comcast/sheens/crew"
+	"github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestStorageRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbFile := "storage-test.db"
+	defer os.Remove(dbFile)
+
+	s, err := NewStorage(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+
+	st := &core.State{
+		NodeName: "sending",
+		Bs:       match.Bindings{"x": "tacos"},
+		Child: &core.State{
+			NodeName: "waiting",
+		},
+		Regions: map[string]string{
+			"connectivity": "online",
+		},
+	}
+
+	mss := AsMachinesStates(map[string]*core.State{"m1": st})
+	mss[0].setSpecSource(&crew.SpecSource{
+		Name: "double",
+		Hash: "sha256:old",
+	})
+	if err = s.WriteState(ctx, "crew", mss); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetCrew(ctx, "crew")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, have := AsMachines(got)["m1"]
+	if !have {
+		t.Fatal(JS(got))
+	}
+	if m.State.Child == nil || m.State.Child.NodeName != "waiting" {
+		t.Fatal(JS(m.State))
+	}
+	if m.State.Regions["connectivity"] != "online" {
+		t.Fatal(JS(m.State))
+	}
+	if got[0].SpecHash != "sha256:old" || m.SpecSource.Hash != "sha256:old" {
+		t.Fatal(JS(got))
+	}
+
+	// The spec changed under the persisted machine.
+	spec := &core.Spec{
+		Name: "double",
+		Nodes: map[string]*core.Node{
+			"start": {},
+		},
+	}
+	if err = spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	m.Specter = spec
+	if !m.SpecChanged() {
+		t.Fatal("didn't notice the spec changed")
+	}
+	m.RecordSpecHash()
+	if m.SpecChanged() || m.SpecSource.Hash != spec.Id {
+		t.Fatal(JS(m.SpecSource))
+	}
+}
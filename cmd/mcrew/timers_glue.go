@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/Comcast/sheens/match"
 	testutils "github.com/Comcast/sheens/util/testutil"
 )
 
@@ -28,6 +29,8 @@ func (s *Service) toTimers(ctx context.Context, msg interface{}) error {
 		return fmt.Errorf("%s (%T) isn't a %T", testutils.JS(msg), msg, m)
 	}
 
+	bs := match.Bindings(m)
+
 	if v, have := m["makeTimer"]; have {
 
 		if m, is = v.(map[string]interface{}); !is {
@@ -35,7 +38,7 @@ func (s *Service) toTimers(ctx context.Context, msg interface{}) error {
 		}
 
 		var id string
-		if x, have := m["id"]; have {
+		if x, have := bs.Get("/makeTimer/id"); have {
 			if id, is = x.(string); !is {
 				return fmt.Errorf("id %s (%T) isn't a %T", testutils.JS(x), x, id)
 			}
@@ -43,7 +46,7 @@ func (s *Service) toTimers(ctx context.Context, msg interface{}) error {
 
 		var d time.Duration
 		var err error
-		if x, have := m["in"]; have {
+		if x, have := bs.Get("/makeTimer/in"); have {
 			str, is := x.(string)
 			if !is {
 				return fmt.Errorf("'in' %s (%T) isn't a %T", testutils.JS(x), x, id)
@@ -51,7 +54,7 @@ func (s *Service) toTimers(ctx context.Context, msg interface{}) error {
 			if d, err = time.ParseDuration(str); err != nil {
 				return fmt.Errorf("bad duration '%s': %s", str, err)
 			}
-		} else if x, have := m["at"]; have {
+		} else if x, have := bs.Get("/makeTimer/at"); have {
 			str, is := x.(string)
 			if !is {
 				return fmt.Errorf("'in' %s (%T) isn't a %T", testutils.JS(x), x, id)
@@ -66,7 +69,7 @@ func (s *Service) toTimers(ctx context.Context, msg interface{}) error {
 			return fmt.Errorf("no 'at' or 'in' in %s", testutils.JS(m))
 		}
 
-		msg, have := m["message"]
+		msg, have := bs.Get("/makeTimer/message")
 		if !have {
 			return fmt.Errorf("no 'message' in %s", testutils.JS(m))
 		}
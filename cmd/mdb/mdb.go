@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/Comcast/sheens/core"
@@ -95,6 +96,14 @@ func (opts *Opts) run() error {
 
 		debug = regexp.MustCompile("^debug(ging)? (on|off)")
 
+		setBreakpoint = regexp.MustCompile("^break +([-a-zA-Z0-9_]+) +(.*)")
+
+		remBreakpoint = regexp.MustCompile("^unbreak +([-a-zA-Z0-9_]+)")
+
+		breakpoints = regexp.MustCompile("^breakpoints$")
+
+		explain = regexp.MustCompile("^explain (on|off)")
+
 		outputPrefix = "# "
 
 		debugging = false
@@ -177,6 +186,18 @@ func (opts *Opts) run() error {
 				continue
 			}
 			m.Specter = spec
+			// The bindings might not have the required
+			// parameters yet (see "set ID bindings"), so
+			// just report problems here.
+			bs, warnings, err := spec.Spec().InitialBindings(m.State.Bs)
+			for _, w := range warnings {
+				say("warning: %s", w)
+			}
+			if err != nil {
+				say("warning: %s", err)
+			} else {
+				m.State.Bs = bs
+			}
 			if !have {
 				h.crew.Machines[mid] = m
 				say("crew now has %d machines", len(h.crew.Machines))
@@ -217,6 +238,17 @@ func (opts *Opts) run() error {
 				protest("machine '%s' not found", mid)
 				continue
 			}
+			if m.Specter != nil {
+				checked, warnings, err := m.Specter.Spec().InitialBindings(bs)
+				for _, w := range warnings {
+					say("warning: %s", w)
+				}
+				if err != nil {
+					protest("bad parameters: %s", err)
+					continue
+				}
+				bs = checked
+			}
 			m.State.Bs = bs
 			continue
 		}
@@ -309,6 +341,65 @@ func (opts *Opts) run() error {
 			continue
 		}
 
+		if ss = explain.FindStringSubmatch(line); 0 < len(ss) {
+			switch ss[1] {
+			case "on":
+				h.ctl.TraceLevel = core.TraceExplain
+				say("explaining patterns that don't match")
+			case "off":
+				h.ctl.TraceLevel = ""
+				say("not explaining patterns")
+			}
+			continue
+		}
+
+		if ss = setBreakpoint.FindStringSubmatch(line); 0 < len(ss) {
+			id := ss[1]
+			js := ss[2]
+			var b core.BreakpointSpec
+			if err = json.Unmarshal([]byte(js), &b); err != nil {
+				protest("couldn't parse breakpoint %s", js)
+				continue
+			}
+			if h.ctl.BreakpointSpecs == nil {
+				h.ctl.BreakpointSpecs = make(map[string]*core.BreakpointSpec)
+			}
+			h.ctl.BreakpointSpecs[id] = &b
+			say("%d breakpoints", len(h.ctl.BreakpointSpecs))
+			continue
+		}
+
+		if ss = remBreakpoint.FindStringSubmatch(line); 0 < len(ss) {
+			id := ss[1]
+			if _, have := h.ctl.BreakpointSpecs[id]; !have {
+				protest("breakpoint '%s' not found", id)
+				continue
+			}
+			delete(h.ctl.BreakpointSpecs, id)
+			say("%d breakpoints", len(h.ctl.BreakpointSpecs))
+			continue
+		}
+
+		if ss = breakpoints.FindStringSubmatch(line); 0 < len(ss) {
+			if len(h.ctl.BreakpointSpecs) == 0 {
+				say("no breakpoints")
+				continue
+			}
+			ids := make([]string, 0, len(h.ctl.BreakpointSpecs))
+			for id := range h.ctl.BreakpointSpecs {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				js, err := json.Marshal(h.ctl.BreakpointSpecs[id])
+				if err != nil {
+					return err // Internal error
+				}
+				say("%s %s", id, js)
+			}
+			continue
+		}
+
 		if ss = print.FindStringSubmatch(line); 0 < len(ss) {
 			mid := ss[2]
 			printer := func(id string) error {
@@ -317,6 +408,12 @@ func (opts *Opts) run() error {
 					return fmt.Errorf("machine '%s' not found", id)
 				}
 				say("  node:     %s", m.State.NodeName)
+				if m.State.Child != nil {
+					say("  child:    %s", strings.Join(m.State.Child.Path(), " > "))
+				}
+				for region, node := range m.State.Regions {
+					say("  region:   %s at %s", region, node)
+				}
 				js, err := json.Marshal(m.State.Bs)
 				if err != nil {
 					return err // Internal error
@@ -395,7 +492,7 @@ type Host struct {
 
 func NewHost(specDir, libDir string) (*Host, error) {
 	return &Host{
-		ctl:     core.DefaultControl,
+		ctl:     core.DefaultControl.Copy(),
 		specDir: specDir,
 		crew: crew.Crew{
 			Machines: make(map[string]*crew.Machine, 32),
@@ -406,6 +503,23 @@ func NewHost(specDir, libDir string) (*Host, error) {
 
 func (h *Host) GetSpec(ctx context.Context, src *crew.SpecSource) (core.Specter, error) {
 
+	spec, err := h.readSpec(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if err = crew.ResolveChildren(ctx, spec, crew.SpecProviderFunc(h.readSpec)); err != nil {
+		return nil, err
+	}
+	if err = spec.Compile(ctx, h.interpreters, true); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// readSpec reads the (uncompiled) named spec from the spec directory.
+func (h *Host) readSpec(ctx context.Context, src *crew.SpecSource) (*core.Spec, error) {
+
 	if src.Name == "" {
 		return nil, fmt.Errorf("Unsupported SpecSource %s: needs name", JS(src))
 	}
@@ -427,9 +541,6 @@ func (h *Host) GetSpec(ctx context.Context, src *crew.SpecSource) (core.Specter,
 	if err = yaml.Unmarshal(specSrc, &spec); err != nil {
 		return nil, err
 	}
-	if err = spec.Compile(ctx, h.interpreters, true); err != nil {
-		return nil, err
-	}
 
 	return &spec, nil
 }
@@ -563,7 +674,11 @@ func doc() string {
   drop                       Drop the first message in the queue
   save FILENAME              Save the crew machines to this file
   load FILENAME              Load the crew machines from this file
+  break NAME BREAKPOINT      Set a breakpoint (JSON; see core.BreakpointSpec)
+  unbreak NAME               Remove the breakpoint with that name
+  breakpoints                Show the breakpoints
   debug on/off               When debugging, show walking details
+  explain on/off             When explaining, show why branch patterns didn't match
   help                       Show this documentation
 `
 }
@@ -586,10 +701,20 @@ func Render(w io.Writer, prefix, tag string, m map[string]*core.Walked) {
 			for _, emitted := range stride.Events.Emitted {
 				fmt.Fprintf(w, "%s        %s\n", prefix, JS(emitted))
 			}
+			if stride.Traces != nil {
+				for _, x := range stride.Traces.Messages {
+					if e, is := x.(*core.StepEvent); is && e.Explanation != nil {
+						fmt.Fprintf(w, "%s     missed   %s %s\n", prefix, e.Branch.Target, e.Explanation)
+					}
+				}
+			}
 		}
 		if walked.Error != nil {
 			fmt.Fprintf(w, "%s  error    %v\n", prefix, walked.Error)
 		}
 		fmt.Fprintf(w, "%s  stopped     %v\n", prefix, walked.StoppedBecause)
+		if walked.BreakpointId != "" {
+			fmt.Fprintf(w, "%s  breakpoint  %s\n", prefix, walked.BreakpointId)
+		}
 	}
 }
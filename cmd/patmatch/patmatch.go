@@ -14,6 +14,22 @@
 //
 //   patmatch -p '{"likes":"?liked"}' -m '{"likes":["tacos","chips"]}' -w '[{"?liked":["tacos","chipss"]}]'
 //
+// Use -b to give input bindings, say, for an inequality:
+//
+//   patmatch -p '{"n":"?<n"}' -m '{"n":3}' -b '{"?<n":10}'
+//
+// Use -matcher to override the default Matcher's switches:
+//
+//   patmatch -p '{"entity":"?~id"}' -m '{"entity":"light.kitchen"}' -b '{"?~id":"light\\.(.*)"}' -matcher '{"StringPatterns":true}'
+//
+//   patmatch -p '{"temp":"?temp:number"}' -m '{"temp":72}' -matcher '{"TypedVariables":true}'
+//
+//   patmatch -p '{"wants":{"$not":"queso"}}' -m '{"wants":"tacos"}' -matcher '{"Operators":true}'
+//
+// Use -explain to see why a pattern didn't match:
+//
+//   patmatch -p '{"likes":"tacos"}' -m '{"likes":"chips"}' -explain
+//
 package main
 
 import (
@@ -34,9 +50,12 @@ func main() {
 		patternJS  = flag.String("p", "", "pattern in JSON")
 		bindingsJS = flag.String("b", "{}", "bindings in JSON")
 		wantJS     = flag.String("w", "", "wanted bindings in JSON")
+		matcherJS  = flag.String("matcher", "", "Matcher switches in JSON")
 
 		bench = flag.Int("bench", 0, "number of times to run (and report time)")
 
+		explain = flag.Bool("explain", false, "explain why the pattern didn't match")
+
 		verbose = flag.Bool("v", false, "verbosity")
 
 		message  interface{}
@@ -44,6 +63,7 @@ func main() {
 		want     []match.Bindings
 		wanted   bool
 		bindings match.Bindings
+		matcher  = *match.DefaultMatcher
 	)
 
 	flag.Parse()
@@ -66,6 +86,12 @@ func main() {
 		}
 	}
 
+	if *matcherJS != "" {
+		if err := json.Unmarshal([]byte(*matcherJS), &matcher); err != nil {
+			panic(err)
+		}
+	}
+
 	if *wantJS != "" {
 		if err := json.Unmarshal([]byte(*wantJS), &want); err != nil {
 			panic(err)
@@ -79,7 +105,7 @@ func main() {
 		allocs := stats.TotalAlloc
 		then := time.Now()
 		for i := 0; i < *bench; i++ {
-			if _, err := match.Match(pattern, message, bindings); err != nil {
+			if _, err := matcher.Match(pattern, message, bindings); err != nil {
 				panic(err)
 			}
 		}
@@ -92,7 +118,7 @@ func main() {
 		log.Printf("%d iterations, %d mean ns/Match, %d mean bytes allocated per Match", *bench, meanNanos, allocated)
 	}
 
-	bss, err := match.Match(pattern, message, bindings)
+	bss, err := matcher.Match(pattern, message, bindings)
 	if err != nil {
 		panic(err)
 	}
@@ -128,6 +154,14 @@ func main() {
 	}
 
 	fmt.Printf("%s\n", bssJS)
+
+	if *explain && len(bss) == 0 {
+		js, err := json.Marshal(matcher.Explain(pattern, message, bindings))
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", js)
+	}
 }
 
 // Subset tries to check that Bindings x is a subset of Bindings y.
@@ -36,6 +36,9 @@ type Machine struct {
 	Node string         `json:"node"`
 	Bs   match.Bindings `json:"bs"`
 
+	Child   *core.State       `json:"child,omitempty"`
+	Regions map[string]string `json:"regions,omitempty"`
+
 	spec *core.Spec
 	Id   string `json:"id"`
 }
@@ -130,6 +133,8 @@ func main() {
 			st := &core.State{
 				NodeName: m.Node,
 				Bs:       m.Bs,
+				Child:    m.Child,
+				Regions:  m.Regions,
 			}
 			walked, err := m.spec.Walk(ctx, st, pending, ctl, props)
 			if err != nil {
@@ -140,6 +145,8 @@ func main() {
 			if to := walked.To(); to != nil {
 				m.Node = to.NodeName
 				m.Bs = to.Bs
+				m.Child = to.Child
+				m.Regions = to.Regions
 			}
 
 			for _, stride := range walked.Strides {
@@ -168,19 +168,10 @@ func (a *FuncAction) Exec(ctx context.Context, bs Bindings, props StepProps) (*E
 		}
 	}
 
-	{ // This block just generates tracing data.
-		if exe == nil {
-			exe = NewExecution(nil)
-		}
-		t := map[string]interface{}{
-			"action":  "executed",
-			"emitted": len(exe.Events.Emitted),
-			"bs":      exe.Bs,
-		}
-		if err != nil {
-			t["error"] = err.Error()
-		}
-		exe.AddTrace(t)
+	// Step reports the execution (see ActionExecuted), so we
+	// don't add a trace here.
+	if exe == nil {
+		exe = NewExecution(nil)
 	}
 
 	return exe, err
@@ -193,20 +184,23 @@ type ActionSource struct {
 	Binds       []Bindings  `json:"binds,omitempty" yaml:",omitempty"`
 }
 
-// Copy makes a shallow copy.
+// Copy makes a deep copy.
 //
 // Needed for Specification.Copy().
 func (a *ActionSource) Copy() *ActionSource {
 	if a == nil {
 		return nil
 	}
-	binds := make([]Bindings, len(a.Binds))
-	for i, b := range a.Binds {
-		binds[i] = b.Copy()
+	var binds []Bindings
+	if a.Binds != nil {
+		binds = make([]Bindings, len(a.Binds))
+		for i, b := range a.Binds {
+			binds[i], _ = copyValue(b).(Bindings)
+		}
 	}
 	return &ActionSource{
 		Interpreter: a.Interpreter,
-		Source:      a.Source,
+		Source:      copyValue(a.Source),
 		Binds:       binds,
 	}
 }
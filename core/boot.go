@@ -0,0 +1,99 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+)
+
+// Reasons given to ExecBoot and ExecToob.  An Action can find the
+// reason at the StepProps property "lifecycle".
+const (
+	// BootCreate means a machine was just created.
+	BootCreate = "create"
+
+	// BootRestore means a machine was loaded from storage.
+	BootRestore = "restore"
+
+	// BootUpdate means a machine was switched to a new Spec.
+	BootUpdate = "update"
+
+	// ToobDelete means a machine is being deleted.
+	ToobDelete = "delete"
+
+	// ToobSuspend means a machine is being unloaded (but not
+	// deleted).
+	ToobSuspend = "suspend"
+)
+
+// ExecBoot executes the Spec's Boot Action (if any) with the given
+// State's Bindings.
+//
+// The returned Stride has the Boot's emitted messages and traces.
+// The Stride's To has the same node as the given State and the
+// Bindings returned by the Action.  (Unlike a node's Action, a Boot
+// that returns nil Bindings leaves the Bindings unchanged.)
+//
+// The given reason (see BootCreate, BootRestore, and BootUpdate) and
+// the current node name are available to the Action at the StepProps
+// properties "lifecycle" and "node".  With those properties and
+// permanent bindings (see Exp_PermanentBindings), a Boot can migrate
+// a machine when its Spec changes.  A Boot can also bind a node name
+// that a branch target variable (see Exp_BranchTargetVariables) can
+// use later.
+//
+// If the Spec has no Boot, returns nil.
+func (s *Spec) ExecBoot(ctx context.Context, st *State, reason string, props StepProps) (*Stride, error) {
+	return s.execLifecycle(ctx, s.Boot, st, reason, props)
+}
+
+// ExecToob is the opposite of ExecBoot.  Call it when a machine is
+// deleted (ToobDelete) or suspended (ToobSuspend).
+//
+// If the Spec has no Toob, returns nil.
+func (s *Spec) ExecToob(ctx context.Context, st *State, reason string, props StepProps) (*Stride, error) {
+	return s.execLifecycle(ctx, s.Toob, st, reason, props)
+}
+
+func (s *Spec) execLifecycle(ctx context.Context, a Action, st *State, reason string, props StepProps) (*Stride, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	if props == nil {
+		props = make(StepProps, 2)
+	} else {
+		props = props.Copy()
+	}
+	props["lifecycle"] = reason
+	props["node"] = st.NodeName
+
+	stride := NewStride()
+	stride.From = st.Copy()
+
+	e, err := a.Exec(ctx, st.Bs.Copy(), props)
+	if e != nil {
+		stride.AddEvents(e.Events)
+	}
+	if err != nil {
+		return stride, err
+	}
+
+	to := st.Copy()
+	if e != nil && e.Bs != nil {
+		to.Bs = e.Bs
+	}
+	stride.To = to
+
+	return stride, nil
+}
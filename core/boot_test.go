@@ -0,0 +1,77 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestExecBoot(t *testing.T) {
+	spec := &Spec{
+		Name: "test",
+		Boot: &FuncAction{
+			F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+				e := NewExecution(bs.Extend("booted", props["lifecycle"]))
+				e.AddEmitted(map[string]interface{}{
+					"bootedAt": props["node"],
+				})
+				return e, nil
+			},
+		},
+		Nodes: map[string]*Node{
+			"start": {},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{
+		NodeName: "start",
+		Bs:       Bindings{"x!": 1},
+	}
+
+	stride, err := spec.ExecBoot(ctx, st, BootRestore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stride.To.NodeName != "start" {
+		t.Fatalf("went to %s", stride.To.NodeName)
+	}
+	if stride.To.Bs["booted"] != BootRestore {
+		t.Fatal(JS(stride.To.Bs))
+	}
+	if _, have := st.Bs["booted"]; have {
+		t.Fatal("modified the given state")
+	}
+	if len(stride.Emitted) != 1 {
+		t.Fatal(JS(stride.Emitted))
+	}
+
+	// No Toob, so nothing to do.
+	if stride, err = spec.ExecToob(ctx, st, ToobDelete, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stride != nil {
+		t.Fatal(JS(stride))
+	}
+}
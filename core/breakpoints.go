@@ -0,0 +1,156 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"sort"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+// BreakpointSpec is a declarative (and JSON-serializable) breakpoint.
+//
+// Unlike a Breakpoint, which is a Go function, a BreakpointSpec can
+// be sent over the wire as part of a Control.
+//
+// All of the given conditions must hold for the breakpoint to fire.
+// A BreakpointSpec without any conditions always fires.
+//
+// If Emitted is nil, the breakpoint is checked before each step
+// (like a Breakpoint), and the Walk stops without taking that step.
+// Otherwise the breakpoint is checked after each step, and the Walk
+// stops after the step that emitted a matching message.
+type BreakpointSpec struct {
+	// Node, if not empty, is the name of the node where the
+	// breakpoint applies.  For an Emitted breakpoint, this node
+	// is the node where the step started.
+	Node string `json:"node,omitempty" yaml:",omitempty"`
+
+	// Bindings, if not nil, is a pattern that must match the
+	// machine's Bindings.
+	Bindings interface{} `json:"bindings,omitempty" yaml:",omitempty"`
+
+	// Message, if not nil, is a pattern that must match the
+	// pending message.
+	Message interface{} `json:"message,omitempty" yaml:",omitempty"`
+
+	// Emitted, if not nil, is a pattern that must match a
+	// message emitted during a step.
+	Emitted interface{} `json:"emitted,omitempty" yaml:",omitempty"`
+}
+
+// Copy makes a deep copy of the BreakpointSpec.
+func (b *BreakpointSpec) Copy() *BreakpointSpec {
+	if b == nil {
+		return nil
+	}
+	return &BreakpointSpec{
+		Node:     b.Node,
+		Bindings: copyValue(b.Bindings),
+		Message:  copyValue(b.Message),
+		Emitted:  copyValue(b.Emitted),
+	}
+}
+
+// matches reports whether the given pattern (if not nil) matches
+// the given fact using the given Matcher.
+func (b *BreakpointSpec) matches(m *Matcher, pattern, fact interface{}) (bool, error) {
+	if pattern == nil {
+		return true, nil
+	}
+	if fact == nil {
+		return false, nil
+	}
+	bss, err := m.Match(pattern, fact, NewBindings())
+	if err != nil {
+		return false, err
+	}
+	return 0 < len(bss), nil
+}
+
+// at reports whether the breakpoint's Node and Bindings conditions
+// hold for the given State.
+func (b *BreakpointSpec) at(m *Matcher, st *State) (bool, error) {
+	if st == nil {
+		return false, nil
+	}
+	if b.Node != "" && b.Node != st.NodeName {
+		return false, nil
+	}
+	return b.matches(m, b.Bindings, map[string]interface{}(st.Bs))
+}
+
+// Before reports whether the breakpoint fires before a step from the
+// given State with the given pending message (if any).  The given
+// Matcher (usually the Spec's, see Spec.Matcher) matches the
+// breakpoint's patterns.
+//
+// An Emitted breakpoint never fires before a step.
+func (b *BreakpointSpec) Before(m *Matcher, st *State, pending interface{}) (bool, error) {
+	if b.Emitted != nil {
+		return false, nil
+	}
+	if fired, err := b.at(m, st); !fired || err != nil {
+		return false, err
+	}
+	return b.matches(m, b.Message, pending)
+}
+
+// After reports whether the breakpoint fires after the given Stride.
+// The given Matcher matches the breakpoint's patterns as in Before.
+//
+// Only an Emitted breakpoint fires after a step.
+func (b *BreakpointSpec) After(m *Matcher, stride *Stride) (bool, error) {
+	if b.Emitted == nil {
+		return false, nil
+	}
+	if fired, err := b.at(m, stride.From); !fired || err != nil {
+		return false, err
+	}
+	if b.Message != nil {
+		if fired, err := b.matches(m, b.Message, stride.Consumed); !fired || err != nil {
+			return false, err
+		}
+	}
+	for _, x := range stride.Emitted {
+		if fired, err := b.matches(m, b.Emitted, x); fired || err != nil {
+			return fired, err
+		}
+	}
+	return false, nil
+}
+
+// firedBreakpoint returns the id of the first BreakpointSpec (if any)
+// that the given function says fired.  The BreakpointSpecs are
+// checked in the order of their ids, so the result doesn't depend on
+// map iteration order.
+func (c *Control) firedBreakpoint(f func(*BreakpointSpec) (bool, error)) (string, error) {
+	if len(c.BreakpointSpecs) == 0 {
+		return "", nil
+	}
+	ids := make([]string, 0, len(c.BreakpointSpecs))
+	for id := range c.BreakpointSpecs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fired, err := f(c.BreakpointSpecs[id])
+		if err != nil {
+			return "", err
+		}
+		if fired {
+			return id, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,205 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestBreakpointSpecs(t *testing.T) {
+	// A doubler that counts.
+	spec := &Spec{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{
+							Pattern: Dwimjs(`{"double":"?n"}`),
+							Target:  "process",
+						},
+					},
+				},
+			},
+			"process": {
+				Action: &FuncAction{
+					F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+						n := bs["?n"].(float64)
+						count, _ := bs["count"].(float64)
+						e := NewExecution(NewBindings().Extend("count", count+1))
+						e.AddEmitted(map[string]interface{}{
+							"doubled": n * 2,
+						})
+						return e, nil
+					},
+				},
+				Branches: &Branches{
+					Branches: []*Branch{
+						{
+							Target: "start",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []interface{}{
+		Dwimjs(`{"double":1}`),
+		Dwimjs(`{"double":2}`),
+		Dwimjs(`{"double":3}`),
+	}
+
+	for _, tc := range []struct {
+		name      string
+		ctl       string
+		strides   int
+		remaining int
+		fired     string
+	}{
+		{"none", `{"limit":100}`, 7, 0, ""},
+		{"node", `{"limit":100,"breakpoints":{"b":{"node":"process"}}}`, 1, 2, "b"},
+		{"bindings", `{"limit":100,"breakpoints":{"b":{"bindings":{"count":2}}}}`, 4, 1, "b"},
+		{"message", `{"limit":100,"breakpoints":{"b":{"message":{"double":3}}}}`, 3, 1, "b"},
+		{"emitted", `{"limit":100,"breakpoints":{"b":{"emitted":{"doubled":4}}}}`, 4, 1, "b"},
+		{"both", `{"limit":100,"breakpoints":{"b":{"node":"start","bindings":{"count":"?c"},"message":{"double":2}}}}`, 2, 2, "b"},
+		{"several", `{"limit":100,"breakpoints":{"c":{"node":"process"},"a":{"node":"process"},"b":{"node":"process"}}}`, 1, 2, "a"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Control
+			if err := json.Unmarshal([]byte(tc.ctl), &c); err != nil {
+				t.Fatal(err)
+			}
+
+			// Make sure the breakpoints survive a round trip.
+			js, err := json.Marshal(c.Copy())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c = Control{}
+			if err := json.Unmarshal(js, &c); err != nil {
+				t.Fatal(err)
+			}
+
+			st := &State{
+				NodeName: "start",
+				Bs:       NewBindings(),
+			}
+
+			walked, err := spec.Walk(ctx, st, msgs, &c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(walked.Strides) != tc.strides {
+				t.Fatalf("took %d strides (not %d): %s", len(walked.Strides), tc.strides, JS(walked))
+			}
+			if len(walked.Remaining) != tc.remaining {
+				t.Fatalf("%d remaining (not %d)", len(walked.Remaining), tc.remaining)
+			}
+			if len(c.BreakpointSpecs) == 0 {
+				if walked.StoppedBecause != Done {
+					t.Fatal(walked.StoppedBecause)
+				}
+				return
+			}
+			if walked.StoppedBecause != BreakpointReached {
+				t.Fatal(walked.StoppedBecause)
+			}
+			if walked.BreakpointId != tc.fired {
+				t.Fatal(walked.BreakpointId)
+			}
+		})
+	}
+}
+
+func TestBreakpointSpecCopy(t *testing.T) {
+	var c Control
+	js := `{"breakpoints":{"b":{"bindings":{"rooms":["den"]},"message":{"double":"?n"}}}}`
+	if err := json.Unmarshal([]byte(js), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	d := c.Copy()
+	c.BreakpointSpecs["b"].Bindings.(map[string]interface{})["rooms"].([]interface{})[0] = "attic"
+	c.BreakpointSpecs["b"].Message.(map[string]interface{})["double"] = 3
+
+	if got := JS(d.BreakpointSpecs); got != `{"b":{"bindings":{"rooms":["den"]},"message":{"double":"?n"}}}` {
+		t.Fatal(got)
+	}
+}
+
+func TestBreakpointSpecMatcher(t *testing.T) {
+	// The spec's typed variables apply to its breakpoints.
+	spec := &Spec{
+		Name: "typed",
+		Uses: []string{"match:typedVariables"},
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{
+							Target: "start",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Control{
+		Limit: 10,
+		BreakpointSpecs: map[string]*BreakpointSpec{
+			"n": {Message: Dwimjs(`{"n":"?n:number"}`)},
+		},
+	}
+	st := &State{
+		NodeName: "start",
+		Bs:       NewBindings(),
+	}
+	msgs := []interface{}{
+		Dwimjs(`{"n":"one"}`),
+		Dwimjs(`{"n":2}`),
+	}
+
+	walked, err := spec.Walk(ctx, st, msgs, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walked.StoppedBecause != BreakpointReached {
+		t.Fatal(walked.StoppedBecause)
+	}
+	if len(walked.Strides) != 1 {
+		t.Fatalf("took %d strides (not 1)", len(walked.Strides))
+	}
+}
@@ -0,0 +1,70 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// HashPrefix starts every Spec.Id computed by Spec.Hash.  The prefix
+// names the hash function.
+var HashPrefix = "sha256:"
+
+// Canonical returns a canonical serialization of the Spec.
+//
+// The serialization is JSON with the Spec's Id removed.  Map keys
+// (including node names) are sorted, and the child of each resolved
+// composite node is included inline (recursively).  Compile-only
+// things (like Actions and the PatternParser) are not included.
+//
+// Two Specs with the same canonical serialization behave the same
+// (given the same interpreters).  Note that Compile normalizes some
+// things (like the ErrorNode), so a Spec's canonical serialization
+// can change after Compile.
+func (spec *Spec) Canonical() ([]byte, error) {
+	return json.Marshal(spec.canonical())
+}
+
+// canonical returns a copy of the Spec that's ready for a canonical
+// serialization.
+func (spec *Spec) canonical() *Spec {
+	c := spec.Copy("")
+	c.Id = ""
+	for name, n := range spec.Nodes {
+		if n == nil || n.Child == nil {
+			continue
+		}
+		src := n.ChildSource.Copy()
+		if src == nil {
+			src = &SpecSource{}
+		}
+		src.Inline = n.Child.canonical()
+		c.Nodes[name].ChildSource = src
+	}
+	return c
+}
+
+// Hash returns a stable hash of the Spec's canonical serialization.
+// See Canonical.
+//
+// Compile sets the Spec's Id to this hash.
+func (spec *Spec) Hash() (string, error) {
+	js, err := spec.Canonical()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(js)
+	return HashPrefix + hex.EncodeToString(h[:]), nil
+}
@@ -0,0 +1,153 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// fullSpec uses (almost) every serializable Spec property.
+var fullSpec = `{
+  "name": "full",
+  "version": "1",
+  "doc": "A spec with lots of stuff.",
+  "paramSpecs": {"n": {"primitiveType": "number", "default": 2}},
+  "uses": ["something"],
+  "errorNode": "oops",
+  "actionErrorBranches": true,
+  "actionErrorNode": "oops",
+  "boot": {"interpreter": "x", "source": "boot"},
+  "toob": {"interpreter": "x", "source": {"toob": [1, 2]}},
+  "patternSyntax": "json",
+  "fanOut": true,
+  "regions": [{"name": "r", "start": "r0"}],
+  "regionMerge": "strict",
+  "migrations": [{"from": "0", "nodes": {"begin": "start"}, "defaults": {"x": [1]}}],
+  "nodes": {
+    "start": {
+      "entry": {"interpreter": "x", "source": "entry"},
+      "branching": {
+        "type": "message",
+        "modes": ["exhaustive"],
+        "fallback": "oops",
+        "branches": [{"pattern": "{\"likes\":\"?x\"}", "target": "start"}]
+      }
+    },
+    "r0": {},
+    "oops": {}
+  }
+}`
+
+func parseSpec(t *testing.T, js string) *Spec {
+	var spec Spec
+	if err := json.Unmarshal([]byte(js), &spec); err != nil {
+		t.Fatal(err)
+	}
+	return &spec
+}
+
+func TestSpecCopy(t *testing.T) {
+	spec := parseSpec(t, fullSpec)
+	if err := spec.ParsePatterns(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	canonical := func(s *Spec) string {
+		js, err := s.Canonical()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(js)
+	}
+
+	c := spec.Copy("")
+	if canonical(c) != canonical(spec) {
+		t.Fatalf("lost something:\n%s\n%s", canonical(c), canonical(spec))
+	}
+
+	// The copy should be deep.
+	p := c.Nodes["start"].Branches.Branches[0].Pattern.(map[string]interface{})
+	p["likes"] = "tacos"
+	c.ParamSpecs["n"] = ParamSpec{}
+	c.ToobSource.Source.(map[string]interface{})["toob"].([]interface{})[0] = 3
+	c.Uses[0] = "nothing"
+	c.Regions[0].Start = "start"
+	if canonical(c) == canonical(spec) {
+		t.Fatal("copy isn't deep enough")
+	}
+	if !strings.Contains(canonical(spec), `"?x"`) {
+		t.Fatal(canonical(spec))
+	}
+
+	if v := spec.Copy("2"); v.Version != "2" {
+		t.Fatal(v.Version)
+	}
+}
+
+func TestSpecHash(t *testing.T) {
+	ctx := context.Background()
+
+	compile := func(js string) *Spec {
+		spec := parseSpec(t, js)
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(spec.Id, HashPrefix) {
+			t.Fatal(spec.Id)
+		}
+		return spec
+	}
+
+	var (
+		a = compile(`{"name":"a","nodes":{"start":{"branching":{"branches":[{"pattern":{"x":1,"y":2},"target":"there"}]}},"there":{}}}`)
+
+		// Same as a but with different key orders.
+		b = compile(`{"nodes":{"there":{},"start":{"branching":{"branches":[{"target":"there","pattern":{"y":2,"x":1}}]}}},"name":"a"}`)
+
+		// Different pattern.
+		c = compile(`{"name":"a","nodes":{"start":{"branching":{"branches":[{"pattern":{"x":1,"y":3},"target":"there"}]}},"there":{}}}`)
+	)
+
+	if a.Id != b.Id {
+		t.Fatalf("%s != %s", a.Id, b.Id)
+	}
+	if a.Id == c.Id {
+		t.Fatalf("%s == %s", a.Id, c.Id)
+	}
+
+	// Compiling again doesn't change the Id.
+	id := a.Id
+	if err := a.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if a.Id != id {
+		t.Fatalf("%s != %s", a.Id, id)
+	}
+
+	// A copy has the same Id.
+	if a.Copy("").Id != id {
+		t.Fatal(a.Copy("").Id)
+	}
+
+	// A composite node's child contributes to the Id.
+	parent := func(x int) string {
+		js, _ := json.Marshal(x)
+		return `{"name":"p","nodes":{"start":{"child":{"inline":{"name":"c","nodes":{"start":{"branching":{"branches":[{"pattern":{"x":` + string(js) + `},"target":"start"}]}}}}}}}}`
+	}
+	if compile(parent(1)).Id == compile(parent(2)).Id {
+		t.Fatal("child didn't matter")
+	}
+}
@@ -0,0 +1,203 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultChildStart is the name of the initial node of a composite
+// node's child when the node doesn't specify a ChildStart.
+var DefaultChildStart = "start"
+
+// SpecSource aspires to hold the origin of a specification.
+//
+// Currently a source for a Spec can either be a name, a URL, or maybe
+// given explicitly as a string in an unspecified syntax.
+type SpecSource struct {
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	URL    string `json:"url,omitempty" yaml:"url,omitempty"`
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	Inline *Spec  `json:"inline,omitempty" yaml:",omitempty"`
+
+	// Hash, if not empty, is the Id (see Spec.Hash) of the Spec
+	// that was obtained from this source.  A machine's
+	// SpecSource can record this value to detect when the Spec
+	// has changed.
+	Hash string `json:"hash,omitempty" yaml:"hash,omitempty"`
+}
+
+// Copy makes a deep copy of the given SpecSource.
+func (s *SpecSource) Copy() *SpecSource {
+	if s == nil {
+		return nil
+	}
+	var inline *Spec
+	if s.Inline != nil {
+		inline = s.Inline.Copy("")
+	}
+	return &SpecSource{
+		Name:   s.Name,
+		URL:    s.URL,
+		Source: s.Source,
+		Inline: inline,
+		Hash:   s.Hash,
+	}
+}
+
+// Composite determines if the node embeds a child Spec.
+func (n *Node) Composite() bool {
+	return n.Child != nil || n.ChildSource != nil
+}
+
+func (n *Node) childStart() string {
+	if n.ChildStart == "" {
+		return DefaultChildStart
+	}
+	return n.ChildStart
+}
+
+// compileChild is called by Spec.Compile to compile the child (if
+// any) of a composite node.
+func (n *Node) compileChild(ctx context.Context, spec *Spec, name string, interpreters Interpreters, force bool) error {
+	if !n.Composite() {
+		return nil
+	}
+
+	if n.ChildSource != nil && n.ChildSource.Inline != nil && (force || n.Child == nil) {
+		n.Child = n.ChildSource.Inline
+	}
+
+	if n.Child == nil {
+		return &UnresolvedChild{spec, name}
+	}
+
+	if n.Action != nil || n.ActionSource != nil {
+		return errors.New("composite node '" + name + "' in spec '" + spec.Name + "' can't have an action")
+	}
+
+	if force || !n.Child.compiled {
+		if err := n.Child.Compile(ctx, interpreters, force); err != nil {
+			return errors.New(err.Error() + ": child of node: " + name)
+		}
+	}
+
+	if _, have := n.Child.Nodes[n.childStart()]; !have {
+		return &UnknownNode{n.Child, n.childStart()}
+	}
+
+	return nil
+}
+
+// stepComposite is Step for a composite node.
+//
+// The node's Branches (if any) are considered first.  If one of
+// those branches is followed, the machine leaves the composite node
+// (and its child).  Otherwise the child takes a Step with the
+// machine's Bindings.
+//
+// If the node has "message" branching and neither the node nor the
+// child consumed the pending message, then the message is consumed
+// anyway (as if this node were just a "message" node).
+func (s *Spec) stepComposite(ctx context.Context, n *Node, st *State, pending interface{}, c *Control, props StepProps) (*Stride, error) {
+	stride := NewStride()
+	stride.From = st.Copy()
+
+	bs := st.Bs
+
+	o := c.observing(ctx, stride, st.NodeName)
+	if o.on(StepStarted) {
+		o.report(&StepEvent{
+			Kind:    StepStarted,
+			Bs:      bs,
+			Message: pending,
+		})
+	}
+
+	if b := n.Branches; b != nil && (b.Type != "message" || pending != nil) {
+		to, spawned, consumed, err := b.consider(ctx, s, bs, pending, o, props)
+		if ne, is := err.(*NotExclusive); is {
+			ne.NodeName = st.NodeName
+		}
+		if err != nil {
+			return stride, err
+		}
+		if to != nil {
+			if consumed {
+				stride.Consumed = pending
+			}
+			for _, sp := range spawned {
+				stride.Spawned = append(stride.Spawned, sp.Copy())
+			}
+			stride.To = to.Copy()
+			if err := s.cross(ctx, n, st.NodeName, stride, o, c, props); err != nil {
+				stride.To = nil
+				return stride, err
+			}
+			o.transitioned(stride.To)
+			return stride, nil
+		}
+	}
+
+	// The parent's branches didn't pre-empt the child, so give
+	// the child a shot.
+
+	child := &State{
+		NodeName: n.childStart(),
+		Bs:       bs,
+	}
+	if st.Child != nil {
+		child.NodeName = st.Child.NodeName
+		child.Child = st.Child.Child
+	}
+
+	cs, err := n.Child.Step(ctx, child, pending, c, props)
+	if cs != nil {
+		stride.AddEvents(cs.Events)
+		stride.Consumed = cs.Consumed
+		for _, sp := range cs.Spawned {
+			stride.Spawned = append(stride.Spawned, s.adopt(st.NodeName, sp))
+		}
+		if cs.To != nil {
+			stride.To = s.adopt(st.NodeName, cs.To)
+		}
+	}
+	if err != nil {
+		return stride, err
+	}
+
+	if stride.To == nil && st.Child == nil {
+		// We entered the child but it didn't go anywhere.
+		// Remember that we're at the child's initial node.
+		stride.To = s.adopt(st.NodeName, child)
+	}
+
+	if stride.Consumed == nil && pending != nil && n.Branches != nil && n.Branches.Type == "message" {
+		stride.Consumed = pending
+	}
+
+	o.transitioned(stride.To)
+
+	return stride, nil
+}
+
+// adopt makes a State at the given composite node with the given
+// child State.  The child's Bindings are hoisted to the parent.
+func (s *Spec) adopt(nodeName string, child *State) *State {
+	return &State{
+		NodeName: nodeName,
+		Bs:       child.Bs.Copy(),
+		Child:    child.copyChild(),
+	}
+}
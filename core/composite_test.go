@@ -0,0 +1,171 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func compositeSpec() *Spec {
+	ack := &Spec{
+		Name: "ack",
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{
+							Pattern: Dwimjs(`{"send":"?x"}`),
+							Target:  "waiting",
+						},
+					},
+				},
+			},
+			"waiting": {
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{
+							Pattern: Dwimjs(`{"ack":"?x"}`),
+							Target:  "acked",
+						},
+					},
+				},
+			},
+			"acked": {},
+		},
+	}
+
+	return &Spec{
+		Name: "parent",
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Branches: []*Branch{
+						{
+							Target: "sending",
+						},
+					},
+				},
+			},
+			"sending": {
+				ChildSource: &SpecSource{
+					Inline: ack,
+				},
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{
+							Pattern: Dwimjs(`{"cancel":true}`),
+							Target:  "cancelled",
+						},
+					},
+				},
+			},
+			"cancelled": {},
+		},
+	}
+}
+
+func TestComposite(t *testing.T) {
+	spec := compositeSpec()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{
+		NodeName: "start",
+		Bs:       make(Bindings),
+	}
+
+	t.Run("child", func(t *testing.T) {
+		msgs := []interface{}{
+			Dwimjs(`{"send":"tacos"}`),
+			Dwimjs(`{"ack":"tacos"}`),
+		}
+		walked, err := spec.Walk(ctx, st, msgs, DefaultControl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to := walked.To()
+		if to.NodeName != "sending" || to.Child == nil || to.Child.NodeName != "acked" {
+			t.Fatalf("went to %s", to)
+		}
+		if to.Bs["?x"] != "tacos" {
+			t.Fatal(JS(to.Bs))
+		}
+		if 0 < len(walked.Remaining) {
+			t.Fatal(JS(walked.Remaining))
+		}
+	})
+
+	t.Run("pre-empt", func(t *testing.T) {
+		msgs := []interface{}{
+			Dwimjs(`{"send":"tacos"}`),
+			Dwimjs(`{"cancel":true}`),
+		}
+		walked, err := spec.Walk(ctx, st, msgs, DefaultControl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to := walked.To()
+		if to.NodeName != "cancelled" || to.Child != nil {
+			t.Fatalf("went to %s", to)
+		}
+	})
+
+	t.Run("serialization", func(t *testing.T) {
+		st := &State{
+			NodeName: "sending",
+			Bs:       Bindings{"?x": "chips"},
+			Child: &State{
+				NodeName: "waiting",
+			},
+		}
+		js, err := json.Marshal(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got State
+		if err = json.Unmarshal(js, &got); err != nil {
+			t.Fatal(err)
+		}
+		stride, err := spec.Step(ctx, &got, Dwimjs(`{"ack":"chips"}`), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stride.To == nil || stride.To.Child == nil || stride.To.Child.NodeName != "acked" {
+			t.Fatal(JS(stride))
+		}
+	})
+
+	t.Run("unresolved", func(t *testing.T) {
+		spec := compositeSpec()
+		spec.Nodes["sending"].ChildSource = &SpecSource{
+			Name: "ack",
+		}
+		err := spec.Compile(ctx, nil, true)
+		if _, is := err.(*UnresolvedChild); !is {
+			t.Fatalf("expected UnresolvedChild; got %v", err)
+		}
+	})
+}
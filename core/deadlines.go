@@ -0,0 +1,73 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"time"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+// DeadlineError occurs when an Action (or guard) takes longer than
+// its budget (Control.ActionTimeout).
+//
+// A DeadlineError is a context.DeadlineExceeded (via errors.Is).
+type DeadlineError struct {
+	Timeout time.Duration
+
+	// Err is the error (if any) that the Action returned.
+	Err error
+}
+
+func (e *DeadlineError) Error() string {
+	return "exceeded deadline of " + e.Timeout.String()
+}
+
+func (e *DeadlineError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports that a DeadlineError is a context.DeadlineExceeded.
+func (e *DeadlineError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// execWithin executes the Action with the given budget.
+//
+// A non-positive timeout means no budget.  If the Action exceeds its
+// budget, the returned error is a DeadlineError, even if the Action
+// (which might have ignored its context) didn't return an error.
+//
+// The Action's context is derived from the given context, so the
+// Action is also subject to the given context's deadline (if any).
+// Only exceeding the Action's own budget results in a DeadlineError.
+func execWithin(ctx context.Context, a Action, bs Bindings, props StepProps, timeout time.Duration) (*Execution, error) {
+	if timeout <= 0 {
+		return a.Exec(ctx, bs, props)
+	}
+
+	actx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	exe, err := a.Exec(actx, bs, props)
+
+	if actx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = &DeadlineError{
+			Timeout: timeout,
+			Err:     err,
+		}
+	}
+
+	return exe, err
+}
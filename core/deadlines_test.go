@@ -0,0 +1,196 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestDeadlines(t *testing.T) {
+	// stuck is an Action that waits for its context to be done.
+	stuck := &FuncAction{
+		F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	// slow is an Action that takes a while while ignoring its
+	// context.
+	slow := &FuncAction{
+		F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+			time.Sleep(20 * time.Millisecond)
+			return NewExecution(bs), nil
+		},
+	}
+
+	compile := func(t *testing.T, spec *Spec) {
+		if err := spec.Compile(context.Background(), nil, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := func() *State {
+		return &State{
+			NodeName: "start",
+			Bs:       NewBindings(),
+		}
+	}
+
+	t.Run("action", func(t *testing.T) {
+		spec := &Spec{
+			Name:                "test",
+			ActionErrorBranches: true,
+			Nodes: map[string]*Node{
+				"start": {
+					Action: stuck,
+					Branches: &Branches{
+						Branches: []*Branch{
+							{
+								Pattern: Dwimjs(`{"errorDetails":{"code":"action.deadlineExceeded"}}`),
+								Target:  "late",
+							},
+							{
+								Target: "start",
+							},
+						},
+					},
+				},
+				"late": {},
+			},
+		}
+		compile(t, spec)
+
+		c := DefaultControl.Copy()
+		c.ActionTimeout = 10 * time.Millisecond
+
+		walked, err := spec.Walk(context.Background(), start(), nil, c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to := walked.To()
+		if to.NodeName != "late" {
+			t.Fatal(JS(to))
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		// An Action that ignores its context but finishes
+		// late still exceeded its budget.
+		spec := &Spec{
+			Name:            "test",
+			ActionErrorNode: "error",
+			Nodes: map[string]*Node{
+				"start": {
+					Action: slow,
+				},
+			},
+		}
+		compile(t, spec)
+
+		c := DefaultControl.Copy()
+		c.ActionTimeout = time.Millisecond
+
+		walked, err := spec.Walk(context.Background(), start(), nil, c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		details, _ := walked.To().Bs["errorDetails"].(map[string]interface{})
+		if details["code"] != CodeActionDeadline {
+			t.Fatal(JS(walked.To()))
+		}
+	})
+
+	t.Run("guard", func(t *testing.T) {
+		spec := &Spec{
+			Name: "test",
+			Nodes: map[string]*Node{
+				"start": {
+					Branches: &Branches{
+						Branches: []*Branch{
+							{
+								Guard:  stuck,
+								Target: "there",
+							},
+						},
+					},
+				},
+				"there": {},
+			},
+		}
+		compile(t, spec)
+
+		c := DefaultControl.Copy()
+		c.ActionTimeout = 10 * time.Millisecond
+
+		walked, err := spec.Walk(context.Background(), start(), nil, c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		details, _ := walked.To().Bs["errorDetails"].(map[string]interface{})
+		if details["code"] != CodeGuardDeadline {
+			t.Fatal(JS(walked.To()))
+		}
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		spec := &Spec{
+			Name: "test",
+			Nodes: map[string]*Node{
+				"start": {
+					Action: slow,
+					Branches: &Branches{
+						Branches: []*Branch{
+							{
+								Target: "start",
+							},
+						},
+					},
+				},
+			},
+		}
+		compile(t, spec)
+
+		c := DefaultControl.Copy()
+		c.Limit = 1000
+		c.WalkTimeout = 50 * time.Millisecond
+
+		walked, err := spec.Walk(context.Background(), start(), []interface{}{"hello"}, c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if walked.StoppedBecause != DeadlineExceeded {
+			t.Fatal(walked.StoppedBecause)
+		}
+		if len(walked.Remaining) != 1 {
+			t.Fatal(walked.Remaining)
+		}
+		if len(walked.Strides) == 0 || 10 < len(walked.Strides) {
+			t.Fatal(len(walked.Strides))
+		}
+
+		js, err := json.Marshal(walked.StoppedBecause)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(js) != `"DeadlineExceeded"` {
+			t.Fatal(string(js))
+		}
+	})
+}
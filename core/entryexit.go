@@ -0,0 +1,139 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+const (
+	// PhaseEntry is the ActionError.Phase for an error from a
+	// node's Entry action.
+	PhaseEntry = "entry"
+
+	// PhaseExit is the ActionError.Phase for an error from a
+	// node's Exit action.
+	PhaseExit = "exit"
+)
+
+// compileEntryExit is called by Spec.Compile to compile the node's
+// EntrySource and ExitSource (if any).
+func (n *Node) compileEntryExit(ctx context.Context, name string, interpreters Interpreters, force bool) error {
+	if n.EntrySource != nil && (force || n.Entry == nil) {
+		entry, err := n.EntrySource.Compile(ctx, interpreters)
+		if err != nil {
+			return errors.New(err.Error() + ": entry of node: " + name)
+		}
+		n.Entry = entry
+	}
+	if n.ExitSource != nil && (force || n.Exit == nil) {
+		exit, err := n.ExitSource.Compile(ctx, interpreters)
+		if err != nil {
+			return errors.New(err.Error() + ": exit of node: " + name)
+		}
+		n.Exit = exit
+	}
+	return nil
+}
+
+// cross runs the Exit action (if any) of the given node and then the
+// Entry action (if any) of the node at the Stride's To State.
+//
+// This method is called when a Branch takes the machine from the
+// given node to a different node.  The actions' Bindings replace the
+// To State's Bindings.
+//
+// If an action returns an error and the Spec has
+// ActionErrorBranches, the error is bound (as for a node's Action),
+// and the transition proceeds.  Otherwise, if the Spec has an
+// ActionErrorNode, the machine goes there.  Otherwise cross returns
+// the error.
+func (s *Spec) cross(ctx context.Context, from *Node, fromName string, stride *Stride, o *observing, c *Control, props StepProps) error {
+	to := stride.To
+	if to == nil || to.NodeName == fromName {
+		return nil
+	}
+
+	run := func(a Action, src *ActionSource, nodeName, phase string, kind StepEventKind) (bool, error) {
+		exe, err := execWithin(ctx, a, to.Bs, props, c.ActionTimeout)
+		if o.on(kind) {
+			ev := &StepEvent{
+				Kind: kind,
+				Bs:   to.Bs,
+			}
+			if exe != nil {
+				ev.Result = exe.Bs
+			}
+			if err != nil {
+				ev.Error = err.Error()
+			}
+			o.report(ev)
+		}
+		if exe != nil {
+			o.addEvents(stride, exe.Events)
+		}
+		if err == nil {
+			if exe != nil && exe.Bs != nil {
+				to.Bs = exe.Bs
+			} else {
+				to.Bs = NewBindings()
+			}
+			return true, nil
+		}
+
+		ae := &ActionError{
+			Spec:     s,
+			NodeName: nodeName,
+			Phase:    phase,
+			Err:      err,
+		}
+		if src != nil {
+			ae.Interpreter = src.Interpreter
+		}
+		if to.Bs == nil {
+			to.Bs = NewBindings()
+		}
+		to.Bs.Extend("actionError", ae.Error())
+		to.Bs.Extend("error", ae.Error())
+		to.Bs.Extend("errorDetails", ErrorDetails(ae))
+
+		switch {
+		case s.ActionErrorBranches:
+			return true, nil
+		case s.ActionErrorNode != "":
+			stride.To = &State{
+				NodeName: s.ActionErrorNode,
+				Bs:       to.Bs,
+			}
+			return false, nil
+		}
+		return false, ae
+	}
+
+	if from.Exit != nil {
+		if ok, err := run(from.Exit, from.ExitSource, fromName, PhaseExit, ExitExecuted); !ok {
+			return err
+		}
+	}
+
+	if n, have := s.Nodes[to.NodeName]; have && n.Entry != nil {
+		if ok, err := run(n.Entry, n.EntrySource, to.NodeName, PhaseEntry, EntryExecuted); !ok {
+			return err
+		}
+	}
+
+	return nil
+}
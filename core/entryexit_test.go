@@ -0,0 +1,168 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func armedSpec() *Spec {
+	return &Spec{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Branches: []*Branch{
+						{
+							Target: "armed",
+						},
+					},
+				},
+			},
+			"armed": {
+				Entry: &FuncAction{
+					F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+						entries, _ := bs["entries"].(int)
+						e := NewExecution(bs.Copy().Extend("armed", true).Extend("entries", entries+1))
+						e.AddEmitted("armed")
+						return e, nil
+					},
+				},
+				Exit: &FuncAction{
+					F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+						bs = bs.Copy()
+						delete(bs, "armed")
+						e := NewExecution(bs)
+						e.AddEmitted("disarmed")
+						return e, nil
+					},
+				},
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{
+							Pattern: "ping",
+							Target:  "armed",
+						},
+						{
+							Pattern: "disarm",
+							Target:  "off",
+						},
+					},
+				},
+			},
+			"off": {},
+		},
+	}
+}
+
+func TestEntryExit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	walk := func(t *testing.T, spec *Spec, msgs ...interface{}) *Walked {
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		st := &State{
+			NodeName: "start",
+			Bs:       NewBindings(),
+		}
+		walked, err := spec.Walk(ctx, st, msgs, DefaultControl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return walked
+	}
+
+	t.Run("basic", func(t *testing.T) {
+		walked := walk(t, armedSpec())
+
+		to := walked.To()
+		if to.NodeName != "armed" {
+			t.Fatal(JS(walked))
+		}
+		if armed, _ := to.Bs["armed"].(bool); !armed {
+			t.Fatal(JS(to))
+		}
+		if 1 != len(walked.Strides[0].Emitted) {
+			t.Fatal(JS(walked.Strides[0]))
+		}
+	})
+
+	t.Run("messages", func(t *testing.T) {
+		walked := walk(t, armedSpec(), "ping", "disarm")
+
+		to := walked.To()
+		if to.NodeName != "off" {
+			t.Fatal(JS(walked))
+		}
+		if _, have := to.Bs["armed"]; have {
+			t.Fatal(JS(to))
+		}
+		// Going from "armed" back to "armed" doesn't enter
+		// again.
+		if n, _ := to.Bs["entries"].(int); n != 1 {
+			t.Fatal(JS(to))
+		}
+
+		var emitted []interface{}
+		walked.DoEmitted(func(x interface{}) error {
+			emitted = append(emitted, x)
+			return nil
+		})
+		if JS(emitted) != `["armed","disarmed"]` {
+			t.Fatal(JS(emitted))
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		spec := armedSpec()
+		spec.ActionErrorNode = "error"
+		spec.Nodes["armed"].Entry = &FuncAction{
+			F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+				return nil, errors.New("can't arm")
+			},
+		}
+
+		to := walk(t, spec).To()
+		if to.NodeName != "error" {
+			t.Fatal(JS(to))
+		}
+		details, _ := to.Bs["errorDetails"].(map[string]interface{})
+		if details["code"] != CodeActionException || details["phase"] != PhaseEntry || details["node"] != "armed" {
+			t.Fatal(JS(details))
+		}
+	})
+
+	t.Run("serialization", func(t *testing.T) {
+		js := `{"name":"test","nodes":{"armed":{"entry":{"interpreter":"x","source":"1"},"exit":{"interpreter":"y","source":"2"}}}}`
+		var spec Spec
+		if err := json.Unmarshal([]byte(js), &spec); err != nil {
+			t.Fatal(err)
+		}
+		n := spec.Nodes["armed"].Copy()
+		if n.EntrySource == nil || n.EntrySource.Interpreter != "x" {
+			t.Fatal(JS(n))
+		}
+		if n.ExitSource == nil || n.ExitSource.Interpreter != "y" {
+			t.Fatal(JS(n))
+		}
+	})
+}
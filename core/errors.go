@@ -14,12 +14,197 @@ package core
 
 // These errors are user errors, not internal errors.
 //
-// Probably should have a type just for user errors.
+// Each error type has a stable code (see the Code constants and
+// ErrorCode), and ErrorDetails gives a structured description of an
+// error suitable for bindings.  When Step or Walk sends a machine to
+// an error node, the bindings include "errorDetails" (along with the
+// traditional "error" string).
 
 import (
+	"context"
 	"errors"
+	"strconv"
 )
 
+// Error codes.  These strings are stable, so specs can match on them.
+const (
+	CodeActionException   = "action.exception"
+	CodeActionInterrupted = "action.interrupted"
+	CodeActionDeadline    = "action.deadlineExceeded"
+	CodeActionNoBranch    = "action.noBranch"
+	CodeGuardException    = "guard.exception"
+	CodeGuardInterrupted  = "guard.interrupted"
+	CodeGuardDeadline     = "guard.deadlineExceeded"
+	CodePattern           = "branch.pattern"
+	CodeTooManyBindingss  = "branch.tooManyBindings"
+	CodeNotExclusive      = "branch.notExclusive"
+	CodeRegionConflict    = "region.conflict"
+	CodeSpecNotCompiled   = "spec.notCompiled"
+	CodeUnknownNode       = "spec.unknownNode"
+	CodeUnresolvedChild   = "spec.unresolvedChild"
+	CodeUncompiledAction  = "spec.uncompiledAction"
+	CodeBadBranching      = "spec.badBranching"
+	CodeMigration         = "migration.failed"
+
+	// CodeInternal is the code for an error that doesn't have
+	// a code.
+	CodeInternal = "internal"
+)
+
+// Coded is an error with a stable code.
+type Coded interface {
+	error
+	Code() string
+}
+
+// ErrorCode returns the code for the given error.
+//
+// An error that isn't Coded (even when wrapped) gets CodeInternal.
+func ErrorCode(err error) string {
+	var c Coded
+	if errors.As(err, &c) {
+		return c.Code()
+	}
+	return CodeInternal
+}
+
+// detailer is an error that can contribute to ErrorDetails.
+type detailer interface {
+	details(m map[string]interface{})
+}
+
+// ErrorDetails returns a structured description of the given error.
+//
+// The result has a "code" (see ErrorCode) and a "cause" (the error's
+// string).  Depending on the error, the result might also have
+// properties like "node" and "interpreter".
+func ErrorDetails(err error) map[string]interface{} {
+	m := map[string]interface{}{
+		"code":  ErrorCode(err),
+		"cause": err.Error(),
+	}
+	var d detailer
+	if errors.As(err, &d) {
+		d.details(m)
+	}
+	return m
+}
+
+// codedError is a simple error with a code.
+type codedError struct {
+	code string
+	msg  string
+}
+
+func (e *codedError) Error() string {
+	return e.msg
+}
+
+func (e *codedError) Code() string {
+	return e.code
+}
+
+// InterruptedError is an error that an Interpreter can return when
+// an execution was interrupted.  Errors from a canceled Context are
+// also considered to be interruptions.
+type InterruptedError struct {
+	Msg string
+}
+
+func (e *InterruptedError) Error() string {
+	return e.Msg
+}
+
+// IsInterrupted determines if the given error represents an
+// interruption.
+func IsInterrupted(err error) bool {
+	var ie *InterruptedError
+	return errors.As(err, &ie) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// ActionError occurs when an Action (or a guard) returns an error.
+//
+// The Error() is the original error's string.
+type ActionError struct {
+	Spec        *Spec
+	NodeName    string
+	Interpreter string
+
+	// Guard is true if the Action was a Branch's guard.
+	Guard bool
+
+	// Phase is PhaseEntry or PhaseExit if the Action was a
+	// node's Entry or Exit action.
+	Phase string
+
+	Err error
+}
+
+func (e *ActionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ActionError) Code() string {
+	deadline := errors.Is(e.Err, context.DeadlineExceeded)
+	switch {
+	case e.Guard && deadline:
+		return CodeGuardDeadline
+	case e.Guard && IsInterrupted(e.Err):
+		return CodeGuardInterrupted
+	case e.Guard:
+		return CodeGuardException
+	case deadline:
+		return CodeActionDeadline
+	case IsInterrupted(e.Err):
+		return CodeActionInterrupted
+	}
+	return CodeActionException
+}
+
+func (e *ActionError) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+	if e.Interpreter != "" {
+		m["interpreter"] = e.Interpreter
+	}
+	if e.Phase != "" {
+		m["phase"] = e.Phase
+	}
+	var de *DeadlineError
+	if errors.As(e.Err, &de) {
+		m["timeout"] = de.Timeout.String()
+	}
+}
+
+// PatternError occurs when a Branch pattern can't be matched (as
+// opposed to not matching).
+type PatternError struct {
+	Spec     *Spec
+	NodeName string
+	Err      error
+}
+
+func (e *PatternError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PatternError) Code() string {
+	return CodePattern
+}
+
+func (e *PatternError) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+}
+
 // SpecNotCompiled occurs when a Spec is used (say via Step()) before
 // it has been Compile()ed.
 type SpecNotCompiled struct {
@@ -30,6 +215,10 @@ func (e *SpecNotCompiled) Error() string {
 	return `spec "` + e.Spec.Name + `" not compiled`
 }
 
+func (e *SpecNotCompiled) Code() string {
+	return CodeSpecNotCompiled
+}
+
 // UnknownNode occurs when a branch is followed and its target node is
 // not in the Spec.
 type UnknownNode struct {
@@ -41,6 +230,33 @@ func (e *UnknownNode) Error() string {
 	return `node "` + e.NodeName + `" not found in spec "` + e.Spec.Name + `"`
 }
 
+func (e *UnknownNode) Code() string {
+	return CodeUnknownNode
+}
+
+func (e *UnknownNode) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+}
+
+// UnresolvedChild occurs when Spec.Compile finds a composite node
+// whose ChildSource hasn't been resolved to a Child Spec.
+type UnresolvedChild struct {
+	Spec     *Spec
+	NodeName string
+}
+
+func (e *UnresolvedChild) Error() string {
+	return `unresolved child at node "` + e.NodeName + `" in spec "` + e.Spec.Name + `"`
+}
+
+func (e *UnresolvedChild) Code() string {
+	return CodeUnresolvedChild
+}
+
+func (e *UnresolvedChild) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+}
+
 // UncompiledAction occurs when an ActionSource execution is attempted
 // but that ActionSource hasn't been Compile()ed.  Usually, this
 // compilation happens as part of Spec.Compile().
@@ -53,6 +269,14 @@ func (e *UncompiledAction) Error() string {
 	return `uncompiled action at node "` + e.NodeName + `" in spec "` + e.Spec.Name + `"`
 }
 
+func (e *UncompiledAction) Code() string {
+	return CodeUncompiledAction
+}
+
+func (e *UncompiledAction) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+}
+
 // BadBranching occurs when somebody the a Spec.Branches isn't right.
 //
 // For example, a Branch with an action must have braching type
@@ -67,6 +291,47 @@ func (e *BadBranching) Error() string {
 		`has "message" branching and an action`
 }
 
-// TooManyBindingss occurs when a guard returns more than one set of
-// bindings.
-var TooManyBindingss = errors.New("too many bindingss")
+func (e *BadBranching) Code() string {
+	return CodeBadBranching
+}
+
+func (e *BadBranching) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+}
+
+// NotExclusive occurs when more than one Branch of an Exclusive
+// Branches matched.
+type NotExclusive struct {
+	Spec     *Spec
+	NodeName string
+
+	// Branches are the indexes of the Branches that matched.
+	Branches []int
+}
+
+func (e *NotExclusive) Error() string {
+	s := `exclusive branching at node "` + e.NodeName + `" in spec "` + e.Spec.Name + `" ` +
+		`matched branches`
+	for _, i := range e.Branches {
+		s += " " + strconv.Itoa(i)
+	}
+	return s
+}
+
+func (e *NotExclusive) Code() string {
+	return CodeNotExclusive
+}
+
+func (e *NotExclusive) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+	// Patterns (and interpreters) expect JSON-ish values.
+	branches := make([]interface{}, len(e.Branches))
+	for i, n := range e.Branches {
+		branches[i] = float64(n)
+	}
+	m["branches"] = branches
+}
+
+// TooManyBindingss occurs when a branch pattern match returns more
+// than one set of bindings and the Spec doesn't allow FanOut.
+var TooManyBindingss error = &codedError{CodeTooManyBindingss, "too many bindingss"}
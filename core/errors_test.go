@@ -0,0 +1,232 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestErrorCodes(t *testing.T) {
+	spec := &Spec{Name: "test"}
+
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{TooManyBindingss, CodeTooManyBindingss},
+		{&UnknownNode{spec, "there"}, CodeUnknownNode},
+		{&BadBranching{spec, "here"}, CodeBadBranching},
+		{&UncompiledAction{spec, "here"}, CodeUncompiledAction},
+		{&SpecNotCompiled{spec}, CodeSpecNotCompiled},
+		{&ActionError{Spec: spec, NodeName: "here", Err: errors.New("oops")}, CodeActionException},
+		{&ActionError{Spec: spec, NodeName: "here", Err: &InterruptedError{"timeout"}}, CodeActionInterrupted},
+		{&ActionError{Spec: spec, NodeName: "here", Err: context.Canceled}, CodeActionInterrupted},
+		{&ActionError{Spec: spec, NodeName: "here", Err: &DeadlineError{Timeout: time.Second}}, CodeActionDeadline},
+		{&ActionError{Spec: spec, NodeName: "here", Guard: true, Err: context.DeadlineExceeded}, CodeGuardDeadline},
+		{&ActionError{Spec: spec, NodeName: "here", Guard: true, Err: errors.New("oops")}, CodeGuardException},
+		{fmt.Errorf("wrapped: %w", &BadBranching{spec, "here"}), CodeBadBranching},
+		{errors.New("who knows"), CodeInternal},
+	} {
+		t.Run(tc.code, func(t *testing.T) {
+			if code := ErrorCode(tc.err); code != tc.code {
+				t.Fatalf("got %s instead of %s", code, tc.code)
+			}
+			details := ErrorDetails(tc.err)
+			if details["code"] != tc.code {
+				t.Fatal(JS(details))
+			}
+			if details["cause"] != tc.err.Error() {
+				t.Fatal(JS(details))
+			}
+		})
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	failing := func(err error) *FuncAction {
+		return &FuncAction{
+			F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+				return nil, err
+			},
+		}
+	}
+
+	walk := func(t *testing.T, spec *Spec, pendings ...interface{}) *State {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		st := &State{
+			NodeName: "start",
+			Bs:       NewBindings(),
+		}
+		walked, err := spec.Walk(ctx, st, pendings, DefaultControl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to := walked.To()
+		if to == nil {
+			t.Fatal("went nowhere")
+		}
+		return to
+	}
+
+	check := func(t *testing.T, to *State, code, node string) map[string]interface{} {
+		details, is := to.Bs["errorDetails"].(map[string]interface{})
+		if !is {
+			t.Fatal(JS(to.Bs))
+		}
+		if details["code"] != code {
+			t.Fatalf("got code %v instead of %s", details["code"], code)
+		}
+		if details["node"] != node {
+			t.Fatalf("got node %v instead of %s", details["node"], node)
+		}
+		if _, have := to.Bs["error"]; !have {
+			t.Fatal("no error: " + JS(to.Bs))
+		}
+		return details
+	}
+
+	t.Run("action", func(t *testing.T) {
+		spec := &Spec{
+			Name:            "test",
+			ActionErrorNode: "error",
+			Nodes: map[string]*Node{
+				"start": {
+					Action: failing(errors.New("something terrible happened")),
+				},
+			},
+		}
+		to := walk(t, spec)
+		if to.NodeName != "error" {
+			t.Fatal(to.NodeName)
+		}
+		details := check(t, to, CodeActionException, "start")
+		if details["cause"] != "something terrible happened" {
+			t.Fatal(JS(details))
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		spec := &Spec{
+			Name:            "test",
+			ActionErrorNode: "error",
+			Nodes: map[string]*Node{
+				"start": {
+					Action: failing(&InterruptedError{"timeout"}),
+				},
+			},
+		}
+		check(t, walk(t, spec), CodeActionInterrupted, "start")
+	})
+
+	t.Run("handled", func(t *testing.T) {
+		spec := &Spec{
+			Name:                "test",
+			ActionErrorBranches: true,
+			Nodes: map[string]*Node{
+				"start": {
+					Action: failing(errors.New("nope")),
+					Branches: &Branches{
+						Branches: []*Branch{
+							{
+								Pattern: Dwimjs(`{"errorDetails":{"code":"action.exception"}}`),
+								Target:  "handled",
+							},
+						},
+					},
+				},
+				"handled": {},
+			},
+		}
+		if to := walk(t, spec); to.NodeName != "handled" {
+			t.Fatal(to.NodeName)
+		}
+	})
+
+	t.Run("guard", func(t *testing.T) {
+		spec := &Spec{
+			Name: "test",
+			Nodes: map[string]*Node{
+				"start": {
+					Branches: &Branches{
+						Branches: []*Branch{
+							{
+								Guard:  failing(errors.New("bad guard")),
+								Target: "there",
+							},
+						},
+					},
+				},
+				"there": {},
+			},
+		}
+		check(t, walk(t, spec), CodeGuardException, "start")
+	})
+
+	t.Run("tooManyBindingss", func(t *testing.T) {
+		spec := &Spec{
+			Name: "test",
+			Nodes: map[string]*Node{
+				"start": {
+					Branches: &Branches{
+						Type: "message",
+						Branches: []*Branch{
+							{
+								Pattern: Dwimjs(`["?x"]`),
+								Target:  "there",
+							},
+						},
+					},
+				},
+				"there": {},
+			},
+		}
+		check(t, walk(t, spec, Dwimjs(`[1,2]`)), CodeTooManyBindingss, "start")
+	})
+
+	t.Run("noBranch", func(t *testing.T) {
+		spec := &Spec{
+			Name: "test",
+			Nodes: map[string]*Node{
+				"start": {
+					Action: &FuncAction{
+						F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+							return NewExecution(bs), nil
+						},
+					},
+					Branches: &Branches{
+						Branches: []*Branch{
+							{
+								Pattern: Dwimjs(`{"never":"?x"}`),
+								Target:  "there",
+							},
+						},
+					},
+				},
+				"there": {},
+			},
+		}
+		check(t, walk(t, spec), CodeActionNoBranch, "start")
+	})
+}
@@ -0,0 +1,224 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"sort"
+	"strings"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+// MinIndexedBranches is the minimum number of Branches with a
+// constant for the same property that justifies a branchIndex.
+var MinIndexedBranches = 2
+
+// branchIndex helps Branches.consider skip the Branches with patterns
+// that can't possibly match a message.
+//
+// The index uses one top-level property (key).  A Branch whose
+// pattern has a constant (string, number, or boolean) value for that
+// property can only match a message with that value for that
+// property.  All other Branches are candidates for every message.
+//
+// The candidate lists preserve the Branches' order, so the index
+// doesn't change which Branch is taken.
+type branchIndex struct {
+	// key is the property used to discriminate.
+	key string
+
+	// hits maps each constant value for key to the indexes of
+	// the Branches that could match a message with that value.
+	hits map[interface{}][]int
+
+	// misses has the indexes of the Branches that could match a
+	// message that doesn't have a value (in hits) for key.
+	misses []int
+
+	// n is the number of Branches when the index was built.
+	n int
+}
+
+// indexConstant returns the (normalized) constant value (if any) that
+// a pattern requires for a property.
+func indexConstant(x interface{}) (interface{}, bool) {
+	switch vv := x.(type) {
+	case string:
+		if DefaultMatcher.IsVariable(vv) {
+			return nil, false
+		}
+		return vv, true
+	case bool:
+		return vv, true
+	case float64:
+		if vv != vv { // NaN
+			return nil, false
+		}
+		return vv, true
+	case int:
+		return float64(vv), true
+	}
+	return nil, false
+}
+
+// indexable determines if matching the given pattern can't return an
+// error.  A Branch that's skipped by an index must not be one that
+// would have returned an error when matched.
+func indexable(x interface{}) bool {
+	switch vv := x.(type) {
+	case nil, bool, float64, int:
+		return true
+	case string:
+		// A bad regular expression or glob is an error.  See
+		// Matcher.StringPatterns.
+		return !strings.HasPrefix(vv, "?"+RegexpOp) && !strings.HasPrefix(vv, "?"+GlobOp)
+	case map[string]interface{}:
+		// An operator (see Matcher.Operators) or an array mode
+		// (see Matcher.ArrayModes) isn't a property.
+		if IsOperator(vv) || IsArrayMode(vv) {
+			return false
+		}
+		for k, v := range vv {
+			// A path key (see Matcher.PathKeys) isn't a
+			// top-level property.
+			if DefaultMatcher.IsVariable(k) || IsPathKey(k) || !indexable(v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		vars := 0
+		for _, y := range vv {
+			if s, is := y.(string); is && DefaultMatcher.IsVariable(s) {
+				vars++
+			}
+			if !indexable(y) {
+				return false
+			}
+		}
+		return vars <= 1
+	}
+	return false
+}
+
+// buildIndex makes a branchIndex for message Branches.
+//
+// Returns nil if an index wouldn't help.
+func (b *Branches) buildIndex() *branchIndex {
+	if b == nil || b.Type != "message" {
+		return nil
+	}
+
+	// Count the constants for each top-level property.
+	counts := make(map[string]int)
+	for _, br := range b.Branches {
+		if br == nil {
+			continue
+		}
+		m, is := br.Pattern.(map[string]interface{})
+		if !is || !indexable(m) {
+			continue
+		}
+		for k, v := range m {
+			if _, is := indexConstant(v); is {
+				counts[k]++
+			}
+		}
+	}
+
+	var key string
+	for k, n := range counts {
+		if counts[key] < n || (counts[key] == n && k < key) {
+			key = k
+		}
+	}
+	if counts[key] < MinIndexedBranches {
+		return nil
+	}
+
+	idx := &branchIndex{
+		key:    key,
+		hits:   make(map[interface{}][]int),
+		misses: make([]int, 0, len(b.Branches)),
+		n:      len(b.Branches),
+	}
+
+	for i, br := range b.Branches {
+		var (
+			c           interface{}
+			constrained bool
+		)
+		if br != nil {
+			if m, is := br.Pattern.(map[string]interface{}); is && indexable(m) {
+				c, constrained = indexConstant(m[key])
+			}
+		}
+		if constrained {
+			idx.hits[c] = append(idx.hits[c], i)
+		} else {
+			idx.misses = append(idx.misses, i)
+		}
+	}
+
+	// Every candidate list includes the misses.
+	for c, is := range idx.hits {
+		is = append(is, idx.misses...)
+		sort.Ints(is)
+		idx.hits[c] = is
+	}
+
+	return idx
+}
+
+// candidates returns the indexes (in order) of the given Branches
+// that could match the given message.
+//
+// Returns nil if every Branch is a candidate (including when the
+// Branches have changed since the index was built).
+func (idx *branchIndex) candidates(b *Branches, msg interface{}) []int {
+	if idx == nil || idx.n != len(b.Branches) {
+		return nil
+	}
+	m, is := msg.(map[string]interface{})
+	if !is {
+		return idx.misses
+	}
+	c, is := InterestValue(m[idx.key])
+	if !is {
+		return idx.misses
+	}
+	if is, have := idx.hits[c]; have {
+		return is
+	}
+	return idx.misses
+}
+
+// InterestValue normalizes a message's property value for comparison
+// with the constants in patterns (and Interest.Values).
+//
+// Returns false if the value can't equal any such constant.
+func InterestValue(x interface{}) (interface{}, bool) {
+	switch vv := x.(type) {
+	case string, bool, float64:
+		return vv, true
+	case int:
+		return float64(vv), true
+	case int64:
+		return float64(vv), true
+	case int32:
+		return float64(vv), true
+	case float32:
+		return float64(vv), true
+	}
+	return nil, false
+}
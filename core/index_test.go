@@ -0,0 +1,260 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestBranchIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	spec := parseSpec(t, `{
+  "name": "indexed",
+  "nodes": {
+    "listen": {
+      "branching": {
+        "type": "message",
+        "branches": [
+          {"pattern": {"type": "motion", "room": "?r"}, "target": "motion"},
+          {"pattern": {"type": "door", "state": "open"}, "target": "open"},
+          {"pattern": {"kind": "?k"}, "target": "kind"},
+          {"pattern": {"type": "door"}, "target": "door"},
+          {"pattern": {"type": 1}, "target": "one"},
+          {"pattern": {"type": true, "?x": 1}, "target": "bad"},
+          {"target": "other"}
+        ]
+      }
+    },
+    "motion": {}, "open": {}, "kind": {}, "door": {}, "one": {}, "bad": {}, "other": {}
+  }
+}`)
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	branches := spec.Nodes["listen"].Branches
+	if branches.index == nil || branches.index.key != "type" {
+		t.Fatal("no index for 'type'")
+	}
+
+	for _, tc := range []struct {
+		msg    interface{}
+		target string
+	}{
+		{Dwimjs(`{"type":"motion","room":"den"}`), "motion"},
+		{Dwimjs(`{"type":"door","state":"open"}`), "open"},
+		{Dwimjs(`{"type":"door","state":"closed"}`), "door"},
+		{Dwimjs(`{"type":"door","kind":"x"}`), "kind"},
+		{map[string]interface{}{"type": 1}, "one"},
+		{Dwimjs(`{"type":1}`), "one"},
+		// The bad pattern (with a property variable along
+		// with another property) isn't indexed, so it still
+		// reports its problem.
+		{Dwimjs(`{"type":"?x"}`), ""},
+		{Dwimjs(`{"type":"cat"}`), ""},
+		{Dwimjs(`{"type":["door"]}`), ""},
+		{"door", "other"},
+	} {
+		for _, level := range []TraceLevel{TraceOff, TraceAll} {
+			t.Run(JS(tc.msg)+"/"+string(level), func(t *testing.T) {
+				st := &State{
+					NodeName: "listen",
+					Bs:       NewBindings(),
+				}
+				c := &Control{
+					Limit:      10,
+					TraceLevel: level,
+				}
+				stride, err := spec.Step(ctx, st, tc.msg, c, nil)
+				if tc.target == "" {
+					if err == nil {
+						t.Fatal("expected a pattern error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stride.To == nil || stride.To.NodeName != tc.target {
+					t.Fatal(JS(stride))
+				}
+			})
+		}
+	}
+}
+
+func TestIndexableOperators(t *testing.T) {
+	// An operator (see Matcher.Operators) looks like a property
+	// with a constant, but it isn't one.
+	for _, js := range []string{
+		`{"$not":"door"}`,
+		`{"$or":["door","motion"]}`,
+		`{"type":{"$not":"door"}}`,
+	} {
+		if indexable(Dwimjs(js)) {
+			t.Fatal(js)
+		}
+	}
+	if disjoint(Dwimjs(`{"$not":"door"}`), Dwimjs(`{"$not":"motion"}`)) {
+		t.Fatal("operators aren't disjoint")
+	}
+}
+
+func TestIndexablePathKeys(t *testing.T) {
+	// A path key (see Matcher.PathKeys) looks like a top-level
+	// property, but it isn't one.
+	if indexable(Dwimjs(`{"/type":"door"}`)) {
+		t.Fatal("path key is indexable")
+	}
+}
+
+func TestIndexableArrayModes(t *testing.T) {
+	// An array mode (see Matcher.ArrayModes) with bad options is
+	// an error, so it can't be skipped.
+	if indexable(Dwimjs(`{"xs":{"$array":{"min":3,"max":2}}}`)) {
+		t.Fatal("array mode is indexable")
+	}
+	if disjoint(Dwimjs(`{"$array":{"max":1}}`), Dwimjs(`{"$array":{"min":2}}`)) {
+		t.Fatal("disjoint shouldn't consider array modes")
+	}
+}
+
+func TestInterest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	spec := parseSpec(t, `{
+  "name": "interest",
+  "nodes": {
+    "keyed": {"branching": {"type": "message", "branches": [
+      {"pattern": {"type": "motion"}, "target": "keyed"},
+      {"pattern": {"type": 2, "x": "?x"}, "target": "keyed"}]}},
+    "open": {"branching": {"type": "message", "branches": [
+      {"pattern": {"type": "motion"}, "target": "keyed"},
+      {"pattern": {"type": "door"}, "target": "keyed"},
+      {"pattern": {"x": "?x"}, "target": "keyed"}]}},
+    "exhaustive": {"branching": {"type": "message", "modes": ["exhaustive"], "branches": [
+      {"pattern": {"type": "motion"}, "target": "keyed"},
+      {"pattern": {"type": "door"}, "target": "keyed"}]}},
+    "bindings": {"branching": {"branches": [{"pattern": {"ready": true}, "target": "keyed"}]}},
+    "terminal": {}
+  }
+}`)
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	interest := func(node string) *Interest {
+		return spec.Interest(&State{NodeName: node, Bs: NewBindings()})
+	}
+
+	i := interest("keyed")
+	if i.Any || i.Key != "type" || len(i.Values) != 2 {
+		t.Fatal(JS(i))
+	}
+	for msg, could := range map[string]bool{
+		`{"type":"motion"}`: true,
+		`{"type":2}`:        true,
+		`{"type":"door"}`:   false,
+		`{"x":1}`:           false,
+		`"motion"`:          false,
+	} {
+		if i.Could(Dwimjs(msg)) != could {
+			t.Fatal(msg)
+		}
+	}
+	if !i.Could(map[string]interface{}{"type": 2}) {
+		t.Fatal("int")
+	}
+
+	for _, node := range []string{"open", "exhaustive", "missing"} {
+		if !interest(node).Any {
+			t.Fatal(node)
+		}
+	}
+	for _, node := range []string{"bindings", "terminal"} {
+		if !interest(node).None() {
+			t.Fatal(node)
+		}
+	}
+}
+
+func benchmarkBranchIndex(b *testing.B, indexed bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const n = 12
+
+	branches := make([]*Branch, 0, n)
+	for i := 0; i < n; i++ {
+		branches = append(branches, &Branch{
+			Pattern: Dwimjs(`{"type":"t` + strconv.Itoa(i) + `","device":"?d","level":"?level"}`),
+			Target:  "listen",
+		})
+	}
+
+	spec := &Spec{
+		Name: "bench",
+		Nodes: map[string]*Node{
+			"listen": {
+				Branches: &Branches{
+					Type:     "message",
+					Branches: branches,
+				},
+			},
+		},
+	}
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		b.Fatal(err)
+	}
+	if !indexed {
+		spec.Nodes["listen"].Branches.index = nil
+	}
+
+	msgs := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, Dwimjs(`{"type":"t`+strconv.Itoa(i)+`","device":"d1","level":3}`))
+	}
+
+	st := &State{
+		NodeName: "listen",
+		Bs:       NewBindings(),
+	}
+	c := &Control{
+		Limit:      10,
+		TraceLevel: TraceOff,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := spec.Step(ctx, st, msgs[i%n], c, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBranchIndex(b *testing.B) {
+	benchmarkBranchIndex(b, true)
+}
+
+func BenchmarkBranchNoIndex(b *testing.B) {
+	benchmarkBranchIndex(b, false)
+}
@@ -0,0 +1,112 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+// Interest describes the messages that could affect a machine in a
+// given State.  See Spec.Interest.
+//
+// When Any is false and Key is empty, no message could affect the
+// machine.
+type Interest struct {
+	// Any is true when any message could affect the machine.
+	Any bool `json:"any,omitempty"`
+
+	// Key (if not empty) is a top-level message property.  Only
+	// a message with one of the Values for that property could
+	// affect the machine.
+	Key    string        `json:"key,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+// AnyInterest is the Interest of a machine that could be affected by
+// any message.
+var AnyInterest = &Interest{Any: true}
+
+// None reports whether no message could affect the machine.
+func (i *Interest) None() bool {
+	return !i.Any && i.Key == ""
+}
+
+// Could reports whether the given message could affect the machine.
+func (i *Interest) Could(msg interface{}) bool {
+	if i.Any {
+		return true
+	}
+	if i.Key == "" {
+		return false
+	}
+	m, is := msg.(map[string]interface{})
+	if !is {
+		return false
+	}
+	x, is := InterestValue(m[i.Key])
+	if !is {
+		return false
+	}
+	for _, v := range i.Values {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Interest determines the messages that could affect a machine in the
+// given State.
+//
+// A machine at a node with "message" Branches is interested in the
+// messages that could match one of those Branches.  If the node's
+// index (see Spec.Compile) can't rule out any message, any message
+// could matter.  Exhaustive Branches (which have a fallback) also
+// care about any message.
+//
+// A machine at a terminal node or at a node with "bindings" Branches
+// (and no Action) isn't interested in any message.  This case
+// assumes that the machine's last Walk ended with StoppedBecause
+// Done, so the machine can't move without a message.
+//
+// Anything else (an Action, a composite node, Regions, or an unknown
+// node) could be affected by any message.
+func (s *Spec) Interest(st *State) *Interest {
+	if st == nil || !s.compiled || 0 < len(s.Regions) {
+		return AnyInterest
+	}
+
+	n, have := s.Nodes[st.NodeName]
+	if !have || n == nil || n.Child != nil || n.Action != nil || n.ActionSource != nil {
+		return AnyInterest
+	}
+
+	b := n.Branches
+	if b == nil || len(b.Branches) == 0 || b.Type != "message" {
+		return &Interest{}
+	}
+
+	if b.Modes.Has(Exhaustive) {
+		return AnyInterest
+	}
+
+	idx := b.index
+	if idx == nil || idx.n != len(b.Branches) || 0 < len(idx.misses) {
+		return AnyInterest
+	}
+
+	i := &Interest{
+		Key:    idx.key,
+		Values: make([]interface{}, 0, len(idx.hits)),
+	}
+	for v := range idx.hits {
+		i.Values = append(i.Values, v)
+	}
+	return i
+}
@@ -0,0 +1,99 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+// MatcherFeaturePrefix starts a Spec.Uses feature tag that configures
+// the Spec's Matcher.
+//
+// The tag "match:F" turns on the feature F, and "match:-F" turns it
+// off.  The features are the keys of MatcherFeatures.  For example, a
+// Spec with Uses ["match:typedVariables","match:-inequalities"]
+// matches its branch patterns with typed variables but without
+// inequalities.
+const MatcherFeaturePrefix = "match:"
+
+// MatcherFeatures maps a feature name (see MatcherFeaturePrefix) to
+// its Matcher switch.
+var MatcherFeatures = map[string]func(m *Matcher) *bool{
+	"propertyVariables":            func(m *Matcher) *bool { return &m.AllowPropertyVariables },
+	"checkForBadPropertyVariables": func(m *Matcher) *bool { return &m.CheckForBadPropertyVariables },
+	"inequalities":                 func(m *Matcher) *bool { return &m.Inequalities },
+	"stringPatterns":               func(m *Matcher) *bool { return &m.StringPatterns },
+	"typedVariables":               func(m *Matcher) *bool { return &m.TypedVariables },
+	"operators":                    func(m *Matcher) *bool { return &m.Operators },
+	"pathKeys":                     func(m *Matcher) *bool { return &m.PathKeys },
+	"arrayModes":                   func(m *Matcher) *bool { return &m.ArrayModes },
+}
+
+// UsesMatcher returns the Matcher declared by the given feature tags
+// (see MatcherFeaturePrefix) along with any problems with those tags.
+//
+// A feature that isn't mentioned has its setting from DefaultMatcher.
+// When no tag mentions a feature, the result is DefaultMatcher itself,
+// so such a Spec follows any changes to DefaultMatcher.
+//
+// An unknown feature is ignored, and a feature that's turned both on
+// and off gets the last setting.  Each is reported as a problem.
+func UsesMatcher(uses []string) (*Matcher, []error) {
+	var (
+		m        *Matcher
+		problems []error
+		settings = make(map[string]string)
+	)
+	for _, tag := range uses {
+		if !strings.HasPrefix(tag, MatcherFeaturePrefix) {
+			continue
+		}
+		name := tag[len(MatcherFeaturePrefix):]
+		on := !strings.HasPrefix(name, "-")
+		if !on {
+			name = name[1:]
+		}
+		sw, have := MatcherFeatures[name]
+		if !have {
+			problems = append(problems, errors.New("unknown matcher feature '"+tag+"'"))
+			continue
+		}
+		if had, have := settings[name]; have && had != tag {
+			problems = append(problems, errors.New("conflicting matcher features '"+had+"' and '"+tag+"'"))
+		}
+		settings[name] = tag
+		if m == nil {
+			c := *DefaultMatcher
+			m = &c
+		}
+		*sw(m) = on
+	}
+	if m == nil {
+		m = DefaultMatcher
+	}
+	return m, problems
+}
+
+// Matcher returns the Matcher for the Spec's branch patterns.
+//
+// Compile determines this Matcher from the Spec's Uses (see
+// UsesMatcher).  Before then, the Matcher is DefaultMatcher.
+func (spec *Spec) Matcher() *Matcher {
+	if spec.matcher == nil {
+		return DefaultMatcher
+	}
+	return spec.matcher
+}
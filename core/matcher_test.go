@@ -0,0 +1,127 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestSpecMatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The same branches with and without typed variables.  With
+	// them, "?n:number" only matches a number.  Without them,
+	// it's just a variable with an odd name.
+	js := `{
+  "name": "typed",
+  "uses": [%s],
+  "nodes": {
+    "start": {
+      "branching": {
+        "type": "message",
+        "branches": [
+          {"pattern": {"n": "?n:number"}, "target": "number"},
+          {"pattern": {"n": "?n"}, "target": "other"}
+        ]
+      }
+    },
+    "number": {},
+    "other": {}
+  }
+}`
+
+	step := func(t *testing.T, spec *Spec) string {
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		st := &State{
+			NodeName: "start",
+			Bs:       NewBindings(),
+		}
+		stride, err := spec.Step(ctx, st, Dwimjs(`{"n":"ten"}`), &Control{Limit: 10}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stride.To == nil {
+			t.Fatal("didn't move")
+		}
+		return stride.To.NodeName
+	}
+
+	t.Run("typed", func(t *testing.T) {
+		spec := parseSpec(t, fmt.Sprintf(js, `"match:typedVariables"`))
+		if at := step(t, spec); at != "other" {
+			t.Fatal(at)
+		}
+		if !spec.Matcher().TypedVariables {
+			t.Fatal("spec's matcher doesn't have typed variables")
+		}
+		if DefaultMatcher.TypedVariables {
+			t.Fatal("DefaultMatcher was modified")
+		}
+		if c := spec.Copy(""); c.Matcher() != spec.Matcher() {
+			t.Fatal("Copy didn't share the matcher")
+		}
+	})
+
+	t.Run("untyped", func(t *testing.T) {
+		spec := parseSpec(t, fmt.Sprintf(js, `"match:-typedVariables"`))
+		if at := step(t, spec); at != "number" {
+			t.Fatal(at)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		spec := parseSpec(t, fmt.Sprintf(js, `"other:feature"`))
+		if at := step(t, spec); at != "number" {
+			t.Fatal(at)
+		}
+		if spec.Matcher() != DefaultMatcher {
+			t.Fatal("spec without matcher features doesn't use DefaultMatcher")
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		spec := parseSpec(t, fmt.Sprintf(js, `"match:operators","match:-operators"`))
+		if err := spec.Compile(ctx, nil, true); err == nil {
+			t.Fatal("expected a conflict")
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		spec := parseSpec(t, fmt.Sprintf(js, `"match:telepathy"`))
+		if err := spec.Compile(ctx, nil, true); err == nil {
+			t.Fatal("expected an unknown feature")
+		}
+	})
+}
+
+func TestUsesMatcher(t *testing.T) {
+	m, problems := UsesMatcher([]string{"match:operators", "match:-inequalities", "match:operators"})
+	if 0 < len(problems) {
+		t.Fatal(problems)
+	}
+	if !m.Operators || m.Inequalities || !m.AllowPropertyVariables {
+		t.Fatal(m)
+	}
+
+	if _, problems = UsesMatcher([]string{"match:-inequalities", "match:inequalities", "match:nope"}); len(problems) != 2 {
+		t.Fatal(problems)
+	}
+}
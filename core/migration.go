@@ -0,0 +1,282 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+// Migration describes how to move a machine's State from an older
+// version of a Spec to the Spec that declares the Migration.  See
+// Spec.Migrations and Spec.Migrate.
+//
+// The steps are applied in this order: Nodes, Rename, Remove,
+// Defaults, and then Transform.
+type Migration struct {
+	// From is the Version of the old Spec.  An empty From
+	// applies to every old version.
+	From string `json:"from,omitempty" yaml:"from,omitempty"`
+
+	// Nodes maps old node names to new node names.  Region
+	// nodes are mapped, too.
+	Nodes map[string]string `json:"nodes,omitempty" yaml:",omitempty"`
+
+	// Rename maps old binding keys to new binding keys.
+	Rename map[string]string `json:"rename,omitempty" yaml:",omitempty"`
+
+	// Remove lists binding keys to remove.
+	Remove []string `json:"remove,omitempty" yaml:",omitempty"`
+
+	// Defaults gives bindings to add when they are missing.
+	Defaults map[string]interface{} `json:"defaults,omitempty" yaml:",omitempty"`
+
+	// TransformSource is an optional action that transforms the
+	// bindings.  The action's bindings replace the State's
+	// Bindings.  Emitted messages are ignored.
+	TransformSource *ActionSource `json:"transform,omitempty" yaml:"transform,omitempty"`
+
+	// Transform is the compiled TransformSource.
+	Transform Action `json:"-" yaml:"-"`
+}
+
+// Copy makes a deep copy of the Migration.
+//
+// The compiled Transform is shared.
+func (m *Migration) Copy() *Migration {
+	if m == nil {
+		return nil
+	}
+	var nodes map[string]string
+	if m.Nodes != nil {
+		nodes = make(map[string]string, len(m.Nodes))
+		for k, v := range m.Nodes {
+			nodes[k] = v
+		}
+	}
+	var rename map[string]string
+	if m.Rename != nil {
+		rename = make(map[string]string, len(m.Rename))
+		for k, v := range m.Rename {
+			rename[k] = v
+		}
+	}
+	var remove []string
+	if m.Remove != nil {
+		remove = append([]string{}, m.Remove...)
+	}
+	var defaults map[string]interface{}
+	if m.Defaults != nil {
+		defaults = copyValue(m.Defaults).(map[string]interface{})
+	}
+	return &Migration{
+		From:            m.From,
+		Nodes:           nodes,
+		Rename:          rename,
+		Remove:          remove,
+		Defaults:        defaults,
+		TransformSource: m.TransformSource.Copy(),
+		Transform:       m.Transform,
+	}
+}
+
+// applies reports whether the Migration applies to a State from the
+// given (old) Spec, which can be nil if unknown.
+func (m *Migration) applies(from *Spec) bool {
+	if m.From == "" {
+		return true
+	}
+	return from != nil && from.Version == m.From
+}
+
+// compileMigrations is called by Spec.Compile to compile each
+// Migration's TransformSource and to check that each node mapping
+// targets a node in the Spec.
+func (spec *Spec) compileMigrations(ctx context.Context, interpreters Interpreters, force bool) error {
+	for i, m := range spec.Migrations {
+		if m == nil {
+			return errors.New("nil migration in spec '" + spec.Name + "'")
+		}
+		for from, to := range m.Nodes {
+			if _, have := spec.Nodes[to]; !have {
+				return errors.New("migration maps node '" + from + "' to unknown node '" + to + "' in spec '" + spec.Name + "'")
+			}
+		}
+		if m.TransformSource != nil && (force || m.Transform == nil) {
+			action, err := m.TransformSource.Compile(ctx, interpreters)
+			if err != nil {
+				return errors.New(err.Error() + ": transform of migration " + strconv.Itoa(i))
+			}
+			m.Transform = action
+		}
+	}
+	return nil
+}
+
+// MigrationError occurs when Spec.Migrate can't move a State to the
+// Spec.
+type MigrationError struct {
+	Spec *Spec
+
+	// From is the Version of the old Spec (if known).
+	From string
+
+	// NodeName is the State's (old) node.
+	NodeName string
+
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	return `can't migrate node "` + e.NodeName + `" from version "` + e.From +
+		`" of spec "` + e.Spec.Name + `": ` + e.Err.Error()
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MigrationError) Code() string {
+	return CodeMigration
+}
+
+func (e *MigrationError) details(m map[string]interface{}) {
+	m["node"] = e.NodeName
+	m["from"] = e.From
+}
+
+// Migrate returns a new State for this Spec based on the given State
+// from the given (old) Spec, which can be nil if it's unknown.
+//
+// The applicable Migrations (see Migration.From) are applied in
+// order.  Then the resulting State's node (and the node of each of
+// its regions) must be in this Spec.  A region that this Spec no
+// longer has is dropped.  A child State of a composite node is
+// migrated using the child Spec.  Since a child shares its parent's
+// Bindings, a child's Migrations only move its nodes.
+//
+// If the old Spec has the same Id as this Spec, Migrate just returns
+// a copy of the given State.
+//
+// The given State is not modified.
+func (spec *Spec) Migrate(ctx context.Context, from *Spec, st *State, props StepProps) (*State, error) {
+	if st == nil {
+		return nil, nil
+	}
+	if from != nil && from.Id != "" && from.Id == spec.Id {
+		return st.Copy(), nil
+	}
+
+	fail := func(err error) (*State, error) {
+		e := &MigrationError{
+			Spec:     spec,
+			NodeName: st.NodeName,
+			Err:      err,
+		}
+		if from != nil {
+			e.From = from.Version
+		}
+		return nil, e
+	}
+
+	to := st.Copy()
+	if to.Bs == nil {
+		to.Bs = NewBindings()
+	}
+
+	for _, m := range spec.Migrations {
+		if !m.applies(from) {
+			continue
+		}
+		if n, have := m.Nodes[to.NodeName]; have {
+			to.NodeName = n
+		}
+		for r, at := range to.Regions {
+			if n, have := m.Nodes[at]; have {
+				to.Regions[r] = n
+			}
+		}
+		for old, k := range m.Rename {
+			if v, have := to.Bs[old]; have {
+				delete(to.Bs, old)
+				to.Bs[k] = v
+			}
+		}
+		for _, k := range m.Remove {
+			delete(to.Bs, k)
+		}
+		for k, v := range m.Defaults {
+			if _, have := to.Bs[k]; !have {
+				to.Bs[k] = copyValue(v)
+			}
+		}
+		if m.TransformSource != nil || m.Transform != nil {
+			if m.Transform == nil {
+				return fail(errors.New("transform not compiled"))
+			}
+			exe, err := m.Transform.Exec(ctx, to.Bs.Copy(), props)
+			if err != nil {
+				return fail(err)
+			}
+			if exe == nil || exe.Bs == nil {
+				to.Bs = NewBindings()
+			} else {
+				to.Bs = exe.Bs
+			}
+		}
+	}
+
+	n, have := spec.Nodes[to.NodeName]
+	if !have {
+		return fail(&UnknownNode{spec, to.NodeName})
+	}
+
+	if to.Regions != nil {
+		regions := make(map[string]string, len(to.Regions))
+		for _, r := range spec.Regions {
+			at, have := to.Regions[r.Name]
+			if !have {
+				continue
+			}
+			if _, have := spec.Nodes[at]; !have {
+				return fail(&UnknownNode{spec, at})
+			}
+			regions[r.Name] = at
+		}
+		to.Regions = regions
+	}
+
+	if to.Child != nil {
+		if n == nil || !n.Composite() || n.Child == nil {
+			to.Child = nil
+		} else {
+			var old *Spec
+			if from != nil {
+				if o, have := from.Nodes[st.NodeName]; have && o != nil {
+					old = o.Child
+				}
+			}
+			child, err := n.Child.Migrate(ctx, old, to.Child, props)
+			if err != nil {
+				return fail(err)
+			}
+			child.Bs = nil
+			to.Child = child
+		}
+	}
+
+	return to, nil
+}
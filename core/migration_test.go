@@ -0,0 +1,110 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	compile := func(js string) *Spec {
+		spec := parseSpec(t, js)
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		return spec
+	}
+
+	var (
+		v1 = compile(`{"name":"m","version":"1","nodes":{"start":{},"waiting":{},"gone":{}}}`)
+
+		v2 = compile(`{"name":"m","version":"2","nodes":{"start":{},"pending":{}},
+                   "migrations":[{"from":"1","nodes":{"waiting":"pending"},
+                                  "rename":{"n":"count"},"remove":["junk"],"defaults":{"limit":10}}]}`)
+	)
+
+	v2.Migrations[0].Transform = &FuncAction{
+		F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+			n, _ := bs["count"].(float64)
+			return NewExecution(bs.Extend("count", n+1)), nil
+		},
+	}
+
+	st := &State{
+		NodeName: "waiting",
+		Bs:       Dwimjs(`{"n":1,"junk":true,"limit":3}`).(map[string]interface{}),
+	}
+
+	t.Run("basic", func(t *testing.T) {
+		to, err := v2.Migrate(ctx, v1, st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if to.NodeName != "pending" {
+			t.Fatal(JS(to))
+		}
+		if JS(to.Bs) != `{"count":2,"limit":3}` {
+			t.Fatal(JS(to.Bs))
+		}
+		// The given State wasn't modified.
+		if st.NodeName != "waiting" || st.Bs["n"] == nil {
+			t.Fatal(JS(st))
+		}
+	})
+
+	t.Run("same", func(t *testing.T) {
+		to, err := v1.Migrate(ctx, v1, st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if JS(to) != JS(st) {
+			t.Fatal(JS(to))
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		// Without the old Spec, the migration from version 1
+		// doesn't apply.
+		_, err := v2.Migrate(ctx, nil, st, nil)
+		if err == nil {
+			t.Fatal("should have failed")
+		}
+		if ErrorCode(err) != CodeMigration {
+			t.Fatal(ErrorCode(err))
+		}
+	})
+
+	t.Run("gone", func(t *testing.T) {
+		_, err := v2.Migrate(ctx, v1, &State{NodeName: "gone"}, nil)
+		if _, is := err.(*MigrationError); !is {
+			t.Fatal(err)
+		}
+		if details := ErrorDetails(err); details["node"] != "gone" || details["from"] != "1" {
+			t.Fatal(JS(details))
+		}
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		spec := parseSpec(t, `{"name":"m","nodes":{"start":{}},"migrations":[{"nodes":{"x":"y"}}]}`)
+		if err := spec.Compile(ctx, nil, true); err == nil {
+			t.Fatal("should have complained about 'y'")
+		}
+	})
+}
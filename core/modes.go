@@ -0,0 +1,186 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"errors"
+	"strconv"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+// BranchMode is a flag that can inform Branch processing and
+// analysis.
+type BranchMode string
+
+const (
+	// Exclusive declares that the Branch patterns are mutually
+	// exclusive.
+	//
+	// Spec.Compile returns an error if it can't prove that the
+	// patterns of the Branches without guards can't overlap.  At
+	// runtime, every Branch is tried, and Step returns a
+	// NotExclusive error if more than one Branch matches.
+	Exclusive BranchMode = "exclusive"
+
+	// Exhaustive declares that some Branch should always match.
+	//
+	// If no Branch matches, the machine goes to the Branches
+	// Fallback node (or the Spec's ErrorNode if there's no
+	// Fallback) with a binding for "unmatched", which is the
+	// pending message (for "message" branching) or true.
+	Exhaustive BranchMode = "exhaustive"
+)
+
+// KnownBranchModes is the set of BranchModes that this package
+// understands.  Other modes are allowed (and ignored).
+var KnownBranchModes = map[BranchMode]bool{
+	Exclusive:  true,
+	Exhaustive: true,
+}
+
+// BranchModes is a set of BranchMode flags.
+type BranchModes []BranchMode
+
+// Has reports whether the given mode is in the set.
+func (ms BranchModes) Has(mode BranchMode) bool {
+	for _, m := range ms {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy makes a copy of the set.
+func (ms BranchModes) Copy() BranchModes {
+	if ms == nil {
+		return nil
+	}
+	acc := make(BranchModes, len(ms))
+	copy(acc, ms)
+	return acc
+}
+
+// fallback determines the fallback State when an Exhaustive
+// Branches has no Branch that matched.
+func (b *Branches) fallback(s *Spec, bs Bindings, pending interface{}) *State {
+	target := (&Branch{Target: b.Fallback}).target(bs)
+	if target == "" {
+		target = s.ErrorNode
+	}
+	var unmatched interface{} = true
+	if b.Type == "message" {
+		unmatched = pending
+	}
+	return &State{
+		NodeName: target,
+		Bs:       bs.Copy().Extend("unmatched", unmatched),
+	}
+}
+
+// checkModes is called by Spec.Compile to check the given
+// Branches' modes.
+func (b *Branches) checkModes(s *Spec, nodeName string) error {
+	if b.Modes.Has(Exhaustive) && b.Fallback != "" && !IsBranchTargetVariable(b.Fallback) {
+		if _, have := s.Nodes[b.Fallback]; !have {
+			return &UnknownNode{s, b.Fallback}
+		}
+	}
+
+	if b.Modes.Has(Exclusive) {
+		for i, x := range b.Branches {
+			if x == nil || x.Guard != nil {
+				continue
+			}
+			for j := i + 1; j < len(b.Branches); j++ {
+				y := b.Branches[j]
+				if y == nil || y.Guard != nil {
+					continue
+				}
+				if !exclusivePatterns(s.Matcher(), x.Pattern, y.Pattern) {
+					return errors.New("exclusive branching at node '" + nodeName +
+						"': can't show that branches " + strconv.Itoa(i) +
+						" and " + strconv.Itoa(j) + " are exclusive")
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// exclusivePatterns conservatively determines if no fact can match both
+// patterns.  When the simple disjoint check isn't enough, the
+// patterns are unified (see Matcher.Unify) with the given Matcher,
+// which also considers variables that appear more than once.
+func exclusivePatterns(m *Matcher, p, q interface{}) bool {
+	if disjoint(p, q) {
+		return true
+	}
+	if p == nil || q == nil {
+		return false
+	}
+	overlap, err := m.Unify(p, q)
+	return err == nil && !overlap
+}
+
+// disjoint conservatively determines if no fact can match both
+// patterns.
+//
+// A false result just means we couldn't prove that the patterns are
+// disjoint.  We don't try very hard: Two patterns are disjoint when
+// they have different constants (or kinds of values) at the same
+// place.  A variable (even an inequality variable) is assumed to
+// match anything, and arrays, operators (see Matcher.Operators), and
+// array modes (see Matcher.ArrayModes) are too much trouble.
+func disjoint(p, q interface{}) bool {
+	if p == nil || q == nil {
+		// No pattern matches everything.
+		return false
+	}
+	if isVariable(p) || isVariable(q) || IsOperator(p) || IsOperator(q) || IsArrayMode(p) || IsArrayMode(q) {
+		return false
+	}
+	switch pv := p.(type) {
+	case map[string]interface{}:
+		qv, is := q.(map[string]interface{})
+		if !is {
+			_, isArray := q.([]interface{})
+			return !isArray
+		}
+		for k, x := range pv {
+			if DefaultMatcher.IsVariable(k) {
+				continue
+			}
+			if y, have := qv[k]; have && disjoint(x, y) {
+				return true
+			}
+		}
+		return false
+	case []interface{}:
+		_, is := q.([]interface{})
+		return !is
+	default:
+		switch q.(type) {
+		case map[string]interface{}, []interface{}:
+			return true
+		}
+		return p != q
+	}
+}
+
+func isVariable(x interface{}) bool {
+	s, is := x.(string)
+	return is && DefaultMatcher.IsVariable(s)
+}
@@ -0,0 +1,250 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"time"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+// TraceLevel determines which StepEvents Step reports.  See
+// Control.TraceLevel.
+type TraceLevel string
+
+const (
+	// TraceAll (the default) reports every StepEvent.  Traces
+	// from Actions and guards are also kept.
+	TraceAll TraceLevel = "all"
+
+	// TraceExplain reports everything that TraceAll does, and
+	// each PatternMatched event for a pattern that didn't match
+	// also has an Explanation (see Matcher.Explain).  Explaining
+	// a mismatch is expensive, so use this level for debugging.
+	TraceExplain TraceLevel = "explain"
+
+	// TraceTransitions only reports StepStarted, Transitioned,
+	// and MessageEmitted events.
+	TraceTransitions TraceLevel = "transitions"
+
+	// TraceOff reports nothing.  Stride.Traces will be empty, and
+	// the Control's Observer (if any) will not be called.
+	// Emitted messages are of course still in Stride.Emitted.
+	TraceOff TraceLevel = "off"
+)
+
+// StepEventKind says what a StepEvent reports.
+type StepEventKind string
+
+const (
+	// StepStarted reports the State (Node and Bs) and the
+	// pending Message (if any) at the start of a step.
+	StepStarted StepEventKind = "stepStart"
+
+	// ActionExecuted reports the Bindings given to a node's
+	// Action (Bs) and the Bindings it returned (Result).
+	ActionExecuted StepEventKind = "action"
+
+	// EntryExecuted and ExitExecuted report the Bindings given
+	// to a node's Entry or Exit action (Bs) and the Bindings it
+	// returned (Result).
+	EntryExecuted StepEventKind = "entry"
+	ExitExecuted  StepEventKind = "exit"
+
+	// BranchTried reports the State (To) that a Branch would
+	// take us to (if any).  A message Branch that the node's
+	// index ruled out isn't tried.
+	BranchTried StepEventKind = "branch"
+
+	// PatternMatched reports the result (Bss) of matching a
+	// Branch's pattern against the Message (or the current
+	// Bindings).  An empty Bss means no match.  With
+	// TraceExplain, the Explanation says why.
+	PatternMatched StepEventKind = "pattern"
+
+	// GuardEvaluated reports the Bindings given to a Branch's
+	// guard (Bs) and the Bindings it returned (Result).  A nil
+	// Result means the guard said no.
+	GuardEvaluated StepEventKind = "guard"
+
+	// Transitioned reports the State (To) at the end of a step.
+	Transitioned StepEventKind = "transition"
+
+	// MessageEmitted reports a Message emitted by an Action.
+	MessageEmitted StepEventKind = "emit"
+)
+
+// StepEvent is something that happened during a step.  The Kind
+// determines which other fields are used.
+type StepEvent struct {
+	Kind StepEventKind `json:"kind"`
+
+	// Node is the name of the node where the step started.
+	Node string `json:"node,omitempty"`
+
+	Branch  *Branch     `json:"branch,omitempty"`
+	Message interface{} `json:"message,omitempty"`
+	Bs      Bindings    `json:"bs,omitempty"`
+	Bss     []Bindings  `json:"bss,omitempty"`
+	Result  Bindings    `json:"result,omitempty"`
+	To      *State      `json:"to,omitempty"`
+	Error   string      `json:"error,omitempty"`
+
+	// Explanation (with TraceExplain) reports why a Branch's
+	// pattern didn't match.
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// Observer receives StepEvents as they happen.
+//
+// Observe is called synchronously, so it should be quick.
+type Observer interface {
+	Observe(ctx context.Context, e *StepEvent)
+}
+
+// ObserverFunc makes a function an Observer.
+type ObserverFunc func(ctx context.Context, e *StepEvent)
+
+// Observe calls the function.
+func (f ObserverFunc) Observe(ctx context.Context, e *StepEvent) {
+	f(ctx, e)
+}
+
+// CollectingObserver adds each StepEvent to its Traces.
+//
+// When the Control has no Observer, Step uses a CollectingObserver to
+// gather StepEvents in each Stride's Traces.
+type CollectingObserver struct {
+	Traces *Traces
+}
+
+// Observe adds the event to the Traces.
+func (o *CollectingObserver) Observe(ctx context.Context, e *StepEvent) {
+	o.Traces.Add(e)
+}
+
+// observing is what a step uses to report StepEvents.
+type observing struct {
+	ctx   context.Context
+	level TraceLevel
+	node  string
+
+	// observer is the Control's Observer or, if that's nil, a
+	// CollectingObserver for the Stride's Traces.
+	observer Observer
+
+	// traces are the Stride's Traces, which get traces from
+	// Actions and guards.
+	traces *Traces
+
+	// timeout is the Control's ActionTimeout, which guards
+	// also honor.
+	timeout time.Duration
+}
+
+// observing makes an observing for the given Stride's step, which
+// starts at the given node.
+func (c *Control) observing(ctx context.Context, stride *Stride, node string) *observing {
+	level := c.TraceLevel
+	if level == "" {
+		level = TraceAll
+	}
+	observer := c.Observer
+	if observer == nil {
+		observer = &CollectingObserver{
+			Traces: stride.Traces,
+		}
+	}
+	return &observing{
+		ctx:      ctx,
+		level:    level,
+		node:     node,
+		observer: observer,
+		traces:   stride.Traces,
+		timeout:  c.ActionTimeout,
+	}
+}
+
+// on reports whether events of the given kind should be reported.
+//
+// Check on before constructing an event to avoid the allocation.
+func (o *observing) on(kind StepEventKind) bool {
+	switch o.level {
+	case TraceOff:
+		return false
+	case TraceTransitions:
+		switch kind {
+		case StepStarted, Transitioned, MessageEmitted:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// all reports whether every event (and Action traces) should be
+// reported.
+func (o *observing) all() bool {
+	return o.level == TraceAll || o.level == TraceExplain
+}
+
+// report sends the event to the observer (if the event's kind is
+// on).
+//
+// The step might modify its Bindings later, so the event gets copies.
+func (o *observing) report(e *StepEvent) {
+	if !o.on(e.Kind) {
+		return
+	}
+	e.Node = o.node
+	if e.Bs != nil {
+		e.Bs = e.Bs.Copy()
+	}
+	if e.Result != nil {
+		e.Result = e.Result.Copy()
+	}
+	o.observer.Observe(o.ctx, e)
+}
+
+// addEvents adds the given Events (probably from an Execution) to the
+// Stride.  Emitted messages are always added, while traces are only
+// kept with TraceAll (or TraceExplain).
+func (o *observing) addEvents(stride *Stride, es *Events) {
+	if es == nil {
+		return
+	}
+	for _, x := range es.Emitted {
+		stride.AddEmitted(x)
+		if o.on(MessageEmitted) {
+			o.report(&StepEvent{
+				Kind:    MessageEmitted,
+				Message: x,
+			})
+		}
+	}
+	if o.all() {
+		stride.Traces.Add(es.Traces.Messages...)
+	}
+}
+
+// transitioned reports a Transitioned event if the given State isn't
+// nil.
+func (o *observing) transitioned(to *State) {
+	if to != nil && o.on(Transitioned) {
+		o.report(&StepEvent{
+			Kind: Transitioned,
+			To:   to,
+		})
+	}
+}
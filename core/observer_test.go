@@ -0,0 +1,224 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestObserver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	spec, err := TurnstileSpec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{
+		NodeName: "locked",
+		Bs:       make(Bindings),
+	}
+
+	msgs := []interface{}{
+		Dwimjs(`{"input":"coin"}`),
+		Dwimjs(`{"input":"push"}`),
+	}
+
+	walk := func(level TraceLevel) (map[StepEventKind]int, *Walked) {
+		kinds := make(map[StepEventKind]int)
+		c := &Control{
+			Limit: 10,
+			Observer: ObserverFunc(func(ctx context.Context, e *StepEvent) {
+				kinds[e.Kind]++
+			}),
+			TraceLevel: level,
+		}
+		walked, err := spec.Walk(ctx, st, msgs, c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if to := walked.To(); to == nil || to.NodeName != "locked" {
+			t.Fatalf("went to %s", to)
+		}
+		return kinds, walked
+	}
+
+	t.Run("all", func(t *testing.T) {
+		kinds, walked := walk("")
+		if kinds[StepStarted] != 3 || kinds[Transitioned] != 2 || kinds[PatternMatched] == 0 {
+			t.Fatal(kinds)
+		}
+		collected := func(walked *Walked) int {
+			n := 0
+			for _, stride := range walked.Strides {
+				for _, x := range stride.Traces.Messages {
+					if _, is := x.(*StepEvent); is {
+						n++
+					}
+				}
+			}
+			return n
+		}
+		// The Observer gets the events instead of the Traces.
+		if n := collected(walked); n != 0 {
+			t.Fatalf("collected %d events", n)
+		}
+		// Without an Observer, the collecting observer should
+		// see the same events.
+		walked, err := spec.Walk(ctx, st, msgs, &Control{Limit: 10}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for _, count := range kinds {
+			total += count
+		}
+		if n := collected(walked); n != total {
+			t.Fatalf("collected %d != observed %d", n, total)
+		}
+	})
+
+	t.Run("transitions", func(t *testing.T) {
+		kinds, _ := walk(TraceTransitions)
+		if kinds[PatternMatched] != 0 || kinds[BranchTried] != 0 || kinds[Transitioned] != 2 {
+			t.Fatal(kinds)
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		kinds, walked := walk(TraceOff)
+		if 0 < len(kinds) {
+			t.Fatal(kinds)
+		}
+		for _, stride := range walked.Strides {
+			if 0 < len(stride.Traces.Messages) {
+				t.Fatal(JS(stride.Traces))
+			}
+		}
+	})
+}
+
+func TestObserverBindingsCopies(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An Action that fails, so the step adds "actionError" to
+	// its Bindings after the StepStarted event.
+	spec := &Spec{
+		Name:                "failing",
+		ActionErrorBranches: true,
+		Nodes: map[string]*Node{
+			"start": {
+				Action: &FuncAction{
+					F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+						return nil, errors.New("oops")
+					},
+				},
+				Branches: &Branches{
+					Branches: []*Branch{
+						{
+							Target: "done",
+						},
+					},
+				},
+			},
+			"done": {},
+		},
+	}
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var started *StepEvent
+	c := &Control{
+		Limit: 10,
+		Observer: ObserverFunc(func(ctx context.Context, e *StepEvent) {
+			if e.Kind == StepStarted {
+				started = e
+			}
+		}),
+	}
+	st := &State{
+		NodeName: "start",
+		Bs:       NewBindings(),
+	}
+	if _, err := spec.Step(ctx, st, nil, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if started == nil {
+		t.Fatal("didn't start")
+	}
+	if _, have := started.Bs["actionError"]; have {
+		t.Fatal(JS(started.Bs))
+	}
+}
+
+func BenchmarkTurnstileTraceOff(b *testing.B) {
+	benchmarkTurnstile(b, TraceOff)
+}
+
+func TestObserverExplain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	spec := parseSpec(t, `{
+  "name": "explained",
+  "nodes": {
+    "listen": {
+      "branching": {
+        "type": "message",
+        "branches": [
+          {"pattern": {"type": "temp", "value": "?v", "unit": "C"}, "target": "celsius"},
+          {"pattern": {"type": "temp", "unit": "?u"}, "target": "other"}
+        ]
+      }
+    },
+    "celsius": {}, "other": {}
+  }
+}`)
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var es []*Explanation
+	c := &Control{
+		Limit: 10,
+		Observer: ObserverFunc(func(ctx context.Context, e *StepEvent) {
+			if e.Kind == PatternMatched {
+				es = append(es, e.Explanation)
+			}
+		}),
+		TraceLevel: TraceExplain,
+	}
+	st := &State{
+		NodeName: "listen",
+		Bs:       NewBindings(),
+	}
+	msg := Dwimjs(`{"type":"temp","value":3,"unit":"F"}`)
+	if _, err := spec.Step(ctx, st, msg, c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(es) != 2 || es[0] == nil || es[1] != nil {
+		t.Fatal(JS(es))
+	}
+	if e := es[0]; e.Path != "/unit" || e.Reason != MismatchValue || e.Expected != "C" || e.Actual != "F" {
+		t.Fatal(JS(e))
+	}
+}
@@ -12,6 +12,17 @@
 
 package core
 
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Comcast/sheens/match"
+)
+
 // ParamSpec is a strawman struct to represent data about required and
 // optional Machine parameters (which are just initial bindings).
 //
@@ -27,6 +38,9 @@ type ParamSpec struct {
 	Doc string `json:"doc,omitempty" yaml:",omitempty"`
 
 	// PrimitiveType is (for now) any string.
+	//
+	// See KnownPrimitiveTypes for the types that are actually
+	// checked.
 	PrimitiveType string `json:"primitiveType" yaml:"primitiveType"`
 
 	// Default is the default value for a parameter used in case a value is not given in the initial bindings
@@ -62,24 +76,247 @@ type ParamSpec struct {
 	Advisory bool `json:"advisory,omitempty" yaml:",omitempty"`
 }
 
-// Valid should return an error if the given spec is bad for some
-// reason.
+// Copy makes a deep copy of the ParamSpec.
+func (s ParamSpec) Copy() ParamSpec {
+	s.Default = copyValue(s.Default)
+	s.Predicate = copyValue(s.Predicate)
+	return s
+}
+
+// KnownPrimitiveTypes are the ParamSpec PrimitiveTypes that
+// ValueCompliesWith knows how to check.
 //
-// Currently just returns nil.
+// Any other PrimitiveType (like "activeTimeSpec") is accepted
+// without a check, which is also what happens when PrimitiveType is
+// empty.
+var KnownPrimitiveTypes = map[string]func(interface{}) bool{
+	"string":    isString,
+	"int":       isInteger,
+	"integer":   isInteger,
+	"number":    isNumber,
+	"float":     isNumber,
+	"bool":      isBool,
+	"boolean":   isBool,
+	"object":    isObject,
+	"map":       isObject,
+	"array":     isArray,
+	"duration":  isDuration,
+	"timestamp": isTimestamp,
+}
+
+// Valid returns an error if the given spec is bad for some reason.
 //
-// Probably shouldn't return an error, but we'll just go with that for
-// now.
+// The cardinalities can't be negative, a non-zero MaxCardinality
+// can't be less than the MinCardinality, and a Default (if any) must
+// comply with the spec.
 func (s *ParamSpec) Valid() error {
+	if s.MinCardinality < 0 {
+		return errors.New("negative minCard")
+	}
+	if s.MaxCardinality < 0 {
+		return errors.New("negative maxCard")
+	}
+	if 0 < s.MaxCardinality && s.MaxCardinality < s.MinCardinality {
+		return errors.New("maxCard less than minCard")
+	}
+	if s.Default != nil {
+		if err := s.ValueCompilesWith(s.Default); err != nil {
+			return errors.New("bad default: " + err.Error())
+		}
+	}
 	return nil
 }
 
+// multiple reports whether a value should be an array of values.
+func (s *ParamSpec) multiple() bool {
+	return s.IsArray || 1 < s.MaxCardinality
+}
+
 // ValueCompilesWith checks that the given value complies with the
 // spec. Returns an error if not.
 //
-// Currently just returns nil.
+// If the spec calls for an array (see IsArray and MaxCardinality),
+// then the value must be an array with an acceptable number of
+// elements, and each element must have the PrimitiveType.
 //
-// Probably shouldn't return an error, but we'll just go with that for
-// now.
+// (Yes, the name should have been "ValueCompliesWith".)
 func (s *ParamSpec) ValueCompilesWith(x interface{}) error {
+	if !s.multiple() {
+		return s.checkPrimitive(x)
+	}
+	xs, is := x.([]interface{})
+	if !is {
+		return errors.New("not an array")
+	}
+	n := len(xs)
+	if n < s.MinCardinality {
+		return errors.New("has " + strconv.Itoa(n) + " values (minCard " + strconv.Itoa(s.MinCardinality) + ")")
+	}
+	if 0 < s.MaxCardinality && s.MaxCardinality < n {
+		return errors.New("has " + strconv.Itoa(n) + " values (maxCard " + strconv.Itoa(s.MaxCardinality) + ")")
+	}
+	for i, x := range xs {
+		if err := s.checkPrimitive(x); err != nil {
+			return errors.New("value " + strconv.Itoa(i) + " " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *ParamSpec) checkPrimitive(x interface{}) error {
+	check, have := KnownPrimitiveTypes[s.PrimitiveType]
+	if !have {
+		return nil
+	}
+	if !check(x) {
+		return errors.New("is not a " + s.PrimitiveType)
+	}
 	return nil
 }
+
+// ParamProblem reports a parameter that doesn't comply with its
+// ParamSpec.
+type ParamProblem struct {
+	Param    string `json:"param"`
+	Problem  string `json:"problem"`
+	Advisory bool   `json:"advisory,omitempty"`
+}
+
+func (p *ParamProblem) Error() string {
+	return `parameter "` + p.Param + `" ` + p.Problem
+}
+
+// ParamProblems is an error that gathers all the (non-advisory)
+// problems found by Spec.InitialBindings.
+type ParamProblems []*ParamProblem
+
+func (ps ParamProblems) Error() string {
+	acc := make([]string, len(ps))
+	for i, p := range ps {
+		acc[i] = p.Error()
+	}
+	return strings.Join(acc, "; ")
+}
+
+// InitialBindings checks the given initial bindings against the
+// Spec's ParamSpecs and returns a copy of those bindings with (copies
+// of) Default values for any missing parameters.
+//
+// A problem with a parameter that has an Advisory ParamSpec is
+// returned as a warning.  If there are any other problems, they are
+// returned as a ParamProblems error.
+func (spec *Spec) InitialBindings(bs Bindings) (Bindings, ParamProblems, error) {
+	if bs == nil {
+		bs = NewBindings()
+	} else {
+		bs = bs.Copy()
+	}
+
+	var warnings, problems ParamProblems
+
+	// Iterate in a deterministic order so that problems are
+	// reported consistently.
+	names := make([]string, 0, len(spec.ParamSpecs))
+	for name := range spec.ParamSpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ps := spec.ParamSpecs[name]
+		problem := ""
+		x, have := bs[name]
+		if !have && ps.Default != nil {
+			// Each machine gets its own copy.
+			x, have = copyValue(ps.Default), true
+			bs[name] = x
+		}
+		if !have {
+			if !ps.Optional {
+				problem = "is required"
+			}
+		} else if err := ps.ValueCompilesWith(x); err != nil {
+			problem = err.Error()
+		}
+		if problem == "" {
+			continue
+		}
+		p := &ParamProblem{
+			Param:    name,
+			Problem:  problem,
+			Advisory: ps.Advisory,
+		}
+		if ps.Advisory {
+			warnings = append(warnings, p)
+		} else {
+			problems = append(problems, p)
+		}
+	}
+
+	if 0 < len(problems) {
+		return nil, warnings, problems
+	}
+
+	return bs, warnings, nil
+}
+
+func isString(x interface{}) bool {
+	_, is := x.(string)
+	return is
+}
+
+func isNumber(x interface{}) bool {
+	switch x.(type) {
+	case float64, float32, int, int64, int32, uint, uint64, uint32:
+		return true
+	}
+	return false
+}
+
+func isInteger(x interface{}) bool {
+	switch vv := x.(type) {
+	case int, int64, int32, uint, uint64, uint32:
+		return true
+	case float64:
+		return vv == math.Trunc(vv)
+	case float32:
+		return float64(vv) == math.Trunc(float64(vv))
+	}
+	return false
+}
+
+func isBool(x interface{}) bool {
+	_, is := x.(bool)
+	return is
+}
+
+func isObject(x interface{}) bool {
+	switch x.(type) {
+	case map[string]interface{}, Bindings, map[interface{}]interface{}:
+		return true
+	}
+	return false
+}
+
+func isArray(x interface{}) bool {
+	_, is := x.([]interface{})
+	return is
+}
+
+func isDuration(x interface{}) bool {
+	s, is := x.(string)
+	if !is {
+		return false
+	}
+	_, err := time.ParseDuration(s)
+	return err == nil
+}
+
+func isTimestamp(x interface{}) bool {
+	s, is := x.(string)
+	if !is {
+		return false
+	}
+	_, err := time.Parse(time.RFC3339Nano, s)
+	return err == nil
+}
@@ -0,0 +1,163 @@
+/* Copyright 2018 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+)
+
+func TestParamSpecValueCompilesWith(t *testing.T) {
+	tests := []struct {
+		description string
+		spec        ParamSpec
+		val         string
+		ok          bool
+	}{
+		{"string", ParamSpec{PrimitiveType: "string"}, `"tacos"`, true},
+		{"not a string", ParamSpec{PrimitiveType: "string"}, `42`, false},
+		{"int", ParamSpec{PrimitiveType: "int"}, `42`, true},
+		{"not an int", ParamSpec{PrimitiveType: "int"}, `4.2`, false},
+		{"number", ParamSpec{PrimitiveType: "number"}, `4.2`, true},
+		{"duration", ParamSpec{PrimitiveType: "duration"}, `"3s"`, true},
+		{"bad duration", ParamSpec{PrimitiveType: "duration"}, `"soon"`, false},
+		{"unknown type", ParamSpec{PrimitiveType: "activeTimeSpec"}, `{"startTime":"19:00"}`, true},
+		{"array", ParamSpec{PrimitiveType: "string", IsArray: true}, `["a","b"]`, true},
+		{"array required", ParamSpec{PrimitiveType: "string", IsArray: true}, `"a"`, false},
+		{"array element", ParamSpec{PrimitiveType: "string", IsArray: true}, `["a",1]`, false},
+		{"maxCard implies array", ParamSpec{MaxCardinality: 2}, `"a"`, false},
+		{"minCard", ParamSpec{IsArray: true, MinCardinality: 2}, `["a"]`, false},
+		{"maxCard", ParamSpec{IsArray: true, MaxCardinality: 2}, `["a","b","c"]`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			err := tc.spec.ValueCompilesWith(Dwimjs(tc.val))
+			if tc.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tc.ok && err == nil {
+				t.Fatalf("%s should have failed", tc.val)
+			}
+		})
+	}
+}
+
+func TestParamSpecValid(t *testing.T) {
+	if err := (&ParamSpec{MinCardinality: 3, MaxCardinality: 2}).Valid(); err == nil {
+		t.Fatal("should have complained about cardinalities")
+	}
+	if err := (&ParamSpec{PrimitiveType: "int", Default: "ten"}).Valid(); err == nil {
+		t.Fatal("should have complained about the default")
+	}
+	if err := (&ParamSpec{PrimitiveType: "int", Default: 10}).Valid(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitialBindings(t *testing.T) {
+	spec := &Spec{
+		ParamSpecs: map[string]ParamSpec{
+			"door": {
+				PrimitiveType: "string",
+			},
+			"max": {
+				PrimitiveType: "int",
+				Default:       10,
+			},
+			"audience": {
+				PrimitiveType: "string",
+				Optional:      true,
+			},
+			"interval": {
+				PrimitiveType: "duration",
+				Advisory:      true,
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		given := Bindings{"door": "front", "interval": "1m"}
+		bs, warnings, err := spec.InitialBindings(given)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if 0 < len(warnings) {
+			t.Fatal(warnings)
+		}
+		if n, have := bs["max"]; !have || n != 10 {
+			t.Fatal(JS(bs))
+		}
+		if _, have := given["max"]; have {
+			t.Fatal("modified the given bindings")
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		spec := &Spec{
+			ParamSpecs: map[string]ParamSpec{
+				"rooms": {
+					PrimitiveType: "string",
+					IsArray:       true,
+					Default:       []interface{}{"den"},
+				},
+			},
+		}
+		bs, _, err := spec.InitialBindings(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs["rooms"].([]interface{})[0] = "attic"
+		if room := spec.ParamSpecs["rooms"].Default.([]interface{})[0]; room != "den" {
+			t.Fatal(room)
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		if _, _, err := spec.InitialBindings(Bindings{"interval": "1m"}); err == nil {
+			t.Fatal("should have complained about missing door")
+		}
+	})
+
+	t.Run("type", func(t *testing.T) {
+		_, _, err := spec.InitialBindings(Bindings{"door": 42, "interval": "1m"})
+		if err == nil {
+			t.Fatal("should have complained about door")
+		}
+		if ps, is := err.(ParamProblems); !is || len(ps) != 1 || ps[0].Param != "door" {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("advisory", func(t *testing.T) {
+		bs, warnings, err := spec.InitialBindings(Bindings{"door": "front", "interval": "soon"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(warnings) != 1 || warnings[0].Param != "interval" {
+			t.Fatal(JS(warnings))
+		}
+		if bs["interval"] != "soon" {
+			t.Fatal(JS(bs))
+		}
+	})
+}
@@ -0,0 +1,254 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	. "github.com/Comcast/sheens/match"
+)
+
+const (
+	// RegionMergeOrdered means that when two regions change the
+	// same binding, the later region (in Spec.Regions order)
+	// wins.  The primary node comes first.
+	RegionMergeOrdered = "ordered"
+
+	// RegionMergeStrict means that when two regions change the
+	// same binding to different values, Step returns a
+	// RegionConflict error.
+	RegionMergeStrict = "strict"
+)
+
+// Region is an orthogonal region of a Spec.
+//
+// A region's nodes are just nodes in the Spec.  A region only has a
+// name and the node it starts at.  A region only records its current
+// node name, so a region shouldn't visit a composite node.
+type Region struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Start is the region's initial node.
+	Start string `json:"start" yaml:"start"`
+
+	Doc string `json:"doc,omitempty" yaml:",omitempty"`
+}
+
+// Copy makes a copy of the Region.
+func (r *Region) Copy() *Region {
+	if r == nil {
+		return nil
+	}
+	return &Region{
+		Name:  r.Name,
+		Start: r.Start,
+		Doc:   r.Doc,
+	}
+}
+
+// RegionConflict occurs when regions disagree about a binding and
+// the Spec's RegionMerge is RegionMergeStrict.
+type RegionConflict struct {
+	Spec    *Spec
+	Key     string
+	Regions []string
+}
+
+func (e *RegionConflict) Error() string {
+	return `regions "` + e.Regions[0] + `" and "` + e.Regions[1] + `" in spec "` + e.Spec.Name +
+		`" disagree about "` + e.Key + `"`
+}
+
+func (e *RegionConflict) Code() string {
+	return CodeRegionConflict
+}
+
+func (e *RegionConflict) details(m map[string]interface{}) {
+	m["key"] = e.Key
+	regions := make([]interface{}, len(e.Regions))
+	for i, r := range e.Regions {
+		regions[i] = r
+	}
+	m["regions"] = regions
+}
+
+// checkRegions is called by Spec.Compile.
+func (s *Spec) checkRegions() error {
+	switch s.RegionMerge {
+	case "":
+		s.RegionMerge = RegionMergeOrdered
+	case RegionMergeOrdered, RegionMergeStrict:
+	default:
+		return errors.New("unknown regionMerge '" + s.RegionMerge + "'")
+	}
+
+	seen := make(map[string]bool, len(s.Regions))
+	for _, r := range s.Regions {
+		if r == nil || r.Name == "" {
+			return errors.New("region without a name in spec '" + s.Name + "'")
+		}
+		if seen[r.Name] {
+			return errors.New("duplicate region '" + r.Name + "' in spec '" + s.Name + "'")
+		}
+		seen[r.Name] = true
+		if _, have := s.Nodes[r.Start]; !have {
+			return &UnknownNode{s, r.Start}
+		}
+	}
+
+	return nil
+}
+
+// stepRegions is Step for a Spec with Regions.
+//
+// The primary node (State.NodeName) takes a step, and then each
+// region takes a step (in order).  Each step starts with the given
+// Bindings, and each step sees the same pending message.  The message
+// is consumed if any step consumed it.  The resulting Bindings are
+// merged according to the Spec's RegionMerge.
+//
+// A region that isn't in the given State.Regions starts at its
+// Region.Start.
+func (s *Spec) stepRegions(ctx context.Context, st *State, pending interface{}, c *Control, props StepProps) (*Stride, error) {
+	if !s.compiled {
+		return nil, &SpecNotCompiled{s}
+	}
+
+	stride := NewStride()
+	stride.From = st.Copy()
+
+	var (
+		regions = make(map[string]string, len(s.Regions))
+		merged  = st.Bs.Copy()
+		setBy   = make(map[string]string)
+		moved   = false
+		to      = &State{
+			NodeName: st.NodeName,
+			Child:    st.Child.copyChild(),
+		}
+	)
+
+	for _, r := range s.Regions {
+		if at, have := st.Regions[r.Name]; have {
+			regions[r.Name] = at
+		} else {
+			regions[r.Name] = r.Start
+			moved = true
+		}
+	}
+
+	// merge folds the given Bindings from the named region into
+	// the merged Bindings.
+	merge := func(region string, bs Bindings) error {
+		for k, v := range bs {
+			if was, have := st.Bs[k]; have && reflect.DeepEqual(was, v) {
+				continue
+			}
+			if other, have := setBy[k]; have && s.RegionMerge == RegionMergeStrict {
+				if !reflect.DeepEqual(merged[k], v) {
+					return &RegionConflict{s, k, []string{other, region}}
+				}
+			}
+			setBy[k] = region
+			merged[k] = v
+		}
+		for k := range st.Bs {
+			if _, have := bs[k]; !have {
+				delete(merged, k)
+			}
+		}
+		return nil
+	}
+
+	// take makes the given (sub)State take a step.
+	take := func(region string, sub *State) (*State, error) {
+		ss, err := s.step(ctx, sub, pending, c, props)
+		if ss == nil {
+			return nil, err
+		}
+		stride.AddEvents(ss.Events)
+		if ss.Consumed != nil {
+			stride.Consumed = ss.Consumed
+		}
+		for _, sp := range ss.Spawned {
+			// A new machine starts with the other
+			// regions where they are now.
+			spawned := &State{
+				NodeName: st.NodeName,
+				Bs:       sp.Bs.Copy(),
+				Child:    st.Child.copyChild(),
+				Regions:  make(map[string]string, len(regions)),
+			}
+			for r, at := range regions {
+				spawned.Regions[r] = at
+			}
+			if region == "" {
+				spawned.NodeName = sp.NodeName
+				spawned.Child = sp.Child.copyChild()
+			} else {
+				spawned.Regions[region] = sp.NodeName
+			}
+			stride.Spawned = append(stride.Spawned, spawned)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ss.To == nil {
+			return nil, nil
+		}
+		moved = true
+		return ss.To, merge(region, ss.To.Bs)
+	}
+
+	// The primary node, which is in the unnamed region, goes
+	// first.
+	primary, err := take("", &State{
+		NodeName: st.NodeName,
+		Bs:       st.Bs.Copy(),
+		Child:    st.Child.copyChild(),
+	})
+	if err != nil {
+		return stride, err
+	}
+	if primary != nil {
+		to.NodeName = primary.NodeName
+		to.Child = primary.Child.copyChild()
+	}
+
+	for _, r := range s.Regions {
+		next, err := take(r.Name, &State{
+			NodeName: regions[r.Name],
+			Bs:       st.Bs.Copy(),
+		})
+		if err != nil {
+			return stride, err
+		}
+		if next != nil {
+			regions[r.Name] = next.NodeName
+		}
+	}
+
+	if moved {
+		to.Bs = merged
+		to.Regions = regions
+		stride.To = to
+		if c == nil {
+			c = DefaultControl
+		}
+		c.observing(ctx, stride, st.NodeName).transitioned(to)
+	}
+
+	return stride, nil
+}
@@ -0,0 +1,154 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/Comcast/sheens/match"
+	. "github.com/Comcast/sheens/util/testutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+func toggle(pattern, target string) *Node {
+	return &Node{
+		Branches: &Branches{
+			Type: "message",
+			Branches: []*Branch{
+				{
+					Pattern: Dwimjs(pattern),
+					Target:  target,
+				},
+			},
+		},
+	}
+}
+
+func regionsSpec() *Spec {
+	return &Spec{
+		Name: "device",
+		Nodes: map[string]*Node{
+			"start":   toggle(`{"power":"on","by":"?who"}`, "on"),
+			"on":      toggle(`{"power":"off","by":"?who"}`, "start"),
+			"offline": toggle(`{"link":"up","by":"?linker"}`, "online"),
+			"online":  toggle(`{"link":"down","by":"?linker"}`, "offline"),
+		},
+		Regions: []*Region{
+			{
+				Name:  "connectivity",
+				Start: "offline",
+			},
+		},
+	}
+}
+
+func TestRegions(t *testing.T) {
+	spec := regionsSpec()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{
+		NodeName: "start",
+		Bs:       make(Bindings),
+	}
+
+	msgs := []interface{}{
+		Dwimjs(`{"power":"on","by":"homer"}`),
+		Dwimjs(`{"link":"up","by":"marge"}`),
+	}
+
+	walked, err := spec.Walk(ctx, st, msgs, DefaultControl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := walked.To()
+	if to.NodeName != "on" || to.Regions["connectivity"] != "online" {
+		t.Fatal(JS(to))
+	}
+	if to.Bs["?who"] != "homer" || to.Bs["?linker"] != "marge" {
+		t.Fatal(JS(to.Bs))
+	}
+
+	t.Run("json", func(t *testing.T) {
+		js, err := json.Marshal(to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got State
+		if err = json.Unmarshal(js, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Regions["connectivity"] != "online" {
+			t.Fatal(string(js))
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		bs, err := yaml.Marshal(to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got State
+		if err = yaml.Unmarshal(bs, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Regions["connectivity"] != "online" {
+			t.Fatal(string(bs))
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		spec := regionsSpec()
+		spec.RegionMerge = RegionMergeStrict
+		// Both regions will react to a power message.
+		spec.Nodes["offline"] = toggle(`{"power":"on","who":"?who"}`, "online")
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		st := &State{
+			NodeName: "start",
+			Bs:       make(Bindings),
+			Regions: map[string]string{
+				"connectivity": "offline",
+			},
+		}
+
+		// Both regions bind the same value: no conflict.
+		msg := Dwimjs(`{"power":"on","by":"bart","who":"bart"}`)
+		if _, err := spec.Step(ctx, st, msg, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		msg = Dwimjs(`{"power":"on","by":"bart","who":"lisa"}`)
+		_, err := spec.Step(ctx, st, msg, nil, nil)
+		if _, is := err.(*RegionConflict); !is {
+			t.Fatalf("expected RegionConflict; got %v", err)
+		}
+		// A branch pattern can match the error's details.
+		bss, err := Match(Dwimjs(`{"regions":["?r"]}`), ErrorDetails(err), NewBindings())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bss) != 2 {
+			t.Fatal(bss)
+		}
+	})
+}
@@ -16,6 +16,8 @@ import (
 	"context"
 	"encoding/json" // ToDo: remove
 	"errors"
+
+	. "github.com/Comcast/sheens/match"
 )
 
 var (
@@ -72,13 +74,13 @@ type Spec struct {
 	// like "1.2".
 	Version string `json:"version,omitempty" yaml:",omitempty"`
 
-	// Id should be a globally unique identifier (such as a hash
-	// of a canonical representation of the Spec).
+	// Id is a hash of a canonical representation of the Spec.
+	// See Spec.Hash.
 	//
-	// This value could be used to determine when a Spec has
+	// This value can be used to determine when a Spec has
 	// changed.
 	//
-	// This package does not read or write this value.
+	// Compile writes this value.
 	Id string `json:"id,omitempty" yaml:",omitempty"`
 
 	// Doc is general documentation about how this specification works.
@@ -88,13 +90,14 @@ type Spec struct {
 	// specification for that parameter.
 	//
 	// A parameter is really just an initial binding that's
-	// provided when a machine is created.
-	//
-	// ToDo: Implement actual check of parameters when machine is
-	// created.
+	// provided when a machine is created.  See InitialBindings,
+	// which checks parameters and provides defaults.
 	ParamSpecs map[string]ParamSpec `json:"paramSpecs,omitempty" yaml:",omitempty"`
 
 	// Uses is a set of feature tags.
+	//
+	// Tags that start with MatcherFeaturePrefix configure the
+	// Matcher for branch patterns.  See UsesMatcher.
 	Uses []string `json:"uses,omitempty" yaml:",omitempty"`
 
 	// Nodes is the structure of the machine.  This value could be
@@ -129,8 +132,9 @@ type Spec struct {
 	// rather than a stride ending at a node given by this value.
 	ActionErrorNode string `json:"actionErrorNode,omitempty" yaml:",omitempty"`
 
-	// Boot is an optional Action that should be (?) executed when
-	// the machine is loaded.  Not implemented yet.
+	// Boot is an optional Action that should be executed when a
+	// machine is created, loaded, or switched to this Spec.  See
+	// ExecBoot.
 	Boot Action `json:"-" yaml:"-"`
 
 	// BootSource, if given, can be compiled to a Boot Action.
@@ -139,8 +143,7 @@ type Spec struct {
 
 	// Toob is of course Boot in reverse.  It's also an optional
 	// Action that can/should be executed when a Machine is
-	// unloaded, suspended, or whatever.  Not currently connected
-	// to anything.
+	// deleted, suspended, or whatever.  See ExecToob.
 	Toob Action `json:"-" yaml:"-"`
 
 	// ToobSource, if given, can be compiled to a Toob Action.
@@ -152,30 +155,155 @@ type Spec struct {
 
 	PatternParser func(string, interface{}) (interface{}, error) `json:"-" yaml:"-"`
 
+	// FanOut (when true) means that a branch pattern match that
+	// returns more than one set of bindings spawns new machines.
+	// The first set of bindings is used to take the branch, and
+	// each additional set of bindings results in a new State at
+	// the (same) branch target.  Step reports these States in
+	// Stride.Spawned.  It's up to the caller to make new
+	// machines with those States.
+	//
+	// When FanOut is false, multiple sets of bindings result in
+	// a TooManyBindingss error.
+	FanOut bool `json:"fanOut,omitempty" yaml:"fanOut,omitempty"`
+
 	// NoNewMachines will make Step return an error if a pattern
 	// match returns more than one set of bindings.
 	//
-	// ToDo: Implement.
-	NoNewMachines bool `json:"noNewMachined,omitempty" yaml:",omitempty"`
+	// That's the behavior when FanOut is false, and this switch
+	// overrides FanOut.
+	//
+	// The JSON property was once spelled "noNewMachined", and
+	// UnmarshalJSON still accepts that spelling.
+	NoNewMachines bool `json:"noNewMachines,omitempty" yaml:",omitempty"`
+
+	// Regions declares orthogonal regions that run alongside
+	// the machine's primary node (State.NodeName).  A State
+	// records the current node for each Region in
+	// State.Regions.
+	//
+	// Step offers the pending message to the primary node and
+	// then to each Region in order.  Every region starts with
+	// the same Bindings, and the resulting Bindings are merged
+	// according to RegionMerge.
+	Regions []*Region `json:"regions,omitempty" yaml:",omitempty"`
+
+	// RegionMerge is the rule for merging the Bindings from the
+	// regions.  See RegionMergeOrdered (the default) and
+	// RegionMergeStrict.
+	RegionMerge string `json:"regionMerge,omitempty" yaml:"regionMerge,omitempty"`
+
+	// Migrations describe how to move a machine's State from an
+	// older version of this Spec.  See Spec.Migrate.
+	Migrations []*Migration `json:"migrations,omitempty" yaml:",omitempty"`
+
+	// matcher is the Matcher that Compile determined from Uses.
+	// See Spec.Matcher.
+	matcher *Matcher
 
 	compiled bool
 }
 
+// UnmarshalJSON parses a Spec, accepting the old spelling
+// "noNewMachined" for NoNewMachines.
+func (spec *Spec) UnmarshalJSON(bs []byte) error {
+	type plain Spec
+	x := struct {
+		*plain
+		NoNewMachined bool `json:"noNewMachined"`
+	}{
+		plain: (*plain)(spec),
+	}
+	if err := json.Unmarshal(bs, &x); err != nil {
+		return err
+	}
+	if x.NoNewMachined {
+		spec.NoNewMachines = true
+	}
+	return nil
+}
+
 // Copy makes a deep copy of the Spec.
+//
+// If the given version isn't empty, the copy gets that Version (and
+// no Id, since the Id depends on the Version).
+//
+// Compiled things (like Actions) are shared.  Children of composite
+// nodes are not copied.
 func (spec *Spec) Copy(version string) *Spec {
+	id := spec.Id
 	if version == "" {
 		version = spec.Version
+	} else if version != spec.Version {
+		id = ""
 	}
-	ns := make(map[string]*Node, len(spec.Nodes))
-	for name, n := range spec.Nodes {
-		ns[name] = n.Copy()
+
+	var ns map[string]*Node
+	if spec.Nodes != nil {
+		ns = make(map[string]*Node, len(spec.Nodes))
+		for name, n := range spec.Nodes {
+			if n == nil {
+				ns[name] = nil
+				continue
+			}
+			ns[name] = n.Copy()
+		}
+	}
+
+	var ps map[string]ParamSpec
+	if spec.ParamSpecs != nil {
+		ps = make(map[string]ParamSpec, len(spec.ParamSpecs))
+		for name, p := range spec.ParamSpecs {
+			ps[name] = p.Copy()
+		}
+	}
+
+	var uses []string
+	if spec.Uses != nil {
+		uses = append([]string{}, spec.Uses...)
+	}
+
+	var regions []*Region
+	if spec.Regions != nil {
+		regions = make([]*Region, len(spec.Regions))
+		for i, r := range spec.Regions {
+			regions[i] = r.Copy()
+		}
+	}
+
+	var migrations []*Migration
+	if spec.Migrations != nil {
+		migrations = make([]*Migration, len(spec.Migrations))
+		for i, m := range spec.Migrations {
+			migrations[i] = m.Copy()
+		}
 	}
 
 	return &Spec{
-		Name:    spec.Name,
-		Version: version,
-		Doc:     spec.Doc,
-		Nodes:   ns,
+		Name:                spec.Name,
+		Version:             version,
+		Id:                  id,
+		Doc:                 spec.Doc,
+		ParamSpecs:          ps,
+		Uses:                uses,
+		Nodes:               ns,
+		ErrorNode:           spec.ErrorNode,
+		NoAutoErrorNode:     spec.NoAutoErrorNode,
+		ActionErrorBranches: spec.ActionErrorBranches,
+		ActionErrorNode:     spec.ActionErrorNode,
+		Boot:                spec.Boot,
+		BootSource:          spec.BootSource.Copy(),
+		Toob:                spec.Toob,
+		ToobSource:          spec.ToobSource.Copy(),
+		PatternSyntax:       spec.PatternSyntax,
+		PatternParser:       spec.PatternParser,
+		FanOut:              spec.FanOut,
+		NoNewMachines:       spec.NoNewMachines,
+		Regions:             regions,
+		RegionMerge:         spec.RegionMerge,
+		Migrations:          migrations,
+		matcher:             spec.matcher,
+		compiled:            spec.compiled && id != "",
 	}
 }
 
@@ -227,6 +355,12 @@ func (spec *Spec) Compile(ctx context.Context, interpreters Interpreters, force
 		return err
 	}
 
+	m, problems := UsesMatcher(spec.Uses)
+	if 0 < len(problems) {
+		return errors.New("spec '" + spec.Name + "': " + problems[0].Error())
+	}
+	spec.matcher = m
+
 	if spec.BootSource != nil && (force || spec.Boot == nil) {
 		action, err := spec.BootSource.Compile(ctx, interpreters)
 		if err != nil {
@@ -243,6 +377,16 @@ func (spec *Spec) Compile(ctx context.Context, interpreters Interpreters, force
 		spec.Toob = action
 	}
 
+	if err := spec.checkRegions(); err != nil {
+		return err
+	}
+
+	for name, ps := range spec.ParamSpecs {
+		if err := ps.Valid(); err != nil {
+			return errors.New("bad paramSpec '" + name + "': " + err.Error())
+		}
+	}
+
 	if spec.ErrorNode == "" {
 		spec.ErrorNode = DefaultErrorNodeName
 	}
@@ -274,6 +418,14 @@ func (spec *Spec) Compile(ctx context.Context, interpreters Interpreters, force
 			n.Action = action
 		}
 
+		if err := n.compileEntryExit(ctx, name, interpreters, force); err != nil {
+			return err
+		}
+
+		if err := n.compileChild(ctx, spec, name, interpreters, force); err != nil {
+			return err
+		}
+
 		if n.Branches == nil {
 			continue
 		}
@@ -296,6 +448,10 @@ func (spec *Spec) Compile(ctx context.Context, interpreters Interpreters, force
 				return err
 			}
 			b.Pattern = x
+			// A bad pattern isn't compiled, so matching it
+			// reports a PatternError (if matching reaches the
+			// problem) as it always has.
+			b.Compiled, _ = m.Compile(x)
 			if b.GuardSource != nil && (force || b.Guard == nil) {
 				guard, err := b.GuardSource.Compile(ctx, interpreters)
 				if err != nil {
@@ -304,8 +460,24 @@ func (spec *Spec) Compile(ctx context.Context, interpreters Interpreters, force
 				b.Guard = guard
 			}
 		}
+
+		if err := n.Branches.checkModes(spec, name); err != nil {
+			return err
+		}
+
+		n.Branches.index = n.Branches.buildIndex()
+	}
+
+	if err := spec.compileMigrations(ctx, interpreters, force); err != nil {
+		return err
 	}
 
+	id, err := spec.Hash()
+	if err != nil {
+		return errors.New("can't hash spec '" + spec.Name + "': " + err.Error())
+	}
+	spec.Id = id
+
 	spec.compiled = true
 
 	return nil
@@ -324,24 +496,82 @@ type Node struct {
 	// transition to this node.
 	//
 	// Note that a node with "message"-based branching cannot have
-	// an Action.
+	// an Action.  Such a node can have an Entry action instead.
 	Action Action `json:"-" yaml:"-"`
 
 	// ActionSource, if given, is Compile()ed to an Action.
 	ActionSource *ActionSource `json:"action,omitempty" yaml:"action,omitempty"`
 
+	// Entry is an optional action that is executed when a Branch
+	// (from another node) arrives at this node.  Entry runs in
+	// the step that arrives, so a node with "message" branching
+	// can set up its Bindings before waiting for a message.
+	//
+	// Entry doesn't run for a machine that simply starts at this
+	// node (see Spec.Boot) or for a Branch from this node back to
+	// this node.
+	Entry Action `json:"-" yaml:"-"`
+
+	// EntrySource, if given, is Compile()ed to the Entry action.
+	EntrySource *ActionSource `json:"entry,omitempty" yaml:"entry,omitempty"`
+
+	// Exit is an optional action that is executed when a Branch
+	// takes the machine from this node to another node.  Exit
+	// runs before the next node's Entry.
+	Exit Action `json:"-" yaml:"-"`
+
+	// ExitSource, if given, is Compile()ed to the Exit action.
+	ExitSource *ActionSource `json:"exit,omitempty" yaml:"exit,omitempty"`
+
 	// Branches contains the transitions out of this node.
+	//
+	// For a composite node (see ChildSource), these branches
+	// pre-empt the child.
 	Branches *Branches `json:"branching,omitempty" yaml:"branching,omitempty"`
+
+	// ChildSource, if given, makes this node a composite node
+	// that embeds another Spec.  See Child.
+	ChildSource *SpecSource `json:"child,omitempty" yaml:"child,omitempty"`
+
+	// Child is the Spec embedded in a composite node.
+	//
+	// Compile sets Child to an inline ChildSource.  Otherwise
+	// something (like crew.ResolveChildren) needs to find the
+	// Spec for the ChildSource before Compile.
+	//
+	// When a machine is at a composite node, its State.Child
+	// holds the child's State, and Step first considers this
+	// node's Branches.  If none of those branches is followed,
+	// then the child takes a Step (with the machine's Bindings).
+	// A composite node cannot have an Action.
+	Child *Spec `json:"-" yaml:"-"`
+
+	// ChildStart is the name of the child's initial node.  The
+	// default is DefaultChildStart.
+	ChildStart string `json:"childStart,omitempty" yaml:"childStart,omitempty"`
 }
 
 // Copy makes a deep copy of the Node.
+//
+// The Child (if any) is not copied.
 func (n *Node) Copy() *Node {
-	return &Node{
+	c := &Node{
 		Doc:          n.Doc,
 		Action:       n.Action,
 		ActionSource: n.ActionSource.Copy(),
+		Entry:        n.Entry,
+		EntrySource:  n.EntrySource.Copy(),
+		Exit:         n.Exit,
+		ExitSource:   n.ExitSource.Copy(),
 		Branches:     n.Branches.Copy(),
+		ChildSource:  n.ChildSource.Copy(),
+		Child:        n.Child,
+		ChildStart:   n.ChildStart,
 	}
+	if n.ChildSource != nil && n.Child != nil && n.Child == n.ChildSource.Inline {
+		c.Child = c.ChildSource.Inline
+	}
+	return c
 }
 
 // Terminal determines if a node has no branches.
@@ -364,38 +594,43 @@ type Branches struct {
 	Type string `json:"type,omitempty" yaml:",omitempty"`
 
 	// Modes is a set of flags that can inform Branch processing
-	// and analysis.  Currently no modes are considered.
-	//
-	// Example: "exclusive" might declare that the Branch patterns
-	// should be mututally exclusive.
-	//
-	// ToDo: Use a real type instead of string.
-	Modes []string `json:"modes,omitempty" yaml:",omitempty"`
+	// and analysis.  See Exclusive and Exhaustive.  Unknown modes
+	// are ignored.
+	Modes BranchModes `json:"modes,omitempty" yaml:",omitempty"`
+
+	// Fallback is the target node for an Exhaustive Branches
+	// when no Branch matches.  If empty, the Spec's ErrorNode is
+	// the fallback.
+	Fallback string `json:"fallback,omitempty" yaml:",omitempty"`
 
 	// Branches is the list (ordered) of possible transitions to
 	// the next state (if any).
 	//
 	// No Branches means that this node is terminal.
 	Branches []*Branch `json:"branches,omitempty" yaml:",omitempty"`
+
+	// index (if any) is built by Spec.Compile for "message"
+	// Branches.
+	index *branchIndex
 }
 
 // Copy makes a deep copy of the Branches.
+//
+// The index (if any) that Spec.Compile built is shared.
 func (b *Branches) Copy() *Branches {
 	if b == nil {
 		return nil
 	}
-	modes := make([]string, len(b.Modes))
-	for i, mode := range b.Modes {
-		modes[i] = mode
-	}
 	bs := make([]*Branch, len(b.Branches))
 	for i, br := range b.Branches {
 		bs[i] = br.Copy()
 	}
 	return &Branches{
 		Type:     b.Type,
-		Modes:    modes,
+		Modes:    b.Modes.Copy(),
+		Fallback: b.Fallback,
 		Branches: bs,
+		index:    b.index,
 	}
 }
 
@@ -405,6 +640,11 @@ type Branch struct {
 	// bindings -- depending on the Branches.Type.
 	Pattern interface{} `json:"pattern,omitempty" yaml:",omitempty"`
 
+	// Compiled is the compiled Pattern, which Spec.Compile
+	// provides (unless the Pattern has a problem) using the
+	// Spec's Matcher.
+	Compiled *Pattern `json:"-" yaml:"-"`
+
 	// Guard is an optional procedure that will prevent the
 	// transition if the procedure returns nil Bindings.
 	Guard Action `json:"-" yaml:"-"`
@@ -419,15 +659,18 @@ type Branch struct {
 	Target string `json:"target,omitempty" yaml:",omitempty"`
 }
 
-// Copy makes a shallow copy of the Branch.
+// Copy makes a deep copy of the Branch.
+//
+// The Guard and Compiled pattern (if any) are shared.
 func (b *Branch) Copy() *Branch {
 	if b == nil {
 		return nil
 	}
 	return &Branch{
-		Pattern:     b.Pattern,
+		Pattern:     copyValue(b.Pattern),
+		Compiled:    b.Compiled,
 		Guard:       b.Guard,
-		GuardSource: b.GuardSource,
+		GuardSource: b.GuardSource.Copy(),
 		Target:      b.Target,
 	}
 }
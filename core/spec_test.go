@@ -14,6 +14,7 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"strings"
 	"testing"
@@ -198,6 +199,58 @@ func TestCompile(t *testing.T) {
 	}
 }
 
+func TestSpecNoNewMachinesJSON(t *testing.T) {
+	for _, js := range []string{
+		`{"name":"new","noNewMachines":true}`,
+		`{"name":"old","noNewMachined":true}`,
+	} {
+		var s Spec
+		if err := json.Unmarshal([]byte(js), &s); err != nil {
+			t.Fatal(err)
+		}
+		if !s.NoNewMachines {
+			t.Fatal(js)
+		}
+	}
+
+	var s Spec
+	if err := json.Unmarshal([]byte(`{"name":"neither","fanOut":true}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "neither" || !s.FanOut || s.NoNewMachines {
+		t.Fatal(s)
+	}
+}
+
+func TestCompilePatterns(t *testing.T) {
+	s := &Spec{
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{Pattern: map[string]interface{}{"?x": 1, "?y": 2}, Target: "bad"},
+						{Pattern: map[string]interface{}{"n": "?n"}, Target: "good"},
+					},
+				},
+			},
+		},
+	}
+	if err := s.Compile(context.Background(), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	bs := s.Nodes["start"].Branches.Branches
+	if bs[0].Compiled != nil {
+		t.Fatal("bad pattern shouldn't have compiled")
+	}
+	if bs[1].Compiled == nil {
+		t.Fatal("pattern wasn't compiled")
+	}
+	if c := bs[1].Copy(); c.Compiled != bs[1].Compiled {
+		t.Fatal("Copy didn't share the compiled pattern")
+	}
+}
+
 func TestDefaultPatternParser(t *testing.T) {
 	tests := []struct {
 		description    string
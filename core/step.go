@@ -16,14 +16,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"time"
 
 	. "github.com/Comcast/sheens/match"
 )
 
 var (
 	// TracesInitialCap is the initial capacity for Traces buffers.
+	//
+	// See Control.TraceLevel to reduce (or eliminate) traces.
 	TracesInitialCap = 16
-	// ToDo: Provide a configurable limit or implement a rolling buffer.
 
 	// EmittedMessagesInitialCap is the initial capacity for
 	// slices of emitted messages.
@@ -79,6 +81,7 @@ const (
 	Limited                             // Too many steps.
 	InternalError                       // What else to do?
 	BreakpointReached                   // During a Walk.
+	DeadlineExceeded                    // Control.WalkTimeout expired.
 )
 
 // State represents the current state of a machine given a
@@ -86,24 +89,74 @@ const (
 type State struct {
 	NodeName string   `json:"node"`
 	Bs       Bindings `json:"bs"`
+
+	// Child is the State of the child of a composite node (see
+	// Node.ChildSource).  A child shares its parent's Bindings,
+	// so the Child's Bs is nil.
+	Child *State `json:"child,omitempty" yaml:",omitempty"`
+
+	// Regions maps each of the Spec's Regions (if any) to that
+	// region's current node.  See Spec.Regions.
+	Regions map[string]string `json:"regions,omitempty" yaml:",omitempty"`
 }
 
 func (s *State) String() string {
 	if s == nil {
 		return "nil"
 	}
+	path := s.NodeName
+	for c := s.Child; c != nil; c = c.Child {
+		path += ">" + c.NodeName
+	}
 	js, err := json.Marshal(s.Bs)
 	if err != nil {
-		return s.NodeName + "/{*}"
+		return path + "/{*}"
 	}
-	return s.NodeName + "/" + string(js)
+	return path + "/" + string(js)
+}
+
+// Path returns the node names from this State down through its
+// Child (if any).
+func (s *State) Path() []string {
+	var acc []string
+	for ; s != nil; s = s.Child {
+		acc = append(acc, s.NodeName)
+	}
+	return acc
 }
 
 // Copy makes a deep copy of the State.
 func (s *State) Copy() *State {
+	if s == nil {
+		return nil
+	}
 	return &State{
 		NodeName: s.NodeName,
 		Bs:       s.Bs.Copy(),
+		Child:    s.Child.copyChild(),
+		Regions:  s.copyRegions(),
+	}
+}
+
+func (s *State) copyRegions() map[string]string {
+	if s.Regions == nil {
+		return nil
+	}
+	acc := make(map[string]string, len(s.Regions))
+	for r, n := range s.Regions {
+		acc[r] = n
+	}
+	return acc
+}
+
+// copyChild copies a child State, which has no Bindings of its own.
+func (s *State) copyChild() *State {
+	if s == nil {
+		return nil
+	}
+	return &State{
+		NodeName: s.NodeName,
+		Child:    s.Child.copyChild(),
 	}
 }
 
@@ -118,17 +171,62 @@ type Control struct {
 	// Limit is the maximum number of Steps that a Walk() can take.
 	Limit       int                   `json:"limit"`
 	Breakpoints map[string]Breakpoint `json:"-"`
+
+	// BreakpointSpecs are declarative breakpoints, which (unlike
+	// Breakpoints) survive serialization.  A Walk that stops at
+	// one of these reports its key as the Walked.BreakpointId.
+	BreakpointSpecs map[string]*BreakpointSpec `json:"breakpoints,omitempty" yaml:"breakpoints,omitempty"`
+
+	// Observer, if not nil, receives StepEvents as they happen,
+	// and then the StepEvents aren't kept in each Stride's
+	// Traces.  (Traces from Actions and guards are still kept.)
+	// Without an Observer, StepEvents are collected in each
+	// Stride's Traces (see CollectingObserver).
+	Observer Observer `json:"-"`
+
+	// TraceLevel determines which StepEvents are reported.  The
+	// default is TraceAll.  Use TraceOff to turn off tracing
+	// entirely, or use TraceExplain to learn why patterns didn't
+	// match.
+	TraceLevel TraceLevel `json:"traceLevel,omitempty"`
+
+	// ActionTimeout, if positive, is the budget for each
+	// execution of an Action or a guard.  An Action that exceeds
+	// its budget results in an ActionError (with code
+	// CodeActionDeadlineExceeded), which ActionErrorBranches can
+	// handle.
+	ActionTimeout time.Duration `json:"actionTimeout,omitempty"`
+
+	// WalkTimeout, if positive, is the budget for an entire
+	// Walk().  When the budget is exhausted, the Walk stops with
+	// DeadlineExceeded.
+	WalkTimeout time.Duration `json:"walkTimeout,omitempty"`
 }
 
-// Copy will return you a copy of the Control object
+// Copy will return you a copy of the Control object.
+//
+// The copy gets its own BreakpointSpecs (see BreakpointSpec.Copy).
+// The Breakpoints (functions) and the Observer are shared.
 func (c *Control) Copy() *Control {
 	bs := make(map[string]Breakpoint, len(c.Breakpoints))
 	for id, b := range c.Breakpoints {
 		bs[id] = b
 	}
+	var specs map[string]*BreakpointSpec
+	if c.BreakpointSpecs != nil {
+		specs = make(map[string]*BreakpointSpec, len(c.BreakpointSpecs))
+		for id, b := range c.BreakpointSpecs {
+			specs[id] = b.Copy()
+		}
+	}
 	return &Control{
-		Limit:       c.Limit,
-		Breakpoints: bs,
+		Limit:           c.Limit,
+		Breakpoints:     bs,
+		BreakpointSpecs: specs,
+		Observer:        c.Observer,
+		TraceLevel:      c.TraceLevel,
+		ActionTimeout:   c.ActionTimeout,
+		WalkTimeout:     c.WalkTimeout,
 	}
 }
 
@@ -201,6 +299,10 @@ type Stride struct {
 
 	// Consumed is the message (if any) that was consumed by the step.
 	Consumed interface{} `json:"consumed,omitempty" yaml:",omitempty"`
+
+	// Spawned holds the initial States (if any) for new machines
+	// that the step created.  See Spec.FanOut.
+	Spawned []*State `json:"spawned,omitempty" yaml:",omitempty"`
 }
 
 // New Stride will return an default Stride
@@ -215,7 +317,18 @@ func NewStride() *Stride {
 //
 // The given pending message (if any) will be consumed by "message"
 // type Branches.
+//
+// If the Spec has Regions, each region takes a step.  See
+// Spec.Regions.
 func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Control, props StepProps) (*Stride, error) {
+	if 0 < len(s.Regions) {
+		return s.stepRegions(ctx, st, pending, c, props)
+	}
+	return s.step(ctx, st, pending, c, props)
+}
+
+// step is Step for a single node (ignoring any Regions).
+func (s *Spec) step(ctx context.Context, st *State, pending interface{}, c *Control, props StepProps) (*Stride, error) {
 
 	if c == nil {
 		c = DefaultControl
@@ -249,13 +362,18 @@ func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Cont
 		return nil, &UncompiledAction{s, st.NodeName}
 	}
 
+	if n.Child != nil {
+		return s.stepComposite(ctx, n, st, pending, c, props)
+	}
+
 	haveAction := n.Action != nil
 
 	// If we have an action, branch type must not be "message".
 	//
 	// If we insisted that interpreters could not execute code
-	// that does IO, then we could remove this limitation.  ToDo:
-	// Do that.
+	// that does IO, then we could remove this limitation.  A
+	// node with "message" branching can have an Entry action
+	// instead.
 	if haveAction && n.Branches != nil && n.Branches.Type == "message" {
 		return nil, &BadBranching{s, st.NodeName}
 	}
@@ -266,13 +384,35 @@ func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Cont
 		e      *Execution
 		bs     = st.Bs
 		stride = NewStride()
+		o      = c.observing(ctx, stride, st.NodeName)
 	)
 	stride.From = st.Copy()
 
+	if o.on(StepStarted) {
+		o.report(&StepEvent{
+			Kind:    StepStarted,
+			Bs:      bs,
+			Message: pending,
+		})
+	}
+
 	if haveAction {
-		e, err = n.Action.Exec(ctx, bs, props)
+		e, err = execWithin(ctx, n.Action, bs, props, c.ActionTimeout)
+		if o.on(ActionExecuted) {
+			ev := &StepEvent{
+				Kind: ActionExecuted,
+				Bs:   bs,
+			}
+			if e != nil {
+				ev.Result = e.Bs
+			}
+			if err != nil {
+				ev.Error = err.Error()
+			}
+			o.report(ev)
+		}
 		if e != nil {
-			stride.AddEvents(e.Events)
+			o.addEvents(stride, e.Events)
 			if e.Bs == nil {
 				// If the action returned nil
 				// bindings, use empty bindings.
@@ -284,9 +424,19 @@ func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Cont
 		if err == nil {
 			bs = e.Bs
 		} else {
+			ae := &ActionError{
+				Spec:     s,
+				NodeName: givenState.NodeName,
+				Err:      err,
+			}
+			if n.ActionSource != nil {
+				ae.Interpreter = n.ActionSource.Interpreter
+			}
+			err = ae
 			// Bind "actionError" to the error string.
 			bs.Extend("actionError", err.Error())
 			bs.Extend("error", err.Error())
+			bs.Extend("errorDetails", ErrorDetails(err))
 			if !s.ActionErrorBranches {
 				if s.ActionErrorNode == "" {
 					return nil, err
@@ -295,6 +445,7 @@ func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Cont
 					NodeName: s.ActionErrorNode,
 					Bs:       bs.Copy(),
 				}
+				o.transitioned(stride.To)
 				return stride, nil
 			}
 		}
@@ -303,17 +454,25 @@ func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Cont
 	}
 
 	// Now evaluate the branches (if any).
-	st, ts, consumed, err := n.Branches.consider(ctx, bs, pending, c, props)
+	st, spawned, consumed, err := n.Branches.consider(ctx, s, bs, pending, o, props)
+	if ne, is := err.(*NotExclusive); is {
+		ne.NodeName = givenState.NodeName
+	}
 	if consumed {
 		stride.Consumed = pending
 	}
 
-	if ts != nil {
-		stride.Traces.Add(ts.Messages...)
+	for _, sp := range spawned {
+		stride.Spawned = append(stride.Spawned, sp.Copy())
 	}
 
 	if st != nil {
 		stride.To = st.Copy()
+		if err == nil {
+			if err = s.cross(ctx, n, givenState.NodeName, stride, o, c, props); err != nil {
+				stride.To = nil
+			}
+		}
 	}
 
 	if st == nil && haveAction {
@@ -350,7 +509,13 @@ func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Cont
 		if bs == nil {
 			bs = NewBindings()
 		}
-		bs, _ = bs.Extendm("error", "Action node followed no branch",
+		msg := "Action node followed no branch"
+		bs, _ = bs.Extendm("error", msg,
+			"errorDetails", map[string]interface{}{
+				"code":  CodeActionNoBranch,
+				"node":  givenState.NodeName,
+				"cause": msg,
+			},
 			"lastNode", givenState.NodeName,
 			"lastBindings", givenState.Bs.Copy())
 		stride.To = &State{
@@ -359,28 +524,23 @@ func (s *Spec) Step(ctx context.Context, st *State, pending interface{}, c *Cont
 		}
 	}
 
+	o.transitioned(stride.To)
+
 	return stride, err
 }
 
 // consider considers the Branches to determine the next state.
 //
-// If this method returns more than one set of Bindings, new machines
-// will be created!  ToDo: A switch to warn or prevent.
-func (b *Branches) consider(ctx context.Context, bs Bindings, pending interface{}, c *Control, props StepProps) (*State, *Traces, bool, error) {
+// If the Spec allows FanOut, this method can also return States for
+// new machines.
+func (b *Branches) consider(ctx context.Context, s *Spec, bs Bindings, pending interface{}, o *observing, props StepProps) (*State, []*State, bool, error) {
 
 	// This method will return an error only if its call to try()
-	// returns an error.
-
-	ts := NewTraces()
-
-	ts.Add(map[string]interface{}{
-		"consider": b,
-		"bs":       bs,
-		"pending":  pending,
-	})
+	// returns an error or if more than one branch of an Exclusive
+	// Branches matched.
 
 	if b == nil {
-		return nil, ts, false, nil
+		return nil, nil, false, nil
 	}
 
 	var (
@@ -390,38 +550,88 @@ func (b *Branches) consider(ctx context.Context, bs Bindings, pending interface{
 
 	if consumer {
 		if pending == nil {
-			return nil, ts, consumer, nil
+			return nil, nil, consumer, nil
 		}
 		against = pending
 	} else {
 		against = map[string]interface{}(bs)
 	}
 
-	for _, br := range b.Branches {
-		to, traces, err := br.try(ctx, bs, against, props)
+	var (
+		// exclusive means we try every branch to make sure
+		// that at most one matches.
+		exclusive = b.Modes.Has(Exclusive)
 
-		ts.Add(traces.Messages...)
+		first        *State
+		firstSpawned []*State
+		matched      []int
+	)
 
-		ts.Add(map[string]interface{}{
-			"tried": br,
-			"to":    to,
-			"err":   err,
-		})
+	// The index (if any) gives the Branches that could match the
+	// message.  The other Branches aren't tried (or reported).
+	var candidates []int
+	if consumer {
+		candidates = b.index.candidates(b, against)
+	}
+
+	n := len(b.Branches)
+	if candidates != nil {
+		n = len(candidates)
+	}
+
+	for j := 0; j < n; j++ {
+		i := j
+		if candidates != nil {
+			i = candidates[j]
+		}
+		br := b.Branches[i]
+		to, spawned, err := br.try(ctx, s, bs, against, o, props)
+
+		if o.on(BranchTried) {
+			e := &StepEvent{
+				Kind:   BranchTried,
+				Branch: br,
+				To:     to,
+			}
+			if err != nil {
+				e.Error = err.Error()
+			}
+			o.report(e)
+		}
 
 		if err != nil {
-			ts.Add(map[string]interface{}{
-				"err": "forwarded",
-			})
-			return nil, ts, consumer, err
+			return nil, nil, consumer, err
+		}
+		if to == nil {
+			continue
+		}
+		if !exclusive {
+			return to, spawned, consumer, nil
+		}
+		if first == nil {
+			first, firstSpawned = to, spawned
 		}
-		if to != nil {
-			return to, ts, consumer, nil
+		matched = append(matched, i)
+	}
+
+	if 1 < len(matched) {
+		return nil, nil, consumer, &NotExclusive{
+			Spec:     s,
+			Branches: matched,
 		}
 	}
 
+	if first != nil {
+		return first, firstSpawned, consumer, nil
+	}
+
 	// No branch was traversed.
 
-	return nil, ts, consumer, nil
+	if b.Modes.Has(Exhaustive) {
+		return b.fallback(s, bs, pending), nil, consumer, nil
+	}
+
+	return nil, nil, consumer, nil
 }
 
 // IsBranchTargetVariable determines if the Branch Target
@@ -445,94 +655,133 @@ func (b *Branch) target(bs Bindings) string {
 }
 
 // try evaluates this Branch to see if it applies.
-func (b *Branch) try(ctx context.Context, bs Bindings, against interface{}, props StepProps) (*State, *Traces, error) {
-	ts := NewTraces()
-
-	ts.Add(map[string]interface{}{
-		"try":     b,
-		"bs":      bs,
-		"against": against,
-	})
-
-	var bss []Bindings
+//
+// When the Spec allows FanOut, each set of bindings beyond the first
+// results in a spawned State.
+func (b *Branch) try(ctx context.Context, s *Spec, bs Bindings, against interface{}, o *observing, props StepProps) (*State, []*State, error) {
+	var (
+		bss    []Bindings
+		extras []Bindings
+		fanOut = s.FanOut && !s.NoNewMachines
+	)
 
 	if b.Pattern != nil {
 		var err error
-		if bss, err = DefaultMatcher.Match(b.Pattern, against, bs); err != nil {
-			ts.Add(map[string]interface{}{
-				"error":   err.Error(),
-				"pattern": b.Pattern,
-			})
-			return nil, ts, err
+		if b.Compiled != nil {
+			bss, err = b.Compiled.Match(against, bs)
+		} else {
+			bss, err = s.Matcher().Match(b.Pattern, against, bs)
+		}
+		if o.on(PatternMatched) {
+			e := &StepEvent{
+				Kind:    PatternMatched,
+				Branch:  b,
+				Message: against,
+				Bs:      bs,
+				Bss:     bss,
+			}
+			if err != nil {
+				e.Error = err.Error()
+			} else if len(bss) == 0 && o.level == TraceExplain {
+				if b.Compiled != nil {
+					e.Explanation = b.Compiled.Explain(against, bs)
+				} else {
+					e.Explanation = s.Matcher().Explain(b.Pattern, against, bs)
+				}
+			}
+			o.report(e)
+		}
+		if err != nil {
+			return nil, nil, &PatternError{s, o.node, err}
 		}
 	} else {
 		bss = []Bindings{bs}
 	}
 
-	ts.Add(map[string]interface{}{
-		"bss": bss,
-	})
-
 	if b.Guard == nil {
 		switch len(bss) {
 		case 0:
 			// No match
-			return nil, ts, nil
+			return nil, nil, nil
 		case 1:
 			bs = bss[0]
 		default:
-			return nil, ts, TooManyBindingss
+			if !fanOut {
+				return nil, nil, TooManyBindingss
+			}
+			bs = bss[0]
+			extras = bss[1:]
 		}
 	} else {
 		bs = nil
 		for _, candidate := range bss {
-			ts.Add(map[string]interface{}{
-				"guardingWith": candidate,
-			})
+			exe, err := execWithin(ctx, b.Guard, candidate, props, o.timeout)
 
-			exe, err := b.Guard.Exec(ctx, candidate, props)
+			if exe != nil && o.all() {
+				o.traces.Add(exe.Events.Traces.Messages...)
+			}
 
-			if exe != nil {
-				ts.Add(exe.Events.Traces.Messages...)
+			if o.on(GuardEvaluated) {
+				e := &StepEvent{
+					Kind:   GuardEvaluated,
+					Branch: b,
+					Bs:     candidate,
+				}
+				if exe != nil {
+					e.Result = exe.Bs
+				}
+				if err != nil {
+					e.Error = err.Error()
+				}
+				o.report(e)
 			}
 
 			if err != nil {
-				ts.Add(map[string]interface{}{
-					"error": err.Error(),
-				})
-
-				return nil, ts, err
+				interpreter := ""
+				if b.GuardSource != nil {
+					interpreter = b.GuardSource.Interpreter
+				}
+				return nil, nil, &ActionError{
+					Spec:        s,
+					NodeName:    o.node,
+					Interpreter: interpreter,
+					Guard:       true,
+					Err:         err,
+				}
 			}
 
-			ts.Add(map[string]interface{}{
-				"guardReturned": exe.Bs,
-			})
-
 			if exe.Bs != nil {
 				// Guard allowed us follow this branch.
-				bs = exe.Bs
-				break
+				if bs == nil {
+					bs = exe.Bs
+					if !fanOut {
+						break
+					}
+					continue
+				}
+				extras = append(extras, exe.Bs)
 			}
 		}
 	}
 
 	if bs == nil {
-		return nil, ts, nil
+		return nil, nil, nil
 	}
 
-	target := b.target(bs)
-
-	ts.Add(map[string]interface{}{
-		"bs":     bs,
-		"target": target,
-	})
-
 	st := &State{
 		Bs:       bs,
-		NodeName: target,
+		NodeName: b.target(bs),
 	}
 
-	return st, ts, nil
+	var spawned []*State
+	for _, bs := range extras {
+		spawned = append(spawned, &State{
+			Bs:       bs,
+			NodeName: b.target(bs),
+		})
+	}
+
+	return st, spawned, nil
 }
 
 // Walked represents a sequence of strides taken by a Walk().
@@ -571,6 +820,18 @@ func (w *Walked) To() *State {
 	return nil
 }
 
+// Spawned gathers the States for new machines (if any) from all the
+// Strides.  See Spec.FanOut.
+func (w *Walked) Spawned() []*State {
+	var acc []*State
+	for _, stride := range w.Strides {
+		for _, st := range stride.Spawned {
+			acc = append(acc, st.Copy())
+		}
+	}
+	return acc
+}
+
 // DoEmitted is a convenience method to iterate over messages emitted
 // by the Walked.
 func (w Walked) DoEmitted(f func(x interface{}) error) error {
@@ -623,7 +884,19 @@ func (s *Spec) Walk(ctx context.Context, st *State, pendings []interface{}, c *C
 
 	walked := newWalked(c.Limit)
 
+	if 0 < c.WalkTimeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.WalkTimeout)
+		defer cancel()
+	}
+
 	for i := 0; i < c.Limit; i++ {
+		if ctx.Err() == context.DeadlineExceeded {
+			walked.StoppedBecause = DeadlineExceeded
+			walked.Remaining = pendings
+			return walked, nil
+		}
+
 		for id, breakpoint := range c.Breakpoints {
 			if breakpoint(ctx, st) {
 				walked.StoppedBecause = BreakpointReached
@@ -637,6 +910,21 @@ func (s *Spec) Walk(ctx context.Context, st *State, pendings []interface{}, c *C
 		if 0 < len(pendings) {
 			pending = pendings[0]
 		}
+
+		if id, err := c.firedBreakpoint(func(b *BreakpointSpec) (bool, error) {
+			return b.Before(s.Matcher(), st, pending)
+		}); err != nil {
+			walked.StoppedBecause = InternalError
+			walked.Error = err
+			walked.Remaining = pendings
+			return walked, nil
+		} else if id != "" {
+			walked.StoppedBecause = BreakpointReached
+			walked.BreakpointId = id
+			walked.Remaining = pendings
+			return walked, nil
+		}
+
 		stride, err := s.Step(ctx, st, pending, c, props)
 
 		if stride == nil {
@@ -658,13 +946,20 @@ func (s *Spec) Walk(ctx context.Context, st *State, pendings []interface{}, c *C
 			if st.NodeName == "error" {
 				// We're already at an error.
 			} else {
+				details := ErrorDetails(err)
+				if _, have := details["node"]; !have {
+					details["node"] = st.NodeName
+				}
 				errorBs, _ := st.Bs.Extendm("error", err.Error(),
+					"errorDetails", details,
 					"lastNode", st.NodeName,
 					"lastBindings", st.Bs.Copy())
 				stride.To = &State{
 					NodeName: "error",
 					Bs:       errorBs,
+					Regions:  st.copyRegions(),
 				}
+				c.observing(ctx, stride, st.NodeName).transitioned(stride.To)
 			}
 		}
 
@@ -676,6 +971,20 @@ func (s *Spec) Walk(ctx context.Context, st *State, pendings []interface{}, c *C
 			pendings = pendings[1:]
 		}
 
+		if id, err := c.firedBreakpoint(func(b *BreakpointSpec) (bool, error) {
+			return b.After(s.Matcher(), stride)
+		}); err != nil {
+			walked.StoppedBecause = InternalError
+			walked.Error = err
+			walked.Remaining = pendings
+			return walked, nil
+		} else if id != "" {
+			walked.StoppedBecause = BreakpointReached
+			walked.BreakpointId = id
+			walked.Remaining = pendings
+			return walked, nil
+		}
+
 		if stride.To == nil {
 			// We went nowhere.
 			if 0 == len(pendings) {
@@ -404,6 +404,10 @@ func TestWalkBreakpoint(t *testing.T) {
 }
 
 func BenchmarkTurnstile(b *testing.B) {
+	benchmarkTurnstile(b, TraceAll)
+}
+
+func benchmarkTurnstile(b *testing.B, level TraceLevel) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
@@ -414,7 +418,8 @@ func BenchmarkTurnstile(b *testing.B) {
 	}
 
 	c := &Control{
-		Limit: 100,
+		Limit:      100,
+		TraceLevel: level,
 	}
 
 	ss := []string{
@@ -592,3 +597,192 @@ func TestActionStuck(t *testing.T) {
 		t.Fatal("Walked.To is nil")
 	}
 }
+
+func TestFanOut(t *testing.T) {
+	spec := &Spec{
+		Name:          "test",
+		PatternSyntax: "json",
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Type: "message",
+					Branches: []*Branch{
+						{
+							Pattern: `{"ids":["?id"]}`,
+							Target:  "listening",
+						},
+					},
+				},
+			},
+			"listening": {},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{
+		NodeName: "start",
+		Bs:       make(Bindings),
+	}
+
+	msg := Dwimjs(`{"ids":["a","b","c"]}`)
+
+	t.Run("off", func(t *testing.T) {
+		if _, err := spec.Step(ctx, st, msg, nil, nil); err != TooManyBindingss {
+			t.Fatalf("expected TooManyBindingss; got %v", err)
+		}
+	})
+
+	t.Run("on", func(t *testing.T) {
+		spec.FanOut = true
+		defer func() { spec.FanOut = false }()
+
+		walked, err := spec.Walk(ctx, st, []interface{}{msg}, DefaultControl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to := walked.To()
+		if to == nil || to.NodeName != "listening" {
+			t.Fatalf("went to %s", to)
+		}
+		spawned := walked.Spawned()
+		if len(spawned) != 2 {
+			t.Fatalf("spawned %s", JS(spawned))
+		}
+		ids := map[interface{}]bool{to.Bs["?id"]: true}
+		for _, sp := range spawned {
+			if sp.NodeName != "listening" {
+				t.Fatalf("spawned at %s", sp.NodeName)
+			}
+			ids[sp.Bs["?id"]] = true
+		}
+		if len(ids) != 3 {
+			t.Fatalf("ids %v", ids)
+		}
+	})
+
+	t.Run("no new machines", func(t *testing.T) {
+		spec.FanOut = true
+		spec.NoNewMachines = true
+		defer func() { spec.FanOut, spec.NoNewMachines = false, false }()
+
+		if _, err := spec.Step(ctx, st, msg, nil, nil); err != TooManyBindingss {
+			t.Fatalf("expected TooManyBindingss; got %v", err)
+		}
+	})
+}
+
+func TestBranchModes(t *testing.T) {
+	spec := &Spec{
+		Name: "test",
+		Nodes: map[string]*Node{
+			"start": {
+				Branches: &Branches{
+					Type:  "message",
+					Modes: BranchModes{Exclusive, Exhaustive},
+					Branches: []*Branch{
+						{
+							Pattern: Dwimjs(`{"likes":"tacos"}`),
+							Target:  "happy",
+						},
+						{
+							Pattern: Dwimjs(`{"likes":"chips"}`),
+							Target:  "happy",
+						},
+					},
+					Fallback: "confused",
+				},
+			},
+			"happy":    {},
+			"confused": {},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{
+		NodeName: "start",
+		Bs:       make(Bindings),
+	}
+
+	t.Run("matched", func(t *testing.T) {
+		stride, err := spec.Step(ctx, st, Dwimjs(`{"likes":"chips"}`), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stride.To.NodeName != "happy" {
+			t.Fatalf("went to %s", stride.To.NodeName)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		msg := Dwimjs(`{"likes":"queso"}`)
+		stride, err := spec.Step(ctx, st, msg, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stride.To.NodeName != "confused" {
+			t.Fatalf("went to %s", stride.To.NodeName)
+		}
+		if stride.Consumed == nil {
+			t.Fatal("didn't consume the message")
+		}
+		if _, have := stride.To.Bs["unmatched"]; !have {
+			t.Fatal(JS(stride.To.Bs))
+		}
+	})
+
+	t.Run("overlap", func(t *testing.T) {
+		spec := spec.Copy("")
+		spec.Nodes["start"].Branches.Branches[1].Pattern = Dwimjs(`{"likes":"?x"}`)
+		if err := spec.Compile(ctx, nil, true); err == nil {
+			t.Fatal("should have complained about overlapping patterns")
+		}
+	})
+
+	t.Run("unified", func(t *testing.T) {
+		// The simple check can't see that these patterns are
+		// exclusive, but unification can.
+		spec := spec.Copy("")
+		spec.Nodes["start"].Branches.Branches[0].Pattern = Dwimjs(`{"likes":"?x","wants":"?x"}`)
+		spec.Nodes["start"].Branches.Branches[1].Pattern = Dwimjs(`{"likes":"chips","wants":"tacos"}`)
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("runtime", func(t *testing.T) {
+		spec := spec.Copy("")
+		spec.Nodes["start"].Branches.Branches[1].Pattern = Dwimjs(`{"likes":"?x"}`)
+		spec.Nodes["start"].Branches.Branches[1].Guard = &FuncAction{
+			F: func(ctx context.Context, bs Bindings, props StepProps) (*Execution, error) {
+				return NewExecution(bs), nil
+			},
+		}
+		if err := spec.Compile(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+		_, err := spec.Step(ctx, st, Dwimjs(`{"likes":"tacos"}`), nil, nil)
+		if ne, is := err.(*NotExclusive); !is || ne.NodeName != "start" || len(ne.Branches) != 2 {
+			t.Fatalf("expected NotExclusive; got %v", err)
+		}
+		// A branch pattern can match the error's details.
+		bss, err := Match(Dwimjs(`{"branches":[0,1]}`), ErrorDetails(err), NewBindings())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bss) != 1 {
+			t.Fatal(bss)
+		}
+	})
+}
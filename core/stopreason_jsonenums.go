@@ -13,6 +13,7 @@ var (
 		"Limited":           Limited,
 		"InternalError":     InternalError,
 		"BreakpointReached": BreakpointReached,
+		"DeadlineExceeded":  DeadlineExceeded,
 	}
 
 	_StopReasonValueToName = map[StopReason]string{
@@ -20,6 +21,7 @@ var (
 		Limited:           "Limited",
 		InternalError:     "InternalError",
 		BreakpointReached: "BreakpointReached",
+		DeadlineExceeded:  "DeadlineExceeded",
 	}
 )
 
@@ -31,6 +33,7 @@ func init() {
 			interface{}(Limited).(fmt.Stringer).String():           Limited,
 			interface{}(InternalError).(fmt.Stringer).String():     InternalError,
 			interface{}(BreakpointReached).(fmt.Stringer).String(): BreakpointReached,
+			interface{}(DeadlineExceeded).(fmt.Stringer).String():  DeadlineExceeded,
 		}
 	}
 }
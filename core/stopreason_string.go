@@ -12,11 +12,12 @@ func _() {
 	_ = x[Limited-1]
 	_ = x[InternalError-2]
 	_ = x[BreakpointReached-3]
+	_ = x[DeadlineExceeded-4]
 }
 
-const _StopReason_name = "DoneLimitedInternalErrorBreakpointReached"
+const _StopReason_name = "DoneLimitedInternalErrorBreakpointReachedDeadlineExceeded"
 
-var _StopReason_index = [...]uint8{0, 4, 11, 24, 41}
+var _StopReason_index = [...]uint8{0, 4, 11, 24, 41, 57}
 
 func (i StopReason) String() string {
 	if i < 0 || i >= StopReason(len(_StopReason_index)-1) {
@@ -17,6 +17,8 @@ import (
 	"math/rand"
 	"strings"
 	"time"
+
+	. "github.com/Comcast/sheens/match"
 )
 
 // alphabet is used by Gensym.
@@ -113,3 +115,33 @@ func Unquestion(p string) string {
 	}
 	return p
 }
+
+// copyValue makes a deep copy of a JSON-like value.
+//
+// Maps and slices are copied; everything else is shared.
+func copyValue(x interface{}) interface{} {
+	switch vv := x.(type) {
+	case map[string]interface{}:
+		acc := make(map[string]interface{}, len(vv))
+		for k, v := range vv {
+			acc[k] = copyValue(v)
+		}
+		return acc
+	case Bindings:
+		acc := make(Bindings, len(vv))
+		for k, v := range vv {
+			acc[k] = copyValue(v)
+		}
+		return acc
+	case []interface{}:
+		acc := make([]interface{}, len(vv))
+		for i, v := range vv {
+			acc[i] = copyValue(v)
+		}
+		return acc
+	case []string:
+		return append([]string(nil), vv...)
+	default:
+		return x
+	}
+}
@@ -14,6 +14,7 @@ package crew
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/Comcast/sheens/core"
 )
@@ -67,31 +68,161 @@ func (m *Machine) Copy() *Machine {
 	}
 }
 
-// SpecSource aspires to hold the origin of a specification.
+// Boot runs the Boot (if any) of the machine's Spec and updates the
+// machine's State.  See core.Spec.ExecBoot.
 //
-// Currently a source for a Spec can either be a name, a URL, or maybe
-// given explicitly as a string in an unspecified syntax.
-type SpecSource struct {
-	Name   string     `json:"name,omitempty" yaml:"name,omitempty"`
-	URL    string     `json:"url,omitempty" yaml:"url,omitempty"`
-	Source string     `json:"source,omitempty" yaml:"source,omitempty"`
-	Inline *core.Spec `json:"inline,omitempty" yaml:",omitempty"`
+// The returned Walked (if any) has a single Stride, which has any
+// messages the Boot emitted.
+//
+// Not thread-safe.
+func (m *Machine) Boot(ctx context.Context, reason string, props core.StepProps) (*core.Walked, error) {
+	return m.lifecycle(ctx, true, reason, props)
 }
 
-// NewSpecSource creates a SpecSource with the given name.
-func NewSpecSource(name string) *SpecSource {
-	return &SpecSource{
-		Name: name,
+// Toob runs the Toob (if any) of the machine's Spec and updates the
+// machine's State.  See core.Spec.ExecToob.
+//
+// Not thread-safe.
+func (m *Machine) Toob(ctx context.Context, reason string, props core.StepProps) (*core.Walked, error) {
+	return m.lifecycle(ctx, false, reason, props)
+}
+
+func (m *Machine) lifecycle(ctx context.Context, boot bool, reason string, props core.StepProps) (*core.Walked, error) {
+	if m.Specter == nil || m.State == nil {
+		return nil, nil
+	}
+	spec := m.Specter.Spec()
+	if spec == nil {
+		return nil, nil
+	}
+
+	var (
+		stride *core.Stride
+		err    error
+	)
+	if boot {
+		stride, err = spec.ExecBoot(ctx, m.State, reason, props)
+	} else {
+		stride, err = spec.ExecToob(ctx, m.State, reason, props)
+	}
+	if stride == nil {
+		return nil, err
+	}
+
+	walked := &core.Walked{
+		Strides: []*core.Stride{stride},
+	}
+	if err != nil {
+		walked.StoppedBecause = core.InternalError
+		walked.Error = err
+		return walked, err
+	}
+
+	m.State = stride.To.Copy()
+
+	return walked, nil
+}
+
+// SetSpec migrates the machine's State to the given Spec (see
+// Migrate), switches the machine to that Spec, and then runs that
+// Spec's Boot with reason core.BootUpdate.
+//
+// If the State can't be migrated, the machine is left alone, and the
+// error (a *core.MigrationError) is returned.
+//
+// If the machine's Specter is a *core.UpdatableSpec, the given Spec
+// is given to that UpdatableSpec's SetSpec.  Other machines that
+// share that UpdatableSpec will need to be migrated and booted
+// separately.  See Crew.UpdateSpec.
+//
+// Not thread-safe.
+func (m *Machine) SetSpec(ctx context.Context, spec *core.Spec, props core.StepProps) (*core.Walked, error) {
+	st, err := m.Migrate(ctx, spec, props)
+	if err != nil {
+		return nil, err
+	}
+	m.State = st
+	if u, is := m.Specter.(*core.UpdatableSpec); is {
+		if err := u.SetSpec(spec); err != nil {
+			return nil, err
+		}
+	} else {
+		m.Specter = spec
+	}
+	m.RecordSpecHash()
+	return m.Boot(ctx, core.BootUpdate, props)
+}
+
+// Migrate returns the machine's State migrated from the machine's
+// current Spec (if any) to the given Spec.  See core.Spec.Migrate.
+//
+// The machine itself is not changed.
+func (m *Machine) Migrate(ctx context.Context, spec *core.Spec, props core.StepProps) (*core.State, error) {
+	var from *core.Spec
+	if m.Specter != nil {
+		from = m.Specter.Spec()
 	}
+	return spec.Migrate(ctx, from, m.State, props)
 }
 
-// Copy makes a (deep?) copy of the given SpecSource.
-func (s *SpecSource) Copy() *SpecSource {
+// SpecHash returns the Id (see core.Spec.Hash) of the machine's
+// current Spec (if any).
+func (m *Machine) SpecHash() string {
+	if m.Specter == nil {
+		return ""
+	}
+	spec := m.Specter.Spec()
+	if spec == nil {
+		return ""
+	}
+	return spec.Id
+}
+
+// SpecChanged reports whether the machine's SpecSource records a
+// Hash that differs from the Id of the machine's current Spec.
+//
+// A persisted machine whose Spec changed (say, after a restart) will
+// report true until RecordSpecHash is called.
+func (m *Machine) SpecChanged() bool {
+	if m.SpecSource == nil || m.SpecSource.Hash == "" {
+		return false
+	}
+	hash := m.SpecHash()
+	return hash != "" && hash != m.SpecSource.Hash
+}
+
+// RecordSpecHash records the Id of the machine's current Spec in the
+// machine's SpecSource (which is copied first since it might be
+// shared).
+//
+// Not thread-safe.
+func (m *Machine) RecordSpecHash() {
+	if m.SpecSource == nil {
+		return
+	}
+	hash := m.SpecHash()
+	if hash == m.SpecSource.Hash {
+		return
+	}
+	src := m.SpecSource.Copy()
+	src.Hash = hash
+	m.SpecSource = src
+}
+
+// SpawnedId generates an id for a new machine spawned by the machine
+// with the given id.  See core.Spec.FanOut.
+func SpawnedId(parent string) string {
+	return parent + "-" + core.Gensym(8)
+}
+
+// SpecSource aspires to hold the origin of a specification.  See
+// core.SpecSource.
+type SpecSource = core.SpecSource
+
+// NewSpecSource creates a SpecSource with the given name.
+func NewSpecSource(name string) *SpecSource {
 	return &SpecSource{
-		Name:   s.Name,
-		URL:    s.URL,
-		Source: s.Source,
-		Inline: s.Inline,
+		Name: name,
 	}
 }
 
@@ -99,3 +230,55 @@ func (s *SpecSource) Copy() *SpecSource {
 type SpecProvider interface {
 	FindSpec(ctx context.Context, s *SpecSource) (*core.Spec, error)
 }
+
+// SpecProviderFunc makes a function a SpecProvider.
+type SpecProviderFunc func(ctx context.Context, s *SpecSource) (*core.Spec, error)
+
+// FindSpec calls the function.
+func (f SpecProviderFunc) FindSpec(ctx context.Context, s *SpecSource) (*core.Spec, error) {
+	return f(ctx, s)
+}
+
+// MaxChildDepth limits how deeply ResolveChildren will go.  This
+// limit protects against a Spec that (eventually) embeds itself.
+var MaxChildDepth = 16
+
+// ResolveChildren uses the given SpecProvider to find the child Spec
+// for each composite node (see core.Node.ChildSource) that doesn't
+// have an inline source.  Children of children are resolved, too.
+//
+// Call this function before Compile()ing the Spec.
+func ResolveChildren(ctx context.Context, spec *core.Spec, p SpecProvider) error {
+	return resolveChildren(ctx, spec, p, 0)
+}
+
+func resolveChildren(ctx context.Context, spec *core.Spec, p SpecProvider, depth int) error {
+	if MaxChildDepth < depth {
+		return fmt.Errorf("spec '%s' children nested too deeply (%d)", spec.Name, depth)
+	}
+	for name, n := range spec.Nodes {
+		if n == nil || n.ChildSource == nil {
+			continue
+		}
+		if n.ChildSource.Inline != nil {
+			if err := resolveChildren(ctx, n.ChildSource.Inline, p, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if n.Child == nil {
+			child, err := p.FindSpec(ctx, n.ChildSource)
+			if err != nil {
+				return fmt.Errorf("child of node '%s' in spec '%s': %w", name, spec.Name, err)
+			}
+			if child == nil {
+				return fmt.Errorf("child of node '%s' in spec '%s' not found", name, spec.Name)
+			}
+			n.Child = child
+		}
+		if err := resolveChildren(ctx, n.Child, p, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crew
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Comcast/sheens/core"
+)
+
+// MigrationReport describes the migration of machines to a new
+// version of a Spec.  See Crew.UpdateSpec and CheckMigration.
+type MigrationReport struct {
+	// DryRun is true when no machine was actually changed.
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Migrated lists the ids of the machines that were (or would
+	// be) migrated.
+	Migrated []string `json:"migrated"`
+
+	// Failed maps the id of each machine that could not be
+	// migrated to the reason.
+	Failed map[string]string `json:"failed,omitempty"`
+
+	// States has the new State for each migrated machine.
+	States map[string]*core.State `json:"-"`
+
+	// Walkeds has the Walked (if any) from the Boot of each
+	// migrated machine.
+	Walkeds map[string]*core.Walked `json:"-"`
+}
+
+// CheckMigration migrates the State of each of the given machines to
+// the given Spec.  See Machine.Migrate.
+//
+// The machines are not changed, so this function can check which
+// machines (say, ones just read from storage) would break with a new
+// version of a Spec.  A machine without a Specter has an unknown old
+// Spec, so only Migrations without a From apply to it.
+func CheckMigration(ctx context.Context, spec *core.Spec, ms map[string]*Machine, props core.StepProps) *MigrationReport {
+	r := &MigrationReport{
+		DryRun:   true,
+		Migrated: make([]string, 0, len(ms)),
+		Failed:   make(map[string]string),
+		States:   make(map[string]*core.State, len(ms)),
+	}
+	for mid, m := range ms {
+		st, err := m.Migrate(ctx, spec, props)
+		if err != nil {
+			r.Failed[mid] = err.Error()
+			continue
+		}
+		r.Migrated = append(r.Migrated, mid)
+		r.States[mid] = st
+	}
+	sort.Strings(r.Migrated)
+	return r
+}
+
+// specName returns the name of the machine's Spec.
+//
+// If the machine doesn't have a Specter, the name comes from the
+// machine's SpecSource.
+func (m *Machine) specName() string {
+	if m.Specter != nil {
+		if spec := m.Specter.Spec(); spec != nil {
+			return spec.Name
+		}
+	}
+	if m.SpecSource != nil {
+		return m.SpecSource.Name
+	}
+	return ""
+}
+
+// UpdateSpec migrates every machine that runs a Spec with the given
+// Spec's Name to the given Spec.
+//
+// When dryRun is true, the machines are left alone, and the returned
+// report says which machines could be migrated.
+//
+// Otherwise each machine that can be migrated gets its new State and
+// the given Spec (see Machine.SetSpec), and then its Boot (if any)
+// runs with reason core.BootUpdate.  A machine whose Specter is a
+// *core.UpdatableSpec shares that Specter's update.  A machine that
+// can't be migrated keeps its old Spec (and stops sharing any
+// UpdatableSpec), and the report says why.  The returned error is the
+// first Boot error (if any).
+//
+// Gets a write lock.
+func (c *Crew) UpdateSpec(ctx context.Context, spec *core.Spec, props core.StepProps, dryRun bool) (*MigrationReport, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	ms := make(map[string]*Machine, len(c.Machines))
+	for mid, m := range c.Machines {
+		if m.specName() == spec.Name {
+			ms[mid] = m
+		}
+	}
+
+	r := CheckMigration(ctx, spec, ms, props)
+	if dryRun {
+		return r, nil
+	}
+	r.DryRun = false
+
+	// Pin each machine that can't migrate to its old Spec before
+	// any UpdatableSpec changes.
+	for mid := range r.Failed {
+		m := ms[mid]
+		if u, is := m.Specter.(*core.UpdatableSpec); is {
+			m.Specter = u.Spec()
+		}
+	}
+
+	var (
+		updated = make(map[*core.UpdatableSpec]bool)
+		first   error
+	)
+	r.Walkeds = make(map[string]*core.Walked, len(r.Migrated))
+	for _, mid := range r.Migrated {
+		m := ms[mid]
+		if u, is := m.Specter.(*core.UpdatableSpec); is {
+			if !updated[u] {
+				if err := u.SetSpec(spec); err != nil {
+					return r, err
+				}
+				updated[u] = true
+			}
+		} else {
+			m.Specter = spec
+		}
+		m.State = r.States[mid]
+		m.RecordSpecHash()
+
+		walked, err := m.Boot(ctx, core.BootUpdate, props)
+		if walked != nil {
+			r.Walkeds[mid] = walked
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+		r.States[mid] = m.State
+	}
+
+	return r, first
+}
@@ -0,0 +1,147 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crew
+
+import (
+	"github.com/Comcast/sheens/core"
+)
+
+// Interest determines the messages that could affect a machine with
+// the given Spec and State after the given Walk.  See
+// core.Spec.Interest.
+//
+// If the Walk is nil (say, because the machine was just created,
+// restored, or booted) or if the Walk didn't end with
+// core.Done, then any message could affect the machine.
+func Interest(spec *core.Spec, st *core.State, walked *core.Walked) *core.Interest {
+	if spec == nil || walked == nil || walked.StoppedBecause != core.Done {
+		return core.AnyInterest
+	}
+	return spec.Interest(st)
+}
+
+// Interest determines the messages that could affect the machine
+// after the given Walk (which can be nil).  See Interest.
+func (m *Machine) Interest(walked *core.Walked) *core.Interest {
+	var spec *core.Spec
+	if m.Specter != nil {
+		spec = m.Specter.Spec()
+	}
+	return Interest(spec, m.State, walked)
+}
+
+// Subscriptions indexes machines by the messages that could affect
+// them, so a crew can present a message without a specific recipient
+// to only the machines that could care.
+//
+// A crew should Set a machine's Interest every time the machine's
+// State (or Spec) changes.
+//
+// Not thread-safe.
+type Subscriptions struct {
+	interests map[string]*core.Interest
+
+	// any has the machines that could be affected by any
+	// message.
+	any map[string]bool
+
+	// keyed maps a property and a value to the machines that
+	// are interested in messages with that value for that
+	// property.
+	keyed map[string]map[interface{}]map[string]bool
+}
+
+// NewSubscriptions makes an empty Subscriptions.
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{
+		interests: make(map[string]*core.Interest),
+		any:       make(map[string]bool),
+		keyed:     make(map[string]map[interface{}]map[string]bool),
+	}
+}
+
+// Set records the machine's current Interest.
+func (s *Subscriptions) Set(mid string, i *core.Interest) {
+	s.Remove(mid)
+	s.interests[mid] = i
+	switch {
+	case i.Any:
+		s.any[mid] = true
+	case i.Key != "":
+		vs, have := s.keyed[i.Key]
+		if !have {
+			vs = make(map[interface{}]map[string]bool)
+			s.keyed[i.Key] = vs
+		}
+		for _, v := range i.Values {
+			mids, have := vs[v]
+			if !have {
+				mids = make(map[string]bool)
+				vs[v] = mids
+			}
+			mids[mid] = true
+		}
+	}
+}
+
+// Remove forgets the machine.
+func (s *Subscriptions) Remove(mid string) {
+	i, have := s.interests[mid]
+	if !have {
+		return
+	}
+	delete(s.interests, mid)
+	delete(s.any, mid)
+	if i.Key == "" {
+		return
+	}
+	vs := s.keyed[i.Key]
+	for _, v := range i.Values {
+		delete(vs[v], mid)
+		if len(vs[v]) == 0 {
+			delete(vs, v)
+		}
+	}
+	if len(vs) == 0 {
+		delete(s.keyed, i.Key)
+	}
+}
+
+// Get returns the machine's Interest (if any).
+func (s *Subscriptions) Get(mid string) (*core.Interest, bool) {
+	i, have := s.interests[mid]
+	return i, have
+}
+
+// Subscribers returns the ids of the machines that could be affected
+// by the given message.
+func (s *Subscriptions) Subscribers(msg interface{}) []string {
+	acc := make([]string, 0, len(s.any))
+	for mid := range s.any {
+		acc = append(acc, mid)
+	}
+	m, is := msg.(map[string]interface{})
+	if !is {
+		return acc
+	}
+	for k, vs := range s.keyed {
+		v, is := core.InterestValue(m[k])
+		if !is {
+			continue
+		}
+		for mid := range vs[v] {
+			acc = append(acc, mid)
+		}
+	}
+	return acc
+}
@@ -35,8 +35,9 @@ var (
 	InterruptedMessage = "RuntimeError: timeout"
 
 	// Interrupted is returned by Exec if the execution is
-	// interrupted.
-	Interrupted = errors.New(InterruptedMessage)
+	// interrupted.  Its code (see core.ErrorCode) indicates an
+	// interruption.
+	Interrupted error = &core.InterruptedError{Msg: InterruptedMessage}
 
 	// IgnoreExit will prevent the Goja function "exit" from
 	// terminating the process. Being able to halt the process
@@ -61,6 +62,10 @@ type Interpreter struct {
 
 	// Extended adds some additional properties.
 	Extended bool
+
+	// Matcher (if not nil) is used by the extended property
+	// "match".  Otherwise match.DefaultMatcher is used.
+	Matcher *match.Matcher
 }
 
 // NewInterpreter makes a new Interpreter.
@@ -128,6 +133,9 @@ func deepCopy(x interface{}) (interface{}, error) {
 //      next time for the given crontab expression.
 //    esc(s): URL query-escape the given string.
 //    match(pat, obj): Execute the pattern matcher.
+//    get(obj, path): Return the value at the JSON Pointer in obj.
+//    set(obj, path, val): Return a copy of obj with val at the JSON
+//      Pointer.
 //
 // Testing properties (enabled by the interpreter's Test property):
 //
@@ -259,7 +267,12 @@ func (i *Interpreter) Exec(ctx context.Context, bs match.Bindings, props core.St
 				panic(err)
 			}
 
-			bss, err := match.Match(p, m, bindings)
+			matcher := i.Matcher
+			if matcher == nil {
+				matcher = match.DefaultMatcher
+			}
+
+			bss, err := matcher.Match(p, m, bindings)
 			if err != nil {
 				panic(err)
 			}
@@ -271,6 +284,45 @@ func (i *Interpreter) Exec(ctx context.Context, bs match.Bindings, props core.St
 
 			return x
 		}
+
+		// object canonicalizes the given value, which should
+		// be an object.
+		object := func(x goja.Value) match.Bindings {
+			y, err := canonicalize(x.Export())
+			if err != nil {
+				panic(err)
+			}
+			m, is := y.(map[string]interface{})
+			if !is {
+				protest(o, "not an object")
+			}
+			return match.Bindings(m)
+		}
+
+		// get returns the value at the given JSON Pointer in
+		// the given object.  See match.Bindings.Get.
+		env["get"] = func(x, path goja.Value) interface{} {
+			v, have := object(x).Get(path.String())
+			if !have {
+				return goja.Undefined()
+			}
+			return v
+		}
+
+		// set returns a copy of the given object with the
+		// given value at the given JSON Pointer.  See
+		// match.Bindings.Set.
+		env["set"] = func(x, path, v goja.Value) interface{} {
+			y, err := canonicalize(v.Export())
+			if err != nil {
+				panic(err)
+			}
+			bs := object(x)
+			if err := bs.Set(path.String(), y); err != nil {
+				protest(o, err.Error())
+			}
+			return map[string]interface{}(bs)
+		}
 	}
 
 	if i.Test {
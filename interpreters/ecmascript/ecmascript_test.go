@@ -110,6 +110,66 @@ func TestActionsTimeout(t *testing.T) {
 	}
 }
 
+func TestActionsMachineTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	as := core.ActionSource{
+		Interpreter: "ecmascript",
+		Source:      `for (;;) { } null;`,
+	}
+
+	action, err := as.Compile(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &core.Spec{
+		Name:                "test",
+		ActionErrorBranches: true,
+		Nodes: map[string]*core.Node{
+			"start": {
+				Action: action,
+				Branches: &core.Branches{
+					Branches: []*core.Branch{
+						{
+							Pattern: Dwimjs(`{"errorDetails":{"code":"action.deadlineExceeded"}}`),
+							Target:  "late",
+						},
+						{
+							Target: "sad",
+						},
+					},
+				},
+			},
+			"late": {},
+			"sad":  {},
+		},
+	}
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &core.State{
+		NodeName: "start",
+		Bs:       make(match.Bindings),
+	}
+	ctl := &core.Control{
+		Limit:         10,
+		ActionTimeout: 20 * time.Millisecond,
+	}
+
+	walked, err := spec.Walk(ctx, st, nil, ctl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if walked.To().NodeName != "late" {
+		t.Fatal(walked.To().NodeName)
+	}
+}
+
 func TestActionsError(t *testing.T) {
 	code := `likes + tacos; null;`
 
@@ -165,6 +225,69 @@ func TestActionsCronNextBad(t *testing.T) {
 	}
 }
 
+func TestActionsMatchRegexp(t *testing.T) {
+	code := `
+var bss = _.match({entity:"?~id"}, {entity:"light.kitchen"}, {"?~id":"light\\.(.*)"});
+return {room: bss[0]["?id.1"]};`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	m := *match.DefaultMatcher
+	m.StringPatterns = true
+
+	i := NewInterpreter()
+	i.Extended = true
+	i.Matcher = &m
+	compiled, err := i.Compile(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exe, err := i.Exec(ctx, nil, nil, code, compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room := exe.Bs["room"]; room != "kitchen" {
+		t.Fatalf("room %#v", room)
+	}
+}
+
+func TestActionsGetSet(t *testing.T) {
+	code := `
+var battery = _.get(_.bindings, "/?msg/payload/battery");
+var bs = _.set(_.bindings, "/status/battery", battery < 20 ? "low" : "ok");
+bs.missing = _.get(bs, "/?msg/payload/temp") === undefined;
+return bs;`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	i := NewInterpreter()
+	i.Extended = true
+	compiled, err := i.Compile(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs := match.NewBindings()
+	bs["?msg"] = map[string]interface{}{
+		"payload": map[string]interface{}{
+			"battery": 12,
+		},
+	}
+	exe, err := i.Exec(ctx, bs, nil, code, compiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, _ := exe.Bs.Get("/status/battery"); x != "low" {
+		t.Fatalf("status %#v", exe.Bs)
+	}
+	if x, _ := exe.Bs.Get("/missing"); x != true {
+		t.Fatalf("missing %#v", exe.Bs)
+	}
+}
+
 func TestActionsMachinePrimitive(t *testing.T) {
 	as := core.ActionSource{
 		Interpreter: "ecmascript",
@@ -0,0 +1,201 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"errors"
+	"sort"
+)
+
+// OpArray introduces an array pattern with a mode.  See
+// Matcher.ArrayModes.
+const OpArray = "$array"
+
+const (
+	// ArraySet is the usual array mode, which treats an array as
+	// a set.  See Matcher.Match.
+	ArraySet = "set"
+
+	// ArrayExact requires the fact's elements to match the
+	// pattern's elements in order, with no other elements.
+	ArrayExact = "exact"
+
+	// ArrayPrefix requires the fact's first elements to match
+	// the pattern's elements in order.  Any remaining elements
+	// can be bound to a variable.
+	ArrayPrefix = "prefix"
+)
+
+// IsArrayMode reports whether the given pattern is an array pattern
+// with a mode (see Matcher.ArrayModes) like
+// {"$array":{"mode":"exact","elems":[1,2]}}.
+//
+// The options aren't checked here.  Compile reports bad options.
+func IsArrayMode(pattern interface{}) bool {
+	m, is := pattern.(map[string]interface{})
+	if !is || len(m) != 1 {
+		return false
+	}
+	_, have := m[OpArray]
+	return have
+}
+
+// arrayModeNode implements Matcher.ArrayModes.
+type arrayModeNode struct {
+	mode string
+
+	// elems are the element patterns for ArrayExact and
+	// ArrayPrefix, and set is the array pattern for ArraySet.
+	elems []node
+	set   node
+
+	// rest (if any) is bound to the elements after the prefix.
+	rest node
+
+	// min and max are the allowed lengths.  A negative max means
+	// no maximum.
+	min, max int
+
+	err error
+}
+
+// arrayLength parses a "min" or "max" option.
+func arrayLength(x interface{}) (int, bool) {
+	f, is := fudge(x).(float64)
+	if !is || f < 0 || f != float64(int(f)) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+func (m *Matcher) compileArrayMode(operand interface{}, problem func(error) error, err *error) node {
+	bad := func(msg string) node {
+		return &arrayModeNode{err: problem(errors.New(`bad "` + OpArray + `": ` + msg))}
+	}
+
+	opts, is := operand.(map[string]interface{})
+	if !is {
+		return bad("options must be a map")
+	}
+
+	n := &arrayModeNode{
+		mode: ArraySet,
+		max:  -1,
+	}
+
+	ks := make([]string, 0, len(opts))
+	for k := range opts {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	var elems []interface{}
+	for _, k := range ks {
+		v := opts[k]
+		switch k {
+		case "mode":
+			s, is := v.(string)
+			if !is || (s != ArraySet && s != ArrayExact && s != ArrayPrefix) {
+				return bad(`unknown mode`)
+			}
+			n.mode = s
+		case "elems":
+			if elems, is = v.([]interface{}); !is {
+				return bad(`"elems" must be an array`)
+			}
+		case "rest":
+			s, is := v.(string)
+			if !is || !m.IsVariable(s) {
+				return bad(`"rest" must be a variable`)
+			}
+			n.rest = m.compileVariable(s, problem)
+		case "min":
+			if n.min, is = arrayLength(v); !is {
+				return bad(`"min" must be a non-negative integer`)
+			}
+		case "max":
+			if n.max, is = arrayLength(v); !is {
+				return bad(`"max" must be a non-negative integer`)
+			}
+		default:
+			return bad(`unknown option "` + k + `"`)
+		}
+	}
+
+	if 0 <= n.max && n.max < n.min {
+		return bad(`"min" is greater than "max"`)
+	}
+	if n.rest != nil && n.mode != ArrayPrefix {
+		return bad(`"rest" requires mode "` + ArrayPrefix + `"`)
+	}
+
+	if n.mode == ArraySet {
+		if elems == nil {
+			elems = []interface{}{}
+		}
+		n.set = m.compile(elems, err)
+		return n
+	}
+
+	n.elems = make([]node, 0, len(elems))
+	for _, x := range elems {
+		n.elems = append(n.elems, m.compile(x, err))
+	}
+	return n
+}
+
+func (n *arrayModeNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	fa, is := fact.([]interface{})
+	if !is {
+		return nil, nil
+	}
+	if len(fa) < n.min || (0 <= n.max && n.max < len(fa)) {
+		return nil, nil
+	}
+
+	switch n.mode {
+	case ArraySet:
+		return n.set.match(m, fa, bs)
+	case ArrayExact:
+		if len(fa) != len(n.elems) {
+			return nil, nil
+		}
+	case ArrayPrefix:
+		if len(fa) < len(n.elems) {
+			return nil, nil
+		}
+	}
+
+	bss := []Bindings{bs}
+	for i, p := range n.elems {
+		acc, err := matchWithBindingss(m, bss, p, fa[i])
+		if err != nil {
+			return nil, err
+		}
+		if 0 == len(acc) {
+			return nil, nil
+		}
+		bss = acc
+	}
+
+	if n.rest == nil {
+		return bss, nil
+	}
+
+	rest := make([]interface{}, len(fa)-len(n.elems))
+	copy(rest, fa[len(n.elems):])
+	return matchWithBindingss(m, bss, n.rest, rest)
+}
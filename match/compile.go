@@ -0,0 +1,593 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Pattern is a compiled pattern that can be matched repeatedly
+// without re-examining the pattern's variables.  See
+// Matcher.Compile.
+//
+// A Pattern is immutable, so it can be shared.
+type Pattern struct {
+	m      *Matcher
+	source interface{}
+	root   node
+}
+
+// Compile resolves the given pattern's variables (including optional,
+// anonymous, inequality, typed, and property variables) once and returns a
+// Pattern that uses this Matcher's switches.
+//
+// Compile reports problems that would always cause an error when
+// matching reaches them: unknown pattern types, unknown variable
+// types, multiple variables in an array, bad property variables, and
+// bad path keys.  (Matcher.Match only reports
+// such a problem if matching reaches it.)
+//
+// The given pattern should not be modified after compilation.
+func (m *Matcher) Compile(pattern interface{}) (*Pattern, error) {
+	var err error
+	root := m.compile(pattern, &err)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{
+		m:      m,
+		source: pattern,
+		root:   root,
+	}, nil
+}
+
+// Compile calls DefaultMatcher.Compile.
+func Compile(pattern interface{}) (*Pattern, error) {
+	return DefaultMatcher.Compile(pattern)
+}
+
+// Source returns the pattern that was compiled.
+func (p *Pattern) Source() interface{} {
+	return p.source
+}
+
+// MarshalJSON serializes the Pattern's Source.
+func (p *Pattern) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.source)
+}
+
+// Match is a version of Matches that takes initial bindings.
+//
+// Those initial bindings are not modified.
+func (p *Pattern) Match(fact interface{}, bindings Bindings) ([]Bindings, error) {
+	return p.root.match(p.m, fact, bindings.Copy())
+}
+
+// Matches attempts to match the given fact with this Pattern.  See
+// Matcher.Matches.
+func (p *Pattern) Matches(fact interface{}) ([]Bindings, error) {
+	return p.Match(fact, make(Bindings))
+}
+
+// node is a compiled (part of a) pattern.
+//
+// The match method can modify the given Bindings, which cannot be
+// nil.  When a match fails, those (possibly modified) Bindings should
+// be discarded.
+type node interface {
+	match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error)
+}
+
+// compile compiles a pattern.
+//
+// A problem with the pattern results in a node that reports the
+// problem when matching reaches it.  The first such problem is also
+// stored in err.
+func (m *Matcher) compile(pattern interface{}, err *error) node {
+	problem := func(e error) error {
+		if *err == nil {
+			*err = e
+		}
+		return e
+	}
+
+	switch vv := fudge(pattern).(type) {
+	case nil:
+		return nilNode{}
+	case bool:
+		return boolNode(vv)
+	case float64:
+		return numNode(vv)
+	case string:
+		if m.IsConstant(vv) {
+			return strNode(vv)
+		}
+		return m.compileVariable(vv, problem)
+	case map[string]interface{}:
+		return m.compileMap(vv, problem, err)
+	case []interface{}:
+		return m.compileArray(vv, problem, err)
+	default:
+		return &badNode{problem(&UnknownPatternType{pattern})}
+	}
+}
+
+type nilNode struct{}
+
+func (n nilNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	if fact == nil {
+		return []Bindings{bs}, nil
+	}
+	return nil, nil
+}
+
+type boolNode bool
+
+func (n boolNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	if b, is := fact.(bool); is && b == bool(n) {
+		return []Bindings{bs}, nil
+	}
+	return nil, nil
+}
+
+type numNode float64
+
+func (n numNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	if x, is := fudge(fact).(float64); is && x == float64(n) {
+		return []Bindings{bs}, nil
+	}
+	return nil, nil
+}
+
+type strNode string
+
+func (n strNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	if s, is := fact.(string); is && s == string(n) {
+		return []Bindings{bs}, nil
+	}
+	return nil, nil
+}
+
+// badNode reports a problem with the pattern.
+type badNode struct {
+	err error
+}
+
+func (n *badNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	return nil, n.err
+}
+
+// anyNode is an anonymous variable, which matches anything without
+// binding.
+type anyNode struct{}
+
+func (n anyNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	return []Bindings{bs}, nil
+}
+
+// varNode is a pattern variable.
+type varNode struct {
+	name string
+
+	// optional is true for an optional variable like "??x".
+	optional bool
+
+	// op is the inequality (see Matcher.Inequalities) or the
+	// string pattern operator (see Matcher.StringPatterns) that
+	// the variable's name specifies (if any), and target is the
+	// variable that a satisfying value will be bound to.
+	op, target string
+}
+
+func (m *Matcher) compileVariable(v string, problem func(error) error) node {
+	if m.TypedVariables {
+		if name, typ := SplitTypedVariable(v); typ != "" {
+			if !VariableTypes[typ] {
+				return &badNode{problem(&UnknownVariableType{Var: v, Type: typ})}
+			}
+			return &typedNode{
+				typ: typ,
+				v:   m.compileVariable(name, problem),
+			}
+		}
+	}
+	if m.IsAnonymousVariable(v) {
+		return anyNode{}
+	}
+	n := &varNode{
+		name:     v,
+		optional: m.IsOptionalVariable(v),
+	}
+	if 2 < len(v) {
+		opv := v[1:]
+		for _, op := range []string{"<=", ">=", "!=", ">", "<", RegexpOp, GlobOp} {
+			if strings.HasPrefix(opv, op) {
+				n.op = op
+				n.target = "?" + opv[len(op):]
+				break
+			}
+		}
+	}
+	return n
+}
+
+func (n *varNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	fact = fudge(fact)
+	switch n.op {
+	case "":
+	case RegexpOp, GlobOp:
+		if m.StringPatterns {
+			using, bss, err := n.stringMatch(fact, bs)
+			if err != nil {
+				return nil, err
+			}
+			if using {
+				return bss, nil
+			}
+		}
+	default:
+		if m.Inequalities {
+			using, bss, err := n.inequal(fact, bs)
+			if err != nil {
+				return nil, err
+			}
+			if using {
+				return bss, nil
+			}
+		}
+	}
+	if binding, found := bs[n.name]; found {
+		// The existing binding is itself a pattern.
+		var err error
+		return m.compile(binding, &err).match(m, fact, bs)
+	}
+	bs[n.name] = fact
+	return []Bindings{bs}, nil
+}
+
+// mapNode is a map pattern.
+type mapNode struct {
+	// props are the properties with constant keys.
+	props []*mapProp
+
+	// pvar is the (sole) property with a variable key.
+	pvar *mapProp
+
+	// err (if any) is reported before matching any property, and
+	// lateErr (if any) is reported after matching props.
+	err, lateErr error
+}
+
+type mapProp struct {
+	key string
+
+	// path is the parsed key when the key is a JSON Pointer (see
+	// Matcher.PathKeys).
+	path []string
+
+	// keyNode matches a property variable.
+	keyNode node
+
+	val node
+
+	// optional is true when the value is an optional variable,
+	// which allows the fact to lack the property.
+	optional bool
+}
+
+func (m *Matcher) compileMap(pattern map[string]interface{}, problem func(error) error, err *error) node {
+	if m.Operators && IsOperator(pattern) {
+		for op, operand := range pattern {
+			return m.compileOperator(op, operand, problem, err)
+		}
+	}
+
+	if m.ArrayModes && IsArrayMode(pattern) {
+		return m.compileArrayMode(pattern[OpArray], problem, err)
+	}
+
+	n := &mapNode{
+		props: make([]*mapProp, 0, len(pattern)),
+	}
+
+	ks := make([]string, 0, len(pattern))
+	for k := range pattern {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	if 1 < len(pattern) && m.CheckForBadPropertyVariables {
+		for _, k := range ks {
+			if m.IsVariable(k) {
+				n.err = problem(errors.New(`can't have a variable as a key ("` + k + `") with other keys`))
+				return n
+			}
+		}
+	}
+
+	for _, k := range ks {
+		v := pattern[k]
+		if !m.IsVariable(k) {
+			p := &mapProp{
+				key:      k,
+				val:      m.compile(v, err),
+				optional: m.IsOptionalVariable(v),
+			}
+			if m.PathKeys && IsPathKey(k) {
+				path, e := ParsePointer(k)
+				if e != nil {
+					n.err = problem(e)
+					return n
+				}
+				p.path = path
+			}
+			n.props = append(n.props, p)
+			continue
+		}
+		switch {
+		case n.lateErr != nil:
+		case !m.AllowPropertyVariables:
+			n.lateErr = problem(errors.New(`can't have a variable as a key ("` + k + `")`))
+		case 1 < len(pattern):
+			n.lateErr = problem(errors.New(`can't have a variable as a key ("` + k + `") with other keys`))
+		default:
+			n.pvar = &mapProp{
+				key:     k,
+				keyNode: m.compileVariable(k, problem),
+				val:     m.compile(v, err),
+			}
+		}
+	}
+
+	return n
+}
+
+// get returns the fact's value for the property.
+func (p *mapProp) get(fact map[string]interface{}) (interface{}, bool) {
+	if p.path != nil {
+		return getPath(fact, p.path)
+	}
+	x, have := fact[p.key]
+	return x, have
+}
+
+func (n *mapNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	fm, is := fact.(map[string]interface{})
+	if !is {
+		return nil, nil
+	}
+	if n.err != nil {
+		return nil, n.err
+	}
+
+	bss := []Bindings{bs}
+	for _, p := range n.props {
+		fv, found := p.get(fm)
+		if !found {
+			if p.optional {
+				continue
+			}
+			return nil, nil
+		}
+		acc, err := matchWithBindingss(m, bss, p.val, fv)
+		if err != nil {
+			return nil, err
+		}
+		if 0 == len(acc) {
+			return nil, nil
+		}
+		bss = acc
+	}
+
+	if n.lateErr != nil {
+		return nil, n.lateErr
+	}
+
+	if n.pvar == nil {
+		return bss, nil
+	}
+
+	// Iterate over the fact keys and collect match results.
+	gather := make([]Bindings, 0, 0)
+	for fk, fv := range fm {
+		// Try to match keys.
+		ext, err := matchWithBindingss(m, copyBindingss(bss), n.pvar.keyNode, fk)
+		if err != nil {
+			return nil, err
+		}
+		if 0 == len(ext) {
+			continue
+		}
+		// Matched keys.  Now check values.
+		if ext, err = matchWithBindingss(m, ext, n.pvar.val, fv); err != nil {
+			return nil, err
+		}
+		gather = append(gather, ext...)
+	}
+	return gather, nil
+}
+
+// arrayNode is an array pattern, which represents a set.
+type arrayNode struct {
+	// elems are the array's elements other than the variable (in
+	// order).
+	elems []*arrayElem
+
+	// v is the array's variable (if any).
+	v node
+
+	// optional is true if v is an optional variable.
+	optional bool
+
+	err error
+}
+
+type arrayElem struct {
+	// atom is a string, float64, bool, or nil that the fact array
+	// must contain.
+	atom interface{}
+
+	// n is non-nil for any other element.
+	n node
+}
+
+func (m *Matcher) compileArray(pattern []interface{}, problem func(error) error, err *error) node {
+	n := &arrayNode{
+		elems: make([]*arrayElem, 0, len(pattern)),
+	}
+	var v string
+	for _, x := range pattern {
+		switch vv := x.(type) {
+		case float64, bool, nil:
+			n.elems = append(n.elems, &arrayElem{atom: vv})
+		case string:
+			if !m.IsVariable(vv) {
+				n.elems = append(n.elems, &arrayElem{atom: vv})
+				continue
+			}
+			switch v {
+			case "":
+				v = vv
+			case vv:
+				n.err = problem(errors.New("repeated variables not supported"))
+				return n
+			default:
+				n.err = problem(errors.New("multiple variables not supported here"))
+				return n
+			}
+		default:
+			n.elems = append(n.elems, &arrayElem{n: m.compile(vv, err)})
+		}
+	}
+	if v != "" {
+		n.v = m.compileVariable(v, problem)
+		n.optional = m.IsOptionalVariable(v)
+	}
+	return n
+}
+
+func (n *arrayNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	fa, is := fact.([]interface{})
+	if !is {
+		return nil, nil
+	}
+
+	// Index the fact array, separating arrays and maps from
+	// the atoms.
+	fxs := make(map[interface{}]bool)
+	fxa := make(map[int]interface{})
+	for i, y := range fa {
+		switch y.(type) {
+		case float64, string, bool, nil:
+			fxs[y] = true
+		default:
+			fxa[i] = y
+		}
+	}
+
+	var (
+		bsss = [][]Bindings{{bs}}
+		fxas = []map[int]interface{}{fxa}
+		err  error
+	)
+
+	for _, x := range n.elems {
+		if x.n == nil {
+			if !fxs[x.atom] {
+				return nil, nil
+			}
+			delete(fxs, x.atom)
+			continue
+		}
+		if 0 == len(fxa) {
+			return nil, nil
+		}
+		if bsss, fxas, err = arraycatMatch(m, bsss, x.n, fxas); err != nil {
+			return nil, err
+		}
+		if nil == bsss {
+			return nil, nil
+		}
+	}
+
+	if n.v == nil {
+		return combine(bsss), nil
+	}
+
+	// Merge left-over facts.
+	for _, fxa := range fxas {
+		i := len(fa)
+		for fact := range fxs {
+			fxa[i] = fact
+			i++
+		}
+	}
+
+	// Bind the pattern variable.
+	previous := bsss
+	if bsss, _, err = arraycatMatch(m, bsss, n.v, fxas); err != nil {
+		return nil, err
+	}
+	if len(bsss) == 0 && n.optional {
+		bsss = previous
+	}
+	return combine(bsss), nil
+}
+
+// arraycatMatch attempts to extend the given list of bindingss 'bsss'
+// based on element-wise matching.
+//
+// An array represents a set; therefore, this function can backtrack,
+// which can be scary.
+func arraycatMatch(m *Matcher, bsss [][]Bindings, pattern node, fxas []map[int]interface{}) ([][]Bindings, []map[int]interface{}, error) {
+	var nbsss [][]Bindings
+	var nfxas []map[int]interface{}
+	for i, bss := range bsss {
+		mm := fxas[i]
+		for j, fact := range mm {
+			acc, err := matchWithBindingss(m, copyBindingss(bss), pattern, fact)
+			if nil != err {
+				return nil, nil, err
+			}
+			if 0 != len(acc) {
+				nbsss = append(nbsss, acc)
+				copy := copyMap(mm)
+				delete(copy, j)
+				nfxas = append(nfxas, copy)
+			}
+		}
+	}
+	return nbsss, nfxas, nil
+}
+
+// matchWithBindingss attempts to extend the given bindingss 'bss' from
+// matches of the fact against the pattern.
+//
+// The given Bindings are modified.
+func matchWithBindingss(m *Matcher, bss []Bindings, pattern node, fact interface{}) ([]Bindings, error) {
+	acc := make([]Bindings, 0, len(bss))
+	for _, bs := range bss {
+		matches, err := pattern.match(m, fact, bs)
+		if nil != err {
+			return nil, err
+		}
+		acc = append(acc, matches...)
+	}
+	return acc, nil
+}
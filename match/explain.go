@@ -0,0 +1,469 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MismatchReason says why a pattern didn't match.  See Explanation.
+type MismatchReason string
+
+const (
+	// MismatchType means the fact's value has the wrong JSON
+	// type.  Explanation.Expected is the type (see TypeOf).
+	MismatchType MismatchReason = "type"
+
+	// MismatchValue means the fact's value isn't the pattern's
+	// constant.
+	MismatchValue MismatchReason = "value"
+
+	// MismatchMissing means the fact lacks a property that the
+	// pattern requires.
+	MismatchMissing MismatchReason = "missing"
+
+	// MismatchBinding means the fact's value conflicts with an
+	// existing binding for Explanation.Var, which is
+	// Explanation.Bound.
+	MismatchBinding MismatchReason = "binding"
+
+	// MismatchInequality means the fact's value doesn't satisfy
+	// the inequality (see Matcher.Inequalities) for
+	// Explanation.Var, whose binding is Explanation.Bound.
+	MismatchInequality MismatchReason = "inequality"
+
+	// MismatchStringPattern means the fact's value doesn't match
+	// the regular expression or glob (see
+	// Matcher.StringPatterns) for Explanation.Var, whose binding
+	// is Explanation.Bound.
+	MismatchStringPattern MismatchReason = "stringPattern"
+
+	// MismatchElement means no element of the fact's array
+	// matches an element of the pattern's array.
+	MismatchElement MismatchReason = "element"
+
+	// MismatchProperty means no property of the fact matches a
+	// property variable (see Matcher.AllowPropertyVariables).
+	MismatchProperty MismatchReason = "property"
+
+	// MismatchOperator means an operator (see Matcher.Operators)
+	// wasn't satisfied.
+	MismatchOperator MismatchReason = "operator"
+
+	// MismatchLength means the fact's array doesn't have a
+	// length that an array mode (see Matcher.ArrayModes)
+	// allows.
+	MismatchLength MismatchReason = "length"
+
+	// MismatchError means matching returned an error, which is
+	// Explanation.Error.
+	MismatchError MismatchReason = "error"
+
+	// MismatchOther means that each part of the pattern matched
+	// on its own but the parts' bindings couldn't be combined.
+	MismatchOther MismatchReason = "other"
+)
+
+// Explanation reports why a pattern did (or didn't) match a fact.
+// See Matcher.Explain.
+type Explanation struct {
+	// Matched is true if the pattern matched the fact, in which
+	// case the other fields are empty.
+	Matched bool `json:"matched"`
+
+	// Path is the JSON Pointer (RFC 6901) for the place in the
+	// fact where the first mismatch occurred.  The empty string
+	// is the entire fact.
+	Path string `json:"path"`
+
+	Reason MismatchReason `json:"reason,omitempty"`
+
+	// Expected is the part of the pattern (or, for MismatchType,
+	// the JSON type) that the fact's value at Path didn't
+	// match, which is Actual.
+	Expected interface{} `json:"expected,omitempty"`
+	Actual   interface{} `json:"actual,omitempty"`
+
+	// Var (if any) is the variable involved, and Bound is its
+	// binding.
+	Var   string      `json:"var,omitempty"`
+	Bound interface{} `json:"bound,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// String renders the Explanation in a single line.
+func (e *Explanation) String() string {
+	if e.Matched {
+		return "matched"
+	}
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	var acc string
+	switch e.Reason {
+	case MismatchMissing:
+		acc = fmt.Sprintf("missing property for %s", js(e.Expected))
+	case MismatchType:
+		acc = fmt.Sprintf("expected a value of type %v but got %s", e.Expected, js(e.Actual))
+	case MismatchBinding:
+		acc = fmt.Sprintf("%s is bound to %s but got %s", e.Var, js(e.Bound), js(e.Actual))
+	case MismatchInequality, MismatchStringPattern:
+		acc = fmt.Sprintf("%s with binding %s doesn't accept %s", e.Var, js(e.Bound), js(e.Actual))
+	case MismatchError:
+		acc = "error: " + e.Error
+	default:
+		acc = fmt.Sprintf("expected %s but got %s", js(e.Expected), js(e.Actual))
+	}
+	return fmt.Sprintf("at %s: %s (%s)", path, acc, e.Reason)
+}
+
+func js(x interface{}) string {
+	bs, err := json.Marshal(x)
+	if err != nil {
+		return fmt.Sprintf("%#v", x)
+	}
+	return string(bs)
+}
+
+// pointerEscape escapes a JSON Pointer reference token.
+func pointerEscape(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// Explain reports why the given pattern didn't match the given fact
+// with the given bindings (which are not modified).
+//
+// When the pattern doesn't match, the Explanation describes the
+// first mismatch found by considering the pattern's properties in
+// order (sorted by name).  When a part of the pattern could match in
+// more than one way, only the first set of bindings is considered
+// for the rest of the pattern, so the Explanation is a guide rather
+// than a proof.
+//
+// Explain repeatedly matches parts of the pattern, so it's much
+// slower than Match.  Use it for debugging.
+func (m *Matcher) Explain(pattern interface{}, fact interface{}, bindings Bindings) *Explanation {
+	return m.explain(pattern, fact, bindings.Copy(), "")
+}
+
+// Explain calls DefaultMatcher.Explain.
+func Explain(pattern interface{}, fact interface{}, bindings Bindings) *Explanation {
+	return DefaultMatcher.Explain(pattern, fact, bindings)
+}
+
+// Explain calls Matcher.Explain with the compiled Pattern's Matcher
+// and Source.
+func (p *Pattern) Explain(fact interface{}, bindings Bindings) *Explanation {
+	return p.m.Explain(p.source, fact, bindings)
+}
+
+func (m *Matcher) explain(pattern interface{}, fact interface{}, bs Bindings, path string) *Explanation {
+	bss, err := m.Match(pattern, fact, bs)
+	if err != nil {
+		return &Explanation{
+			Path:     path,
+			Reason:   MismatchError,
+			Expected: pattern,
+			Actual:   fact,
+			Error:    err.Error(),
+		}
+	}
+	if 0 < len(bss) {
+		return &Explanation{
+			Matched: true,
+		}
+	}
+
+	mismatch := func(reason MismatchReason) *Explanation {
+		return &Explanation{
+			Path:     path,
+			Reason:   reason,
+			Expected: pattern,
+			Actual:   fact,
+		}
+	}
+
+	typeMismatch := func(typ string) *Explanation {
+		if TypeOf(fact) == typ {
+			return nil
+		}
+		e := mismatch(MismatchType)
+		e.Expected = typ
+		return e
+	}
+
+	switch vv := fudge(pattern).(type) {
+	case nil:
+		if e := typeMismatch("null"); e != nil {
+			return e
+		}
+	case bool:
+		if e := typeMismatch("boolean"); e != nil {
+			return e
+		}
+		return mismatch(MismatchValue)
+	case float64:
+		if e := typeMismatch("number"); e != nil {
+			return e
+		}
+		return mismatch(MismatchValue)
+	case string:
+		if m.IsConstant(vv) {
+			if e := typeMismatch("string"); e != nil {
+				return e
+			}
+			return mismatch(MismatchValue)
+		}
+		return m.explainVariable(vv, fact, bs, path)
+	case map[string]interface{}:
+		if m.Operators && IsOperator(vv) {
+			return m.explainOperator(vv, fact, bs, path)
+		}
+		if m.ArrayModes && IsArrayMode(vv) {
+			if e := typeMismatch("array"); e != nil {
+				return e
+			}
+			return m.explainArrayMode(vv, fact.([]interface{}), bs, path)
+		}
+		if e := typeMismatch("object"); e != nil {
+			return e
+		}
+		return m.explainMap(vv, fact.(map[string]interface{}), bs, path)
+	case []interface{}:
+		if e := typeMismatch("array"); e != nil {
+			return e
+		}
+		return m.explainArray(vv, fact.([]interface{}), bs, path)
+	}
+
+	return mismatch(MismatchOther)
+}
+
+func (m *Matcher) explainVariable(v string, fact interface{}, bs Bindings, path string) *Explanation {
+	e := &Explanation{
+		Path:     path,
+		Expected: v,
+		Actual:   fact,
+	}
+	if m.TypedVariables {
+		if name, typ := SplitTypedVariable(v); typ != "" {
+			if TypeOf(fact) != typ {
+				e.Reason, e.Var, e.Expected = MismatchType, v, typ
+				return e
+			}
+			v = name
+		}
+	}
+
+	bound, have := bs[v]
+	if !have {
+		e.Reason = MismatchOther
+		return e
+	}
+	e.Var, e.Bound = v, bound
+
+	var (
+		op     string
+		target string
+	)
+	if n, is := m.compileVariable(v, func(err error) error { return err }).(*varNode); is {
+		op, target = n.op, n.target
+	}
+
+	switch op {
+	case "":
+		e.Reason = MismatchBinding
+		return e
+	case RegexpOp, GlobOp:
+		if !m.StringPatterns {
+			e.Reason = MismatchBinding
+			return e
+		}
+		e.Reason = MismatchStringPattern
+	default:
+		if !m.Inequalities {
+			e.Reason = MismatchBinding
+			return e
+		}
+		e.Reason = MismatchInequality
+	}
+
+	// The operator might have been satisfied, in which case the
+	// problem is an existing binding for the target.
+	if x, given := bs[target]; given {
+		probe := bs.Copy()
+		delete(probe, target)
+		if bss, _ := m.Match(v, fact, probe); 0 < len(bss) {
+			e.Reason, e.Var, e.Bound = MismatchBinding, target, x
+		}
+	}
+
+	return e
+}
+
+func (m *Matcher) explainMap(pattern map[string]interface{}, fact map[string]interface{}, bs Bindings, path string) *Explanation {
+	ks := make([]string, 0, len(pattern))
+	for k := range pattern {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		if m.IsVariable(k) {
+			continue
+		}
+		v := pattern[k]
+		sub := path + "/" + pointerEscape(k)
+		fv, have := fact[k]
+		if m.PathKeys && IsPathKey(k) {
+			// Matching has already reported a bad path.
+			tokens, _ := ParsePointer(k)
+			sub = path + k
+			fv, have = getPath(fact, tokens)
+		}
+		if !have {
+			if m.IsOptionalVariable(v) {
+				continue
+			}
+			return &Explanation{
+				Path:     sub,
+				Reason:   MismatchMissing,
+				Expected: v,
+			}
+		}
+		bss, err := m.Match(v, fv, bs)
+		if err != nil || len(bss) == 0 {
+			return m.explain(v, fv, bs, sub)
+		}
+		bs = bss[0]
+	}
+
+	for _, k := range ks {
+		if m.IsVariable(k) {
+			return &Explanation{
+				Path:     path,
+				Reason:   MismatchProperty,
+				Expected: map[string]interface{}{k: pattern[k]},
+				Actual:   fact,
+			}
+		}
+	}
+
+	return &Explanation{
+		Path:     path,
+		Reason:   MismatchOther,
+		Expected: pattern,
+		Actual:   fact,
+	}
+}
+
+func (m *Matcher) explainArray(pattern []interface{}, fact []interface{}, bs Bindings, path string) *Explanation {
+	for _, x := range pattern {
+		if s, is := x.(string); is && m.IsVariable(s) {
+			continue
+		}
+		found := false
+		for _, y := range fact {
+			if bss, _ := m.Match(x, y, bs); 0 < len(bss) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &Explanation{
+				Path:     path,
+				Reason:   MismatchElement,
+				Expected: x,
+				Actual:   fact,
+			}
+		}
+	}
+
+	return &Explanation{
+		Path:     path,
+		Reason:   MismatchOther,
+		Expected: pattern,
+		Actual:   fact,
+	}
+}
+
+func (m *Matcher) explainOperator(pattern map[string]interface{}, fact interface{}, bs Bindings, path string) *Explanation {
+	if ps, is := pattern[OpAnd].([]interface{}); is {
+		for _, p := range ps {
+			bss, err := m.Match(p, fact, bs)
+			if err != nil || len(bss) == 0 {
+				return m.explain(p, fact, bs, path)
+			}
+			bs = bss[0]
+		}
+	}
+	return &Explanation{
+		Path:     path,
+		Reason:   MismatchOperator,
+		Expected: pattern,
+		Actual:   fact,
+	}
+}
+
+func (m *Matcher) explainArrayMode(pattern map[string]interface{}, fact []interface{}, bs Bindings, path string) *Explanation {
+	// Matching has already reported bad options.
+	var (
+		opts     = pattern[OpArray].(map[string]interface{})
+		mode, _  = opts["mode"].(string)
+		elems, _ = opts["elems"].([]interface{})
+		min, _   = arrayLength(opts["min"])
+		max, _   = arrayLength(opts["max"])
+	)
+	if _, given := opts["max"]; !given {
+		max = len(fact)
+	}
+
+	mismatch := func(reason MismatchReason) *Explanation {
+		return &Explanation{
+			Path:     path,
+			Reason:   reason,
+			Expected: pattern,
+			Actual:   fact,
+		}
+	}
+
+	switch {
+	case len(fact) < min || max < len(fact):
+		return mismatch(MismatchLength)
+	case mode == ArrayExact && len(fact) != len(elems):
+		return mismatch(MismatchLength)
+	case mode == ArrayPrefix && len(fact) < len(elems):
+		return mismatch(MismatchLength)
+	case mode == "" || mode == ArraySet:
+		if elems == nil {
+			elems = []interface{}{}
+		}
+		return m.explain(elems, fact, bs, path)
+	}
+
+	for i, x := range elems {
+		bss, err := m.Match(x, fact[i], bs)
+		if err != nil || len(bss) == 0 {
+			return m.explain(x, fact[i], bs, path+"/"+strconv.Itoa(i))
+		}
+		bs = bss[0]
+	}
+
+	return mismatch(MismatchOther)
+}
@@ -0,0 +1,159 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"fmt"
+	"time"
+)
+
+// InequalityTypeError reports an inequality variable whose binding
+// can't be compared with the value being matched.  See
+// Matcher.Inequalities.
+type InequalityTypeError struct {
+	// Var is the inequality variable (for example "?<n").
+	Var string
+
+	// Bound is the variable's binding, and Value is the value that
+	// was being matched.
+	Bound, Value interface{}
+
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e *InequalityTypeError) Error() string {
+	return fmt.Sprintf("inequality %s with binding %#v and value %#v: %s",
+		e.Var, e.Bound, e.Value, e.Reason)
+}
+
+// parseTimestamp reports whether the given string is an RFC 3339
+// timestamp (with or without fractional seconds).
+func parseTimestamp(s string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// compare returns -1, 0, or 1 depending on whether a is less than,
+// equal to, or greater than b.
+//
+// The first return value is false if a can't be compared with b
+// because they have different JSON types, in which case the
+// inequality isn't satisfied.  An error reports a combination that is
+// always a mistake: a binding that's neither a number nor a string,
+// and a comparison of an RFC 3339 timestamp with a string that isn't
+// one.
+func (n *varNode) compare(a, b interface{}) (bool, int, error) {
+	problem := func(reason string) error {
+		return &InequalityTypeError{
+			Var:    n.name,
+			Bound:  b,
+			Value:  a,
+			Reason: reason,
+		}
+	}
+
+	switch y := b.(type) {
+	case float64:
+		x, is := a.(float64)
+		if !is {
+			return false, 0, nil
+		}
+		switch {
+		case x < y:
+			return true, -1, nil
+		case x > y:
+			return true, 1, nil
+		}
+		return true, 0, nil
+
+	case string:
+		x, is := a.(string)
+		if !is {
+			return false, 0, nil
+		}
+		ty, yt := parseTimestamp(y)
+		tx, xt := parseTimestamp(x)
+		switch {
+		case xt && yt:
+			switch {
+			case tx.Before(ty):
+				return true, -1, nil
+			case tx.After(ty):
+				return true, 1, nil
+			}
+			return true, 0, nil
+		case yt:
+			return false, 0, problem("binding is an RFC 3339 timestamp but value is not")
+		case xt:
+			return false, 0, problem("value is an RFC 3339 timestamp but binding is not")
+		}
+		switch {
+		case x < y:
+			return true, -1, nil
+		case x > y:
+			return true, 1, nil
+		}
+		return true, 0, nil
+
+	default:
+		return false, 0, problem("binding must be a number or a string")
+	}
+}
+
+// inequal implements Matcher.Inequalities.
+//
+// Returns false if the inequality doesn't apply, in which case the
+// variable is just an ordinary variable.
+func (n *varNode) inequal(fact interface{}, bs Bindings) (bool, []Bindings, error) {
+	x, have := bs[n.name]
+	if !have {
+		return false, nil, nil
+	}
+
+	comparable, c, err := n.compare(fact, fudge(x))
+	if err != nil {
+		return true, nil, err
+	}
+	if !comparable {
+		return true, nil, nil
+	}
+
+	var satisfied bool
+	switch n.op {
+	case "<":
+		satisfied = c < 0
+	case "<=":
+		satisfied = c <= 0
+	case ">":
+		satisfied = c > 0
+	case ">=":
+		satisfied = c >= 0
+	case "!=":
+		satisfied = c != 0
+	}
+	if !satisfied {
+		return true, nil, nil
+	}
+
+	if x, given := bs[n.target]; given {
+		if fudge(x) != fact {
+			return true, nil, nil
+		}
+		// Don't need to update the bindings.
+		return true, []Bindings{bs}, nil
+	}
+
+	bs[n.target] = fact
+	return true, []Bindings{bs}, nil
+}
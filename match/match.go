@@ -38,7 +38,7 @@ type Matcher struct {
 	// Inequalities is a switch to turn on experimental binding
 	// inequality support.
 	//
-	// With this feature, pattern matching supports numeric
+	// With this feature, pattern matching supports
 	// inequalities in addition to the standard equality
 	// predicate.  The input bindings should include a binding for
 	// a variable with a name that contains either "<", ">", "<=",
@@ -57,8 +57,23 @@ type Matcher struct {
 	// See match_test.js for several examples. (Search for
 	// "inequality".)
 	//
-	// For now at least, the inequalities only work for numeric
-	// values.  We might support strings later.
+	// The binding determines the kind of comparison:
+	//
+	//   A number is compared numerically with a number.
+	//
+	//   An RFC 3339 timestamp (like the strings that the
+	//   ECMAScript interpreter's cronNext returns) is compared as
+	//   a time with another RFC 3339 timestamp.  Timestamps with
+	//   different time zones compare correctly.
+	//
+	//   Any other string is compared lexically (byte-wise) with
+	//   another string.
+	//
+	// A value with a different JSON type than the binding (for
+	// example, a string when the binding is a number) doesn't
+	// match.  Comparing an RFC 3339 timestamp with a string that
+	// isn't one is an InequalityTypeError, as is a binding that's
+	// neither a number nor a string.
 	//
 	// Yes, such a feature makes us stare down a slippery slope.
 	// However, we are brave, and we do not shy away from even
@@ -72,7 +87,8 @@ type Matcher struct {
 	// The immediate motivation for this feature was to support
 	// timer fallbacks.  For example, using the Goja interpreter,
 	// an action could establish a binding with a value that's a
-	// number representing a future time in UNIX milliseconds.
+	// number representing a future time in UNIX milliseconds (or
+	// an RFC 3339 timestamp from cronNext).
 	// Then a branch pattern can check for a message containing
 	// the current time which is greater than that number.  When a
 	// machine is loaded, a system could send the machine a
@@ -87,6 +103,146 @@ type Matcher struct {
 	// message-oriented timer protocol that's been offered
 	// elsewhere).
 	Inequalities bool
+
+	// StringPatterns is a switch to turn on regular expression
+	// and glob matching for strings.
+	//
+	// This feature follows the approach for Inequalities.  The
+	// input bindings should include a binding for a variable
+	// with a name that has either "~" (for a regular expression)
+	// or "*" (for a glob) immediately after the leading "?".
+	// That binding is the regular expression or glob.  The input
+	// pattern can then use that variable.  When matching, a
+	// string X will match that variable only if the entire X
+	// matches the regular expression or glob.  In that case, the
+	// output bindings will include a new binding for a variable
+	// with the same name but without the "~" or "*".  For a
+	// regular expression, each captured group is bound to a
+	// variable with that name followed by "." and the group's
+	// number (and name, if the group is named).
+	//
+	// For example, given input bindings {"?~id":"light\\.(.*)"},
+	// pattern {"entity":"?~id"}, and message
+	// {"entity":"light.kitchen"}, the match will succeed with
+	// bindings {"?~id":"light\\.(.*)","?id":"light.kitchen","?id.1":"kitchen"}.
+	//
+	// A glob uses the syntax of path.Match, so "*" doesn't match
+	// a "/".  For example, the glob "home/*/motion" matches the
+	// string "home/den/motion".
+	//
+	// A bad regular expression or glob results in an error.
+	//
+	// DefaultMatcher leaves this switch off, since it changes
+	// the meaning of existing variables like "?~x" and "?*x".
+	StringPatterns bool
+
+	// TypedVariables is a switch to turn on variables that
+	// constrain the type of the values they match.
+	//
+	// A typed variable has a name followed by ":" and one of the
+	// VariableTypes: "number", "string", "boolean", "null",
+	// "array", or "object".  For example, "?temp:number" only
+	// matches a number.  The binding uses the name without the
+	// type, so a successful match binds "?temp".
+	//
+	// The type works with the other kinds of variables, so
+	// "??temp:number" is an optional number, "?:array" matches any
+	// array without binding anything, and "?<n:number" is an
+	// inequality that only considers numbers.
+	//
+	// An unknown type results in an UnknownVariableType error.
+	TypedVariables bool
+
+	// Operators is a switch to turn on negation, disjunction,
+	// and conjunction in patterns.
+	//
+	// A map with a single property named "$not", "$or", or
+	// "$and" is an operator expression:
+	//
+	//   {"$not":P} matches a value that P doesn't match.  Any
+	//   variables in P that weren't already bound remain unbound.
+	//
+	//   {"$or":[P1,P2,...]} matches a value that any Pi matches.
+	//   The result is the union of the bindings from each Pi
+	//   (without duplicates).
+	//
+	//   {"$and":[P1,P2,...]} matches a value that every Pi
+	//   matches, with the bindings from each Pi carried into the
+	//   next.
+	//
+	// The operand of "$or" or "$and" must be a non-empty array.
+	//
+	// Since "$or" can return multiple sets of bindings, the usual
+	// caveats about ambiguous matches apply.
+	Operators bool
+
+	// PathKeys is a switch to turn on properties that reach into
+	// nested values.
+	//
+	// A property name that starts with "/" is a JSON Pointer (RFC
+	// 6901) into the fact.  For example, the pattern
+	// {"/payload/attributes/battery":"?b"} is like
+	// {"payload":{"attributes":{"battery":"?b"}}}.  A reference
+	// token that's an array index (like "0") selects that element
+	// of an array, so unlike an array in a pattern, such a path
+	// considers the order of the fact's array.  In a JSON Pointer,
+	// "~1" is "/" and "~0" is "~".
+	//
+	// A path to a value that the fact doesn't have is like a
+	// missing property, so an optional variable allows it.
+	//
+	// A bad JSON Pointer (with a "~" that isn't "~0" or "~1")
+	// results in an error.
+	PathKeys bool
+
+	// ArrayModes is a switch to turn on array patterns that
+	// consider order and length.
+	//
+	// Usually an array in a pattern is a set: each element must
+	// match a different element of the fact's array (in any
+	// order), and the array's variable (if any) matches each of
+	// the remaining elements in turn.  That approach can be
+	// expensive.  Each element that isn't a string, number,
+	// boolean, or null is tried against every such element of
+	// the fact, and each success is another branch of the
+	// search.  With k such elements and n such elements in the
+	// fact, matching can consider n!/(n-k)! assignments, and
+	// each of those can be a set of bindings in the result.  A
+	// variable multiplies the result by the number of remaining
+	// elements.  (See BenchmarkArraySet.)
+	//
+	// With this switch, a map with the single property "$array"
+	// is an array pattern with a mode.  The value of "$array" is
+	// a map of options:
+	//
+	//   "mode": "set" (the default), "exact", or "prefix".
+	//
+	//   "elems": the array of element patterns.
+	//
+	//   "rest": a variable (only for "prefix") that's bound to the
+	//   array of the fact's elements after the prefix.
+	//
+	//   "min" and "max": the allowed lengths of the fact's array.
+	//
+	// With mode "exact", the fact's array must have exactly the
+	// given elements in order, so {"$array":{"mode":"exact",
+	// "elems":["?first",2]}} matches [1,2] but not [2,1] or
+	// [1,2,3].  With mode "prefix", the fact's array must start
+	// with the given elements, so {"$array":{"mode":"prefix",
+	// "elems":["add"],"rest":"?args"}} matches ["add",1,2] with
+	// the binding {"?args":[1,2]}.  With mode "set", the "elems"
+	// are an ordinary array pattern.  In every mode, "min" and
+	// "max" constrain the length, so {"$array":{"min":1}}
+	// matches any non-empty array.
+	//
+	// In modes "exact" and "prefix", an element that's a
+	// variable matches one element, and each element pattern is
+	// matched against just one of the fact's elements, so the
+	// ordered array itself doesn't multiply bindings (though its
+	// elements might).
+	//
+	// Bad options result in an error.
+	ArrayModes bool
 }
 
 var DefaultMatcher = &Matcher{
@@ -95,21 +251,6 @@ var DefaultMatcher = &Matcher{
 	Inequalities:                 true,
 }
 
-func (m *Matcher) checkForBadPropertyVariables(pattern map[string]interface{}) error {
-	if !m.CheckForBadPropertyVariables {
-		return nil
-	}
-	if len(pattern) <= 1 {
-		return nil
-	}
-	for k := range pattern {
-		if m.IsVariable(k) {
-			return errors.New(`can't have a variable as a key ("` + k + `") with other keys`)
-		}
-	}
-	return nil
-}
-
 // Bindings is a map from variables (strings starting with a '?') to
 // their values.
 type Bindings map[string]interface{}
@@ -206,102 +347,6 @@ func (m *Matcher) IsConstant(s string) bool {
 	return !m.IsVariable(s)
 }
 
-// mapcatMatch attempts to extend the given bindingss 'bss' based on
-// pair-wise matching of the pattern to the fact.
-func (m *Matcher) mapcatMatch(bss []Bindings, pattern map[string]interface{}, fact map[string]interface{}) ([]Bindings, error) {
-	if err := m.checkForBadPropertyVariables(pattern); err != nil {
-		return nil, err
-	}
-
-	for k, v := range pattern {
-		if m.IsVariable(k) {
-			if m.AllowPropertyVariables {
-				if len(pattern) == 1 {
-					// Iterate over the fact keys and collect match results.
-					gather := make([]Bindings, 0, 0)
-					for fk, fv := range fact {
-						ext := copyBindingss(bss)
-
-						// Try to match keys.
-						ext, err := m.matchWithBindingss(ext, k, fk)
-						if err != nil {
-							return nil, err
-						}
-						if 0 == len(ext) {
-							// Didn't match keys.
-							continue
-						}
-						// Matched keys.  Now check values.
-						ext, err = m.matchWithBindingss(ext, v, fv)
-						if err != nil {
-							return nil, err
-						}
-						if 0 == len(ext) {
-							// Didn't match values.
-							continue
-						}
-						gather = append(gather, ext...)
-					}
-					bss = gather
-					// Since we know we have only one key, the outer loop will terminate.
-					// Probably should make that termination explicit.
-					return bss, nil
-				} else {
-					return nil, errors.New(`can't have a variable as a key ("` + k + `") with other keys`)
-				}
-			} else {
-				return nil, errors.New(`can't have a variable as a key ("` + k + `")`)
-			}
-		} else {
-			fv, found := fact[k]
-			if !found {
-				if m.IsOptionalVariable(v) {
-					continue
-				}
-
-				return nil, nil
-			}
-
-			acc, err := m.matchWithBindingss(bss, v, fv)
-			if nil != err {
-				return nil, err
-			}
-
-			if 0 == len(acc) {
-				return nil, nil
-			}
-			bss = acc
-		}
-	}
-	return bss, nil
-}
-
-// arraycatMatch attempts to extend the given list of bindingss 'bsss'
-// based on element-wise matching.
-//
-// An array represents a set; therefore, this function can backtrack,
-// which can be scary.
-func (m *Matcher) arraycatMatch(bsss [][]Bindings, pattern interface{}, fxas []map[int]interface{}) ([][]Bindings, []map[int]interface{}, error) {
-	var nbsss [][]Bindings
-	var nfxas []map[int]interface{}
-	for i, bss := range bsss {
-		mm := fxas[i]
-		for j, fact := range mm {
-			acc, err := m.matchWithBindingss(copyBindingss(bss), pattern, fact)
-			if nil != err {
-				return nil, nil, err
-			}
-			if 0 != len(acc) {
-				nbsss = append(nbsss, acc)
-				copy := copyMap(mm)
-				delete(copy, j)
-				nfxas = append(nfxas, copy)
-			}
-		}
-	}
-	return nbsss, nfxas, nil
-}
-
 func copyMap(source map[int]interface{}) map[int]interface{} {
 	target := make(map[int]interface{})
 	for p, v := range source {
@@ -310,53 +355,6 @@ func copyMap(source map[int]interface{}) map[int]interface{} {
 	return target
 }
 
-// matchWithBindings attempts to extend the given bindingss 'bss' from
-// matches of the fact against the pattern.
-//
-// Ths function mostly just calls 'Match()'.
-func (m *Matcher) matchWithBindingss(bss []Bindings, pattern interface{}, fact interface{}) ([]Bindings, error) {
-	acc := make([]Bindings, 0, len(bss))
-	for _, bs := range bss {
-		matches, err := m.Match(pattern, fact, bs)
-		if nil != err {
-			return nil, err
-		}
-		if nil != matches {
-			acc = append(acc, matches...)
-		}
-	}
-
-	return acc, nil
-}
-
-// getVariable finds the first variable and the other non-variables.
-// For now, we look for at most one variable.
-//
-// ToDo: Improve.
-func (m *Matcher) getVariable(xs []interface{}) (string, []interface{}, error) {
-	var v string
-	acc := make([]interface{}, 0, len(xs))
-	for _, x := range xs {
-		switch x.(type) {
-		case string:
-			s := x.(string)
-			if m.IsVariable(s) {
-				if v == "" {
-					v = s
-					continue
-				}
-				if v == s {
-					return "", nil, errors.New("repeated variables not supported")
-				}
-
-				return "", nil, errors.New("multiple variables not supported here")
-			}
-		}
-		acc = append(acc, x)
-	}
-	return v, acc, nil
-}
-
 // Matches attempts to match the given fact with the given pattern.
 // Returns an array of 'Bindings'.  Each Bindings is just a map from
 // variables to their values.
@@ -389,197 +387,12 @@ func fudge(x interface{}) interface{} {
 // Match is a verion of 'Matches' that takes initial bindings.
 //
 // Those initial bindings are not modified.
+//
+// Match compiles the pattern each time.  If a pattern will be used
+// repeatedly, use Compile.
 func (m *Matcher) Match(pattern interface{}, fact interface{}, bindings Bindings) ([]Bindings, error) {
-	return m.match(pattern, fact, bindings.Copy())
-}
-
-// match is a verion of 'Matches' that takes initial bindings (which
-// can be modified).
-func (m *Matcher) match(pattern interface{}, fact interface{}, bindings Bindings) ([]Bindings, error) {
-
-	pattern = fudge(pattern)
-	fact = fudge(fact)
-
-	// ToDo: Review for garbage reduction.
-
-	if bindings == nil {
-		return nil, nil
-	}
-
-	p := pattern
-	f := fact
-	bs := bindings
-
-	switch vv := p.(type) {
-	case nil:
-		switch f.(type) {
-		case nil:
-			return []Bindings{bindings}, nil
-		default:
-			return nil, nil
-		}
-
-	// case reflect.Value:
-	// 	return match(vv.Interface(), fact, bindings)
-
-	case bool:
-		switch f.(type) {
-		case bool:
-			y := f.(bool)
-			if vv == y {
-				return []Bindings{bindings}, nil
-			}
-			return nil, nil
-		default:
-			return nil, nil
-		}
-
-	case float64:
-		switch f.(type) {
-		case float64:
-			y := f.(float64)
-			if vv == y {
-				return []Bindings{bindings}, nil
-			} else {
-				return nil, nil
-			}
-		default:
-			return nil, nil
-		}
-
-	case string:
-		if m.IsConstant(vv) {
-			switch f.(type) {
-			case string:
-				fs := f.(string)
-				if vv == fs {
-					return []Bindings{bindings}, nil
-				} else {
-					return nil, nil
-				}
-			default:
-				return nil, nil
-			}
-		} else { // IsVariable
-			if m.IsAnonymousVariable(vv) {
-				return []Bindings{bs}, nil
-			}
-			if using, bss, err := m.inequal(fact, bindings, vv); err != nil {
-				return nil, err
-			} else if using {
-				return bss, err
-			}
-			binding, found := bs[vv]
-			if found {
-				return m.match(binding, fact, bindings)
-			} else {
-				// add new binding
-				bs[vv] = fact
-				return []Bindings{bs}, nil
-			}
-		}
-
-	case map[string]interface{}:
-		mm, ok := p.(map[string]interface{})
-		if !ok {
-			mm = map[string]interface{}(p.(mmap))
-		}
-		switch f.(type) {
-		case map[string]interface{}:
-			fm, ok := f.(map[string]interface{})
-			if !ok {
-				fm = map[string]interface{}(f.(mmap))
-			}
-			if 0 == len(mm) {
-				// Empty map pattern matched any given map.
-				return []Bindings{bs}, nil
-			}
-			return m.mapcatMatch([]Bindings{bs}, mm, fm)
-		default:
-			return nil, nil
-		}
-
-	case []interface{}:
-		//separate variable and constants
-		v, xs, err := m.getVariable(vv)
-		if nil != err {
-			return nil, err
-		}
-		switch f.(type) {
-		case []interface{}:
-
-			// index fact array, separate array/map from the string/float fact
-			fa := f.([]interface{})
-			fxs := make(map[interface{}]bool)
-			fxa := make(map[int]interface{})
-			for i, y := range fa {
-				switch y.(type) {
-				case float64, string, bool, nil:
-					fxs[y] = true
-				default:
-					fxa[i] = y
-				}
-			}
-
-			bsss := [][]Bindings{{bindings}}
-			fxas := []map[int]interface{}{fxa}
-
-			// iterate pattern values and match with fact values
-			for _, x := range xs {
-				switch x.(type) {
-				case float64, string, bool, nil:
-					_, found := fxs[x]
-					if found {
-						delete(fxs, x)
-					} else {
-						return nil, nil
-					}
-				default:
-					if 0 == len(fxa) {
-						return nil, nil
-					} else {
-						bsss, fxas, err = m.arraycatMatch(bsss, x, fxas)
-						if nil != err {
-							return nil, err
-						}
-						if nil == bsss {
-							return nil, nil
-						}
-					}
-				}
-			}
-
-			// merge left-over facts
-			for _, fxa := range fxas {
-				i := len(fa)
-				for fact := range fxs {
-					fxa[i] = fact
-					i++
-				}
-			}
-
-			// bind pattern variable and match again
-			if "" == v {
-				return combine(bsss), nil
-			} else {
-				previous := bsss
-				bsss, fxas, err = m.arraycatMatch(bsss, v, fxas)
-				if nil != err {
-					return nil, err
-				}
-				if len(bsss) == 0 && m.IsOptionalVariable(v) {
-					bsss = previous
-				}
-				return combine(bsss), nil
-			}
-
-		default:
-			return nil, nil
-		}
-
-	default:
-		return nil, &UnknownPatternType{p}
-	}
+	var err error
+	return m.compile(pattern, &err).match(m, fact, bindings.Copy())
 }
 
 func combine(bsss [][]Bindings) []Bindings {
@@ -619,94 +432,6 @@ func (e *UnknownPatternType) Error() string {
 // mmap is now a mystery to me.
 type mmap map[string]interface{}
 
-func (m *Matcher) inequal(fact interface{}, bs Bindings, v string) (bool, []Bindings, error) {
-	if !m.Inequalities {
-		return false, nil, nil
-	}
-
-	if v[0] != '?' {
-		return false, nil, nil
-	}
-
-	x, have := bs[v]
-	if !have {
-		return false, nil, nil
-	}
-	x = fudge(x)
-	b, is := x.(float64)
-	if !is {
-		return false, nil, nil
-	}
-
-	x = fudge(fact)
-	a, is := x.(float64)
-	if !is {
-		return false, nil, nil
-	}
-
-	var ineq, vv string
-	switch len(v) {
-	case 1, 2:
-		return false, nil, nil
-	default:
-		ineqv := v[1:]
-		for _, ie := range []string{"<=", ">=", "!=", ">", "<"} {
-			if strings.HasPrefix(ineqv, ie) {
-				ineq = ie
-				vv = "?" + ineqv[len(ie):]
-				break
-			}
-		}
-	}
-	if vv == "" {
-		return false, nil, nil
-	}
-
-	satisfied := false
-	switch ineq {
-	case "<":
-		if a < b {
-			satisfied = true
-		}
-	case "<=":
-		if a <= b {
-			satisfied = true
-		}
-	case ">":
-		if a > b {
-			satisfied = true
-		}
-	case ">=":
-		if a >= b {
-			satisfied = true
-		}
-	case "!=":
-		if a != b {
-			satisfied = true
-		}
-	}
-
-	if !satisfied {
-		return true, nil, nil
-	}
-
-	x, given := bs[vv]
-	if given {
-		c, is := fudge(x).(float64)
-		if !is {
-			return false, nil, nil
-		}
-		if c != a {
-			return true, nil, nil
-		}
-		// Don't need to update the bindings.
-		return true, []Bindings{bs}, nil
-	}
-
-	bs[vv] = a
-	return true, []Bindings{bs}, nil
-}
-
 func Match(pattern interface{}, fact interface{}, bindings Bindings) ([]Bindings, error) {
 	return DefaultMatcher.Match(pattern, fact, bindings)
 }
@@ -36,6 +36,10 @@ type MatchTest struct {
 	NoDoc         bool                     `json:"noDoc,omitempty"`
 	Verbose       bool                     `json:"verbose,omitempty"`
 	BenchmarkOnly bool                     `json:"benchmarkOnly,omitempty"`
+
+	// Matcher (if any) gives Matcher switches (like
+	// {"TypedVariables":true}) that override DefaultMatcher's.
+	Matcher json.RawMessage `json:"matcher,omitempty"`
 }
 
 func (t MatchTest) Name(i int) string {
@@ -80,6 +84,9 @@ func (t MatchTest) Fprintf(w io.Writer, i int) {
 	if t.Bindings != nil {
 		fmt.Fprintf(w, "with bindings\n```JSON\n%s\n```\n\n", JSON(t.Bindings))
 	}
+	if t.Matcher != nil {
+		fmt.Fprintf(w, "using a Matcher with\n```JSON\n%s\n```\n\n", t.Matcher)
+	}
 	if t.Error {
 		fmt.Fprintf(w, "should return an error.\n")
 	} else {
@@ -114,15 +121,55 @@ func compareMatchResult(bss []Bindings, expected []map[string]interface{}) bool
 	return 0 == len(m)
 }
 
+func (mt *MatchTest) bindings() Bindings {
+	if mt.Bindings == nil {
+		return make(Bindings)
+	}
+	return mt.Bindings
+}
+
+// matcher returns DefaultMatcher with the test's switches (if any).
+func (mt *MatchTest) matcher() (*Matcher, error) {
+	if mt.Matcher == nil {
+		return DefaultMatcher, nil
+	}
+	m := *DefaultMatcher
+	if err := json.Unmarshal(mt.Matcher, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
 func (mt *MatchTest) Run(t *testing.T, check bool) {
-	bs := mt.Bindings
-	if bs == nil {
-		bs = make(Bindings)
+	m, err := mt.matcher()
+	if err != nil {
+		if check {
+			t.Fatal(err)
+		}
+		return
 	}
-	bss, err := DefaultMatcher.Match(mt.Pattern, mt.Message, bs)
+	bss, err := m.Match(mt.Pattern, mt.Message, mt.bindings())
 	if !check {
 		return
 	}
+	mt.check(t, bss, err)
+}
+
+// RunCompiled is a version of Run that uses Compile.
+func (mt *MatchTest) RunCompiled(t *testing.T) {
+	m, err := mt.matcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := m.Compile(mt.Pattern)
+	var bss []Bindings
+	if err == nil {
+		bss, err = p.Match(mt.Message, mt.bindings())
+	}
+	mt.check(t, bss, err)
+}
+
+func (mt *MatchTest) check(t *testing.T, bss []Bindings, err error) {
 	if err != nil {
 		if !mt.Error {
 			t.Fatal(err)
@@ -194,3 +241,362 @@ func BenchmarkMatch(b *testing.B) {
 		})
 	}
 }
+
+func TestCompile(t *testing.T) {
+	tests, err := getMatchTests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range tests {
+		if test.BenchmarkOnly {
+			continue
+		}
+		t.Run(test.Name(i), func(t *testing.T) {
+			test.RunCompiled(t)
+		})
+	}
+
+	t.Run("reuse", func(t *testing.T) {
+		p, err := Compile(Dwimjs(`{"a":"?x","b":["?y",1]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs := NewBindings()
+		for _, x := range []float64{1, 2} {
+			bss, err := p.Match(Dwimjs(`{"a":1,"b":[1,2]}`), bs.Extend("?x", x))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if x == 1 && len(bss) != 1 || x == 2 && len(bss) != 0 {
+				t.Fatal(JS(bss))
+			}
+		}
+		if JS(p) != `{"a":"?x","b":["?y",1]}` {
+			t.Fatal(JS(p))
+		}
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		// Matcher.Match only complains if matching reaches the
+		// problem, but Compile always does.
+		p := Dwimjs(`{"a":1,"b":{"?x":1,"?y":2}}`)
+		if _, err := Match(p, Dwimjs(`{"a":2}`), NewBindings()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Compile(p); err == nil {
+			t.Fatal("should have complained")
+		}
+		if _, err := Compile(map[string]interface{}{"a": uint(1)}); err == nil {
+			t.Fatal("should have complained")
+		}
+	})
+}
+
+func BenchmarkMatchCompiled(b *testing.B) {
+	tests, err := getMatchTests()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i, test := range tests {
+		m, err := test.matcher()
+		if err != nil {
+			b.Fatal(err)
+		}
+		p, err := m.Compile(test.Pattern)
+		if err != nil {
+			continue
+		}
+		bs := test.bindings()
+		b.Run(test.Name(i), func(b *testing.B) {
+			for j := 0; j < b.N; j++ {
+				p.Match(test.Message, bs)
+			}
+		})
+	}
+}
+
+// BenchmarkArraySet shows how the cost of matching an array as a set
+// grows with the size of the fact's array, compared with the ordered
+// array modes.  See Matcher.ArrayModes.
+func BenchmarkArraySet(b *testing.B) {
+	m := *DefaultMatcher
+	m.ArrayModes = true
+
+	for _, n := range []int{10, 100, 1000} {
+		fact := make([]interface{}, n)
+		for i := range fact {
+			fact[i] = map[string]interface{}{"id": float64(i)}
+		}
+		for _, pc := range []struct {
+			name, p string
+		}{
+			// One set of bindings.
+			{"set", `[{"id":0}]`},
+			// n-1 sets of bindings.
+			{"set-var", `[{"id":0},"?x"]`},
+			// n(n-1) sets of bindings.
+			{"set-pair", `[{"id":"?a"},{"id":"?b"}]`},
+			// Rejected by its length.
+			{"exact", `{"$array":{"mode":"exact","elems":[{"id":0},"?x"],"min":2,"max":2}}`},
+			// One set of bindings.
+			{"prefix", `{"$array":{"mode":"prefix","elems":[{"id":0}],"rest":"?rest"}}`},
+		} {
+			if pc.name == "set-pair" && 100 < n {
+				// Too slow to bother.
+				continue
+			}
+			p, err := m.Compile(Dwimjs(pc.p))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s-%d", pc.name, n), func(b *testing.B) {
+				for j := 0; j < b.N; j++ {
+					if _, err := p.Match(fact, NewBindings()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	m := *DefaultMatcher
+	m.StringPatterns = true
+	m.TypedVariables = true
+	m.Operators = true
+	m.PathKeys = true
+	m.ArrayModes = true
+
+	for _, tc := range []struct {
+		p, f, bs string
+		want     string
+	}{
+		{`{"likes":"?x"}`, `{"likes":"tacos"}`, `{}`,
+			`{"matched":true,"path":""}`},
+		{`{"likes":"tacos"}`, `{"likes":"chips"}`, `{}`,
+			`{"matched":false,"path":"/likes","reason":"value","expected":"tacos","actual":"chips"}`},
+		{`{"likes":"tacos"}`, `{"likes":1}`, `{}`,
+			`{"matched":false,"path":"/likes","reason":"type","expected":"string","actual":1}`},
+		{`{"likes":"tacos"}`, `{"wants":"tacos"}`, `{}`,
+			`{"matched":false,"path":"/likes","reason":"missing","expected":"tacos"}`},
+		{`{"a":{"b/c":{"d":"?x"}}}`, `{"a":{"b/c":{"d":2}}}`, `{"?x":1}`,
+			`{"matched":false,"path":"/a/b~1c/d","reason":"binding","expected":"?x","actual":2,"var":"?x","bound":1}`},
+		{`{"n":"?x","m":"?x"}`, `{"n":1,"m":2}`, `{}`,
+			`{"matched":false,"path":"/n","reason":"binding","expected":"?x","actual":1,"var":"?x","bound":2}`},
+		{`{"n":"?<n"}`, `{"n":12}`, `{"?<n":10}`,
+			`{"matched":false,"path":"/n","reason":"inequality","expected":"?<n","actual":12,"var":"?<n","bound":10}`},
+		{`{"n":"?<n"}`, `{"n":3}`, `{"?<n":10,"?n":4}`,
+			`{"matched":false,"path":"/n","reason":"binding","expected":"?<n","actual":3,"var":"?n","bound":4}`},
+		{`{"id":"?~id"}`, `{"id":"switch.den"}`, `{"?~id":"light\\..*"}`,
+			`{"matched":false,"path":"/id","reason":"stringPattern","expected":"?~id","actual":"switch.den","var":"?~id","bound":"light\\..*"}`},
+		{`{"t":"?t:number"}`, `{"t":"hot"}`, `{}`,
+			`{"matched":false,"path":"/t","reason":"type","expected":"number","actual":"hot","var":"?t:number"}`},
+		{`{"xs":["a","?x"]}`, `{"xs":["b","c"]}`, `{}`,
+			`{"matched":false,"path":"/xs","reason":"element","expected":"a","actual":["b","c"]}`},
+		{`{"t":{"$not":"ping"}}`, `{"t":"ping"}`, `{}`,
+			`{"matched":false,"path":"/t","reason":"operator","expected":{"$not":"ping"},"actual":"ping"}`},
+		{`{"$and":[{"a":1},{"b":2}]}`, `{"a":1,"b":3}`, `{}`,
+			`{"matched":false,"path":"/b","reason":"value","expected":2,"actual":3}`},
+		{`{"/a/b~1c":1}`, `{"a":{"b/c":2}}`, `{}`,
+			`{"matched":false,"path":"/a/b~1c","reason":"value","expected":1,"actual":2}`},
+		{`{"/a/b":1}`, `{"a":{}}`, `{}`,
+			`{"matched":false,"path":"/a/b","reason":"missing","expected":1}`},
+		{`{"xs":{"$array":{"mode":"exact","elems":[1,{"b":2}]}}}`, `{"xs":[1,{"b":3}]}`, `{}`,
+			`{"matched":false,"path":"/xs/1/b","reason":"value","expected":2,"actual":3}`},
+		{`{"xs":{"$array":{"mode":"prefix","elems":[1,2]}}}`, `{"xs":[1]}`, `{}`,
+			`{"matched":false,"path":"/xs","reason":"length","expected":{"$array":{"elems":[1,2],"mode":"prefix"}},"actual":[1]}`},
+		{`{"xs":{"$array":{"max":1}}}`, `{"xs":"a"}`, `{}`,
+			`{"matched":false,"path":"/xs","reason":"type","expected":"array","actual":"a"}`},
+		{`{"n":"?<n"}`, `{"n":3}`, `{"?<n":true}`,
+			`{"matched":false,"path":"","reason":"error","expected":{"n":"?<n"},"actual":{"n":3},"error":"inequality ?<n with binding true and value 3: binding must be a number or a string"}`},
+	} {
+		t.Run(tc.p, func(t *testing.T) {
+			var bs Bindings
+			if err := json.Unmarshal([]byte(tc.bs), &bs); err != nil {
+				t.Fatal(err)
+			}
+			e := m.Explain(Dwimjs(tc.p), Dwimjs(tc.f), bs)
+			if got := JSON(e); got != tc.want+"\n" {
+				t.Fatalf("got %s want %s", got, tc.want)
+			}
+			if e.String() == "" {
+				t.Fatal("empty string")
+			}
+		})
+	}
+}
+
+func TestUnify(t *testing.T) {
+	m := *DefaultMatcher
+	m.StringPatterns = true
+	m.TypedVariables = true
+	m.Operators = true
+	m.ArrayModes = true
+
+	for _, tc := range []struct {
+		p, q string
+		want bool
+	}{
+		{`{"a":1}`, `{"a":1}`, true},
+		{`{"a":1}`, `{"a":2}`, false},
+		{`{"a":1}`, `{"b":2}`, true},
+		{`{"a":"?x"}`, `{"a":2}`, true},
+		{`{"a":"?x","b":"?x"}`, `{"a":1,"b":2}`, false},
+		{`{"a":"?x","b":"?x"}`, `{"a":"?y","b":2}`, true},
+		// Each pattern's variables are its own.
+		{`{"a":"?x","b":1}`, `{"a":2,"b":"?x"}`, true},
+		{`{"a":"?x","b":"?x"}`, `{"a":"?y","b":{"c":"?y"}}`, false},
+		{`{"a":"?"}`, `{"a":{"b":1}}`, true},
+		{`{"a":"??x"}`, `{"a":"??y"}`, true},
+		{`{"a":{"b":1},"c":"??x"}`, `{"c":{"b":2},"a":"??y"}`, true},
+		{`{"a":{"b":1}}`, `{"a":{"b":"??y"}}`, true},
+		{`{"a":1}`, `{"a":[1]}`, false},
+		{`{"a":["x"]}`, `{"a":["y","?z"]}`, true},
+		{`{"a":"?<n"}`, `{"a":"tacos"}`, true},
+		{`{"a":"?n:number"}`, `{"a":"tacos"}`, false},
+		{`{"a":"?n:string"}`, `{"a":"tacos"}`, true},
+		{`{"a":{"$or":[1,2]}}`, `{"a":2}`, true},
+		{`{"a":{"$or":[1,2]}}`, `{"a":3}`, false},
+		{`{"$and":[{"a":"?x"},{"b":"?x"}]}`, `{"a":1,"b":2}`, false},
+		{`{"?k":1}`, `{"a":2}`, true},
+		{`{"a":{"$array":{"mode":"exact","elems":[1]}}}`, `{"a":[2]}`, true},
+		{`{"a":{"$array":{"mode":"exact","elems":[1]}}}`, `{"a":"?x:array"}`, true},
+		{`{"a":{"$array":{"mode":"exact","elems":[1]}}}`, `{"a":"?x:object"}`, false},
+		{`{"a":{"$array":{"mode":"exact","elems":[1]}}}`, `{"a":{"b":1}}`, false},
+	} {
+		t.Run(tc.p+" "+tc.q, func(t *testing.T) {
+			for _, swap := range []bool{false, true} {
+				p, q := Dwimjs(tc.p), Dwimjs(tc.q)
+				if swap {
+					p, q = q, p
+				}
+				got, err := m.Unify(p, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tc.want {
+					t.Fatalf("swap %v: got %v", swap, got)
+				}
+			}
+		})
+	}
+
+	if _, err := Unify(Dwimjs(`["?x","?y"]`), Dwimjs(`[1]`)); err == nil {
+		t.Fatal("should have complained")
+	}
+}
+
+func TestSubsumes(t *testing.T) {
+	m := *DefaultMatcher
+	m.StringPatterns = true
+	m.TypedVariables = true
+	m.Operators = true
+	m.ArrayModes = true
+
+	for _, tc := range []struct {
+		g, s string
+		want bool
+	}{
+		{`{"a":1}`, `{"a":1,"b":2}`, true},
+		{`{"a":1,"b":2}`, `{"a":1}`, false},
+		{`{"a":"?x"}`, `{"a":1}`, true},
+		{`{"a":1}`, `{"a":"?x"}`, false},
+		{`{"a":"?x"}`, `{"a":"?y"}`, true},
+		{`{"a":"?x","b":"?x"}`, `{"a":"?y","b":"?y"}`, true},
+		{`{"a":"?x","b":"?x"}`, `{"a":"?y","b":"?z"}`, false},
+		{`{"a":"?x","b":"?x"}`, `{"a":"?","b":"?"}`, false},
+		{`{"a":"?x","b":"?x"}`, `{"a":1,"b":1}`, true},
+		{`{"a":"?"}`, `{"a":{"b":1}}`, true},
+		{`{"a":"?x"}`, `{"a":"??y"}`, false},
+		{`{"a":"??x"}`, `{"a":"??y"}`, true},
+		{`{"a":"??x"}`, `{"b":1}`, true},
+		{`{"a":"?<n"}`, `{"a":1}`, false},
+		{`{"a":"?x"}`, `{"a":"?<n"}`, true},
+		{`{"a":"?n:number"}`, `{"a":3}`, true},
+		{`{"a":"?n:number"}`, `{"a":"?m"}`, false},
+		{`{"a":"?n:number"}`, `{"a":"?m:number"}`, true},
+		{`["a"]`, `["b","a"]`, true},
+		{`["a","b"]`, `["a"]`, false},
+		{`["a","?x"]`, `["a","b"]`, true},
+		{`["a","?x"]`, `["a"]`, false},
+		{`["a","??x"]`, `["a"]`, true},
+		{`["a","?x"]`, `["a","?y"]`, true},
+		{`["a","?x"]`, `["a","??y"]`, false},
+		{`[{"b":"?x"}]`, `[{"b":1},{"c":2}]`, true},
+		{`{"a":["?x"],"b":"?x"}`, `{"a":[1,2],"b":2}`, true},
+		{`{"a":["?x"],"b":"?x"}`, `{"a":[1],"b":2}`, false},
+		{`{"?k":1}`, `{"a":1}`, true},
+		{`{"?k":2}`, `{"a":1}`, false},
+		{`{"a":1}`, `{"?k":1}`, false},
+		{`{"a":{"$or":[1,2]}}`, `{"a":2}`, true},
+		{`{"a":{"$or":[1,2]}}`, `{"a":{"$or":[2,1]}}`, true},
+		{`{"a":1}`, `{"a":{"$or":[1,2]}}`, false},
+		{`{"a":{"$not":3}}`, `{"a":2}`, true},
+		{`{"a":{"$not":3}}`, `{"a":"?x"}`, false},
+		{`{"a":1}`, `{"$and":[{"a":1},{"b":2}]}`, true},
+		{`{"$and":[{"a":1},{"b":2}]}`, `{"a":1,"b":2}`, true},
+		{`{"a":"?x:array"}`, `{"a":{"$array":{"mode":"exact","elems":[1]}}}`, true},
+		{`{"a":{"$array":{"mode":"exact","elems":[1]}}}`, `{"a":{"$array":{"mode":"exact","elems":[1]}}}`, false},
+		{`{"a":[1]}`, `{"a":{"$array":{"mode":"exact","elems":[1]}}}`, false},
+	} {
+		t.Run(tc.g+" "+tc.s, func(t *testing.T) {
+			got, err := m.Subsumes(Dwimjs(tc.g), Dwimjs(tc.s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %v", got)
+			}
+		})
+	}
+}
+
+func TestBindingsGetSet(t *testing.T) {
+	bs := NewBindings()
+	if err := json.Unmarshal([]byte(`{"?msg":{"payload":{"battery":80,"readings":[1,2]}},"a/b":1}`), &bs); err != nil {
+		t.Fatal(err)
+	}
+	shared := bs["?msg"]
+
+	for path, want := range map[string]interface{}{
+		"/?msg/payload/battery":    80.0,
+		"/?msg/payload/readings/1": 2.0,
+		"/a~1b":                    1.0,
+	} {
+		if x, have := bs.Get(path); !have || x != want {
+			t.Fatal(path, x)
+		}
+	}
+	for _, path := range []string{"/?msg/payload/temp", "/?msg/payload/readings/2", "/?msg/payload/battery/x", "/?msg/~2", "?msg"} {
+		if x, have := bs.Get(path); have {
+			t.Fatal(path, x)
+		}
+	}
+
+	if err := bs.Set("/?msg/payload/battery", 79); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Set("/?msg/payload/readings/-", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Set("/?out/status/battery", "low"); err != nil {
+		t.Fatal(err)
+	}
+	js, err := json.Marshal(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"?msg":{"payload":{"battery":79,"readings":[1,2,3]}},"?out":{"status":{"battery":"low"}},"a/b":1}`; string(js) != want {
+		t.Fatal(string(js))
+	}
+	if js, _ = json.Marshal(shared); string(js) != `{"payload":{"battery":80,"readings":[1,2]}}` {
+		t.Fatalf("Set modified a shared value: %s", js)
+	}
+
+	for _, path := range []string{"", "/?msg/payload/battery/x", "/?msg/payload/readings/9"} {
+		if err := bs.Set(path, 1); err == nil {
+			t.Fatal(path)
+		}
+	}
+}
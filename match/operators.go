@@ -0,0 +1,141 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"errors"
+	"reflect"
+)
+
+const (
+	// OpNot is the operator for negation.  See
+	// Matcher.Operators.
+	OpNot = "$not"
+
+	// OpOr is the operator for disjunction.  See
+	// Matcher.Operators.
+	OpOr = "$or"
+
+	// OpAnd is the operator for conjunction.  See
+	// Matcher.Operators.
+	OpAnd = "$and"
+)
+
+// IsOperator reports whether the given pattern is an operator
+// expression (see Matcher.Operators) like {"$not":{"likes":"queso"}}.
+//
+// Any single-property map whose property is OpNot, OpOr, or OpAnd
+// qualifies, so the operand isn't checked here.  Compile reports a
+// bad operand.
+func IsOperator(pattern interface{}) bool {
+	m, is := pattern.(map[string]interface{})
+	if !is || len(m) != 1 {
+		return false
+	}
+	for k := range m {
+		switch k {
+		case OpNot, OpOr, OpAnd:
+			return true
+		}
+	}
+	return false
+}
+
+// compileOperator compiles an operator expression.  See
+// Matcher.Operators.
+func (m *Matcher) compileOperator(op string, operand interface{}, problem func(error) error, err *error) node {
+	if op == OpNot {
+		return &notNode{
+			p: m.compile(operand, err),
+		}
+	}
+
+	ps, is := operand.([]interface{})
+	if !is || len(ps) == 0 {
+		return &badNode{problem(errors.New(`operator "` + op + `" needs an array of patterns`))}
+	}
+	ns := make([]node, 0, len(ps))
+	for _, p := range ps {
+		ns = append(ns, m.compile(p, err))
+	}
+	if op == OpOr {
+		return &orNode{ps: ns}
+	}
+	return &andNode{ps: ns}
+}
+
+// notNode matches a fact that the pattern doesn't match.
+//
+// Variables bound by the pattern aren't returned.
+type notNode struct {
+	p node
+}
+
+func (n *notNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	bss, err := n.p.match(m, fact, bs.Copy())
+	if err != nil {
+		return nil, err
+	}
+	if 0 < len(bss) {
+		return nil, nil
+	}
+	return []Bindings{bs}, nil
+}
+
+// orNode returns the union of the Bindings from each pattern.
+//
+// Duplicate Bindings are removed, so a fact that matches more than
+// one pattern the same way doesn't result in ambiguity.
+type orNode struct {
+	ps []node
+}
+
+func (n *orNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	var acc []Bindings
+	for _, p := range n.ps {
+		bss, err := p.match(m, fact, bs.Copy())
+		if err != nil {
+			return nil, err
+		}
+	BSS:
+		for _, x := range bss {
+			for _, y := range acc {
+				if reflect.DeepEqual(x, y) {
+					continue BSS
+				}
+			}
+			acc = append(acc, x)
+		}
+	}
+	return acc, nil
+}
+
+// andNode matches each pattern in turn.
+type andNode struct {
+	ps []node
+}
+
+func (n *andNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	bss := []Bindings{bs}
+	for _, p := range n.ps {
+		acc, err := matchWithBindingss(m, bss, p, fact)
+		if err != nil {
+			return nil, err
+		}
+		if 0 == len(acc) {
+			return nil, nil
+		}
+		bss = acc
+	}
+	return bss, nil
+}
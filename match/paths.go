@@ -0,0 +1,191 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// IsPathKey reports whether the given property name is a JSON Pointer
+// (see Matcher.PathKeys), which is any name that starts with "/".
+//
+// Use ParsePointer to check that the pointer's escapes are valid.
+func IsPathKey(k string) bool {
+	return strings.HasPrefix(k, "/")
+}
+
+// ParsePointer parses a JSON Pointer (RFC 6901) into its reference
+// tokens.
+//
+// The empty string, which refers to an entire value, has no tokens.
+func ParsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !IsPathKey(p) {
+		return nil, errors.New(`pointer "` + p + `" doesn't start with "/"`)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		if !strings.Contains(t, "~") {
+			continue
+		}
+		for j := 0; j < len(t); j++ {
+			if t[j] == '~' && (j+1 == len(t) || (t[j+1] != '0' && t[j+1] != '1')) {
+				return nil, errors.New(`pointer "` + p + `" has a bad escape`)
+			}
+		}
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses a reference token as an index into an array with
+// the given length.
+func arrayIndex(token string, n int) (int, bool) {
+	if token == "" || (1 < len(token) && token[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || n <= i {
+		return 0, false
+	}
+	return i, true
+}
+
+// getPath follows the given reference tokens into x.
+func getPath(x interface{}, tokens []string) (interface{}, bool) {
+	for _, t := range tokens {
+		switch vv := x.(type) {
+		case map[string]interface{}:
+			y, have := vv[t]
+			if !have {
+				return nil, false
+			}
+			x = y
+		case Bindings:
+			y, have := vv[t]
+			if !have {
+				return nil, false
+			}
+			x = y
+		case []interface{}:
+			i, ok := arrayIndex(t, len(vv))
+			if !ok {
+				return nil, false
+			}
+			x = vv[i]
+		default:
+			return nil, false
+		}
+	}
+	return x, true
+}
+
+// setPath returns a copy of x with the value at the given reference
+// tokens replaced by v.  Maps and arrays along the way are copied,
+// and missing properties are created.
+func setPath(x interface{}, tokens []string, v interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return v, nil
+	}
+	t := tokens[0]
+	switch vv := x.(type) {
+	case nil:
+		y, err := setPath(nil, tokens[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{t: y}, nil
+	case map[string]interface{}:
+		y, err := setPath(vv[t], tokens[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		acc := make(map[string]interface{}, len(vv)+1)
+		for k, w := range vv {
+			acc[k] = w
+		}
+		acc[t] = y
+		return acc, nil
+	case []interface{}:
+		var (
+			i  int
+			ok bool
+		)
+		if t == "-" {
+			i, ok = len(vv), true
+		} else {
+			i, ok = arrayIndex(t, len(vv))
+		}
+		if !ok {
+			return nil, errors.New(`no element "` + t + `" in an array of length ` + strconv.Itoa(len(vv)))
+		}
+		acc := make([]interface{}, len(vv), len(vv)+1)
+		copy(acc, vv)
+		if i == len(vv) {
+			acc = append(acc, nil)
+		}
+		y, err := setPath(acc[i], tokens[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		acc[i] = y
+		return acc, nil
+	default:
+		return nil, errors.New(`can't set "` + t + `" in a ` + TypeOf(x))
+	}
+}
+
+// Get returns the value at the given JSON Pointer (RFC 6901).  The
+// pointer's first reference token is the name of a binding.  For
+// example, given the bindings {"?msg":{"payload":{"battery":80}}},
+// the path "/?msg/payload/battery" gives 80.
+//
+// Returns false if there's no such value or the path isn't a JSON
+// Pointer.  The empty path gives the Bindings themselves.
+func (bs Bindings) Get(path string) (interface{}, bool) {
+	tokens, err := ParsePointer(path)
+	if err != nil {
+		return nil, false
+	}
+	if len(tokens) == 0 {
+		return bs, true
+	}
+	return getPath(bs, tokens)
+}
+
+// Set sets the value at the given JSON Pointer (RFC 6901), creating
+// maps as needed.  The pointer's first reference token is the name
+// of a binding.  An array element must exist already, except that the
+// reference token "-" appends an element.
+//
+// The Bindings are modified, but the maps and arrays along the path
+// are copied rather than modified, since they might be shared.
+func (bs Bindings) Set(path string, v interface{}) error {
+	tokens, err := ParsePointer(path)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return errors.New("can't set the empty path")
+	}
+	x, err := setPath(bs[tokens[0]], tokens[1:], v)
+	if err != nil {
+		return errors.New("can't set " + path + ": " + err.Error())
+	}
+	bs[tokens[0]] = x
+	return nil
+}
@@ -0,0 +1,136 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+const (
+	// RegexpOp follows the leading '?' of a variable that
+	// matches a string using a regular expression.  See
+	// Matcher.StringPatterns.
+	RegexpOp = "~"
+
+	// GlobOp follows the leading '?' of a variable that matches
+	// a string using a glob.  See Matcher.StringPatterns.
+	GlobOp = "*"
+)
+
+// MaxCachedRegexps is the maximum number of compiled regular
+// expressions that are cached for string pattern variables.
+var MaxCachedRegexps = 1024
+
+var regexps = struct {
+	sync.Mutex
+	cache map[string]*regexp.Regexp
+}{
+	cache: make(map[string]*regexp.Regexp),
+}
+
+// getRegexp returns the compiled regular expression that must match
+// an entire string.
+func getRegexp(s string) (*regexp.Regexp, error) {
+	regexps.Lock()
+	defer regexps.Unlock()
+	if re, have := regexps.cache[s]; have {
+		return re, nil
+	}
+	re, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return nil, errors.New("bad regexp for string pattern variable: " + err.Error())
+	}
+	if MaxCachedRegexps <= len(regexps.cache) {
+		regexps.cache = make(map[string]*regexp.Regexp)
+	}
+	regexps.cache[s] = re
+	return re, nil
+}
+
+// stringMatch implements Matcher.StringPatterns.
+//
+// Returns false if the string pattern doesn't apply, in which case
+// the variable is just an ordinary variable.
+func (n *varNode) stringMatch(fact interface{}, bs Bindings) (bool, []Bindings, error) {
+	x, have := bs[n.name]
+	if !have {
+		return false, nil, nil
+	}
+	p, is := x.(string)
+	if !is {
+		return false, nil, nil
+	}
+	s, is := fact.(string)
+	if !is {
+		return false, nil, nil
+	}
+
+	var groups []string
+	switch n.op {
+	case RegexpOp:
+		re, err := getRegexp(p)
+		if err != nil {
+			return true, nil, err
+		}
+		if groups = re.FindStringSubmatch(s); groups == nil {
+			return true, nil, nil
+		}
+		names := re.SubexpNames()
+		// Bind the captured groups by number and by name.
+		named := make(map[string]interface{}, 2*len(groups))
+		for i := 1; i < len(groups); i++ {
+			named[n.target+"."+strconv.Itoa(i)] = groups[i]
+			if names[i] != "" {
+				named[n.target+"."+names[i]] = groups[i]
+			}
+		}
+		if !n.bind(s, named, bs) {
+			return true, nil, nil
+		}
+	case GlobOp:
+		matched, err := path.Match(p, s)
+		if err != nil {
+			return true, nil, errors.New("bad glob for string pattern variable: " + err.Error())
+		}
+		if !matched || !n.bind(s, nil, bs) {
+			return true, nil, nil
+		}
+	}
+
+	return true, []Bindings{bs}, nil
+}
+
+// bind binds the target variable to the given string and binds the
+// given groups.  A variable that's already bound must have the same
+// value.
+//
+// Returns false if an existing binding conflicts.
+func (n *varNode) bind(s string, groups map[string]interface{}, bs Bindings) bool {
+	if x, given := bs[n.target]; given && x != s {
+		return false
+	}
+	for k, v := range groups {
+		if x, given := bs[k]; given && x != v {
+			return false
+		}
+	}
+	bs[n.target] = s
+	for k, v := range groups {
+		bs[k] = v
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"strings"
+)
+
+// TypeSep separates a typed variable's name from its type.  See
+// Matcher.TypedVariables.
+const TypeSep = ":"
+
+// VariableTypes are the types that a typed variable can have.  See
+// Matcher.TypedVariables.
+var VariableTypes = map[string]bool{
+	"number":  true,
+	"string":  true,
+	"boolean": true,
+	"null":    true,
+	"array":   true,
+	"object":  true,
+}
+
+// SplitTypedVariable separates a typed variable like "?temp:number"
+// into its name ("?temp") and type ("number").
+//
+// The returned type is empty if the given string isn't a variable
+// with a type.  The type isn't checked against VariableTypes.
+func SplitTypedVariable(v string) (string, string) {
+	if !strings.HasPrefix(v, "?") {
+		return v, ""
+	}
+	i := strings.LastIndex(v, TypeSep)
+	if i < 0 {
+		return v, ""
+	}
+	return v[:i], v[i+len(TypeSep):]
+}
+
+// TypeOf returns the JSON type (one of the VariableTypes) of the
+// given value.
+//
+// Returns the empty string for a value that doesn't have a JSON type.
+func TypeOf(x interface{}) string {
+	switch fudge(x).(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+// UnknownVariableType is an error that reports a typed variable with
+// a type that isn't one of the VariableTypes.
+type UnknownVariableType struct {
+	Var  string
+	Type string
+}
+
+func (e *UnknownVariableType) Error() string {
+	return `unknown type "` + e.Type + `" for variable "` + e.Var + `"`
+}
+
+// typedNode implements Matcher.TypedVariables.
+type typedNode struct {
+	typ string
+
+	// v is the variable without its type.
+	v node
+}
+
+func (n *typedNode) match(m *Matcher, fact interface{}, bs Bindings) ([]Bindings, error) {
+	if TypeOf(fact) != n.typ {
+		return nil, nil
+	}
+	return n.v.match(m, fact, bs)
+}
@@ -0,0 +1,768 @@
+/* Copyright 2021 Comcast Cable Communications Management, LLC
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package match
+
+import (
+	"sort"
+)
+
+// Unify reports whether some fact could match both patterns.
+//
+// Each pattern's variables are its own: "?x" in one pattern and "?x"
+// in the other are different variables.  (Two branches' patterns
+// don't share bindings.)  Within a pattern, a variable that appears
+// more than once must have the same value everywhere.
+//
+// When Unify can't tell, it reports true, so a false result means the
+// patterns are certainly disjoint.  In particular:
+//
+//	Any two arrays unify, since an array is a set (see Matcher.Match)
+//	and a fact's array can have elements for both patterns.
+//	Variables inside arrays aren't bound.
+//
+//	An inequality or string pattern variable (see
+//	Matcher.Inequalities and Matcher.StringPatterns) unifies with
+//	anything, since its input binding is unknown.
+//
+//	A map with a property variable unifies with any map.
+//
+//	A "$not" (see Matcher.Operators) unifies with anything.
+//
+//	A path key (see Matcher.PathKeys) is just a key, so
+//	{"/a/b":1} and {"a":{"b":2}} unify.
+//
+//	An array mode (see Matcher.ArrayModes) unifies with any array.
+//
+// An optional variable allows a fact to lack the property, so two
+// optional variables for the same property always unify.  The
+// anonymous variable "?" unifies with anything.
+func (m *Matcher) Unify(p, q interface{}) (bool, error) {
+	if _, err := m.Compile(p); err != nil {
+		return false, err
+	}
+	if _, err := m.Compile(q); err != nil {
+		return false, err
+	}
+	u := &unifier{m: m}
+	ss := u.unify(term{0, p}, term{1, q}, make(subst))
+	return 0 < len(ss), nil
+}
+
+// Unify calls DefaultMatcher.Unify.
+func Unify(p, q interface{}) (bool, error) {
+	return DefaultMatcher.Unify(p, q)
+}
+
+// Subsumes reports whether the general pattern matches every fact
+// that the specific pattern matches.  For example, a branch whose
+// pattern is subsumed by an earlier branch's pattern (without a
+// guard) can never be taken.
+//
+// When Subsumes can't tell, it reports false, so a true result is
+// certain.  In particular:
+//
+//	A variable in the specific pattern could have any value, so only
+//	a variable in the general pattern subsumes it.  The general
+//	pattern's variables must bind consistently: {"a":"?x","b":"?x"}
+//	doesn't subsume {"a":"?y","b":"?z"}.
+//
+//	An optional variable in the specific pattern means a fact might
+//	lack that property, so the general pattern must not require it.
+//
+//	An inequality or string pattern variable in the general pattern
+//	(see Matcher.Inequalities and Matcher.StringPatterns) depends on
+//	an unknown input binding, so it subsumes nothing.
+//
+//	An array is a set, so each element of the general array must
+//	subsume a different element of the specific array, and the
+//	general array's variable (if any) needs a leftover element.
+//
+//	A "$not" (see Matcher.Operators) in the general pattern subsumes
+//	a specific pattern that it can't Unify with.
+//
+//	A path key (see Matcher.PathKeys) is just a key, so
+//	{"/a/b":"?x"} doesn't subsume {"a":{"b":1}}.
+//
+//	An array mode (see Matcher.ArrayModes) is only subsumed by a
+//	variable, and it subsumes nothing.
+func (m *Matcher) Subsumes(general, specific interface{}) (bool, error) {
+	if _, err := m.Compile(general); err != nil {
+		return false, err
+	}
+	if _, err := m.Compile(specific); err != nil {
+		return false, err
+	}
+	s := &subsumption{
+		m:     m,
+		named: make(map[string]*frozen),
+	}
+	bss := s.subsumes(general, s.freeze(specific), NewBindings())
+	return 0 < len(bss), nil
+}
+
+// Subsumes calls DefaultMatcher.Subsumes.
+func Subsumes(general, specific interface{}) (bool, error) {
+	return DefaultMatcher.Subsumes(general, specific)
+}
+
+// varKind classifies a variable according to the Matcher's switches.
+type varKind int
+
+const (
+	notVar varKind = iota
+	anonVar
+	plainVar
+
+	// specialVar is an inequality or string pattern variable.
+	specialVar
+)
+
+// classify determines the kind of the given string, its name
+// (without any type), and its type (if any).
+func (m *Matcher) classify(x interface{}) (varKind, string, string) {
+	s, is := x.(string)
+	if !is || !m.IsVariable(s) {
+		return notVar, "", ""
+	}
+	name, typ := s, ""
+	if m.TypedVariables {
+		name, typ = SplitTypedVariable(s)
+	}
+	n, is := m.compileVariable(name, func(err error) error { return err }).(*varNode)
+	switch {
+	case !is:
+		return anonVar, name, typ
+	case n.op == RegexpOp || n.op == GlobOp:
+		if m.StringPatterns {
+			return specialVar, name, typ
+		}
+	case n.op != "":
+		if m.Inequalities {
+			return specialVar, name, typ
+		}
+	}
+	return plainVar, name, typ
+}
+
+// propertyVariable returns the property variable (if any) of a map
+// pattern.
+func (m *Matcher) propertyVariable(pattern map[string]interface{}) (string, bool) {
+	if !m.AllowPropertyVariables || len(pattern) != 1 {
+		return "", false
+	}
+	for k := range pattern {
+		if m.IsVariable(k) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func sortedKeys(x map[string]interface{}) []string {
+	ks := make([]string, 0, len(x))
+	for k := range x {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// term is (part of) one of the patterns given to Unify.
+type term struct {
+	// side is 0 for the first pattern and 1 for the second.
+	side int
+	x    interface{}
+}
+
+type varKey struct {
+	side int
+	name string
+}
+
+// subst maps variables to terms.
+type subst map[varKey]term
+
+func (s subst) copy() subst {
+	acc := make(subst, len(s)+1)
+	for k, v := range s {
+		acc[k] = v
+	}
+	return acc
+}
+
+type unifier struct {
+	m *Matcher
+}
+
+// resolve follows the bindings for a plain variable.
+func (u *unifier) resolve(t term, s subst) term {
+	for {
+		kind, name, _ := u.m.classify(t.x)
+		if kind != plainVar {
+			return t
+		}
+		b, have := s[varKey{t.side, name}]
+		if !have {
+			return t
+		}
+		t = b
+	}
+}
+
+// typeOf returns the JSON type of a term that isn't a variable.
+//
+// Returns the empty string if the type isn't known.
+func (u *unifier) typeOf(t term) string {
+	if kind, _, _ := u.m.classify(t.x); kind != notVar {
+		return ""
+	}
+	if u.m.Operators && IsOperator(t.x) {
+		return ""
+	}
+	if u.m.ArrayModes && IsArrayMode(t.x) {
+		return "array"
+	}
+	return TypeOf(t.x)
+}
+
+// occurs reports whether the variable appears in the term.
+func (u *unifier) occurs(k varKey, t term, s subst) bool {
+	t = u.resolve(t, s)
+	switch vv := t.x.(type) {
+	case string:
+		kind, name, _ := u.m.classify(vv)
+		return kind == plainVar && t.side == k.side && name == k.name
+	case map[string]interface{}:
+		for _, x := range vv {
+			if u.occurs(k, term{t.side, x}, s) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, x := range vv {
+			if u.occurs(k, term{t.side, x}, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (u *unifier) unify(a, b term, s subst) []subst {
+	a, b = u.resolve(a, s), u.resolve(b, s)
+
+	if kind, name, typ := u.m.classify(a.x); kind != notVar {
+		if typ != "" {
+			if t := u.typeOf(b); t != "" && t != typ {
+				return nil
+			}
+		}
+		if kind != plainVar {
+			return []subst{s}
+		}
+		k := varKey{a.side, name}
+		if bk, bname, _ := u.m.classify(b.x); bk == plainVar && b.side == a.side && bname == name {
+			return []subst{s}
+		}
+		if u.occurs(k, b, s) {
+			return nil
+		}
+		s = s.copy()
+		s[k] = b
+		return []subst{s}
+	}
+
+	if kind, _, _ := u.m.classify(b.x); kind != notVar {
+		return u.unify(b, a, s)
+	}
+
+	if u.m.Operators {
+		if IsOperator(a.x) {
+			return u.operator(a, b, s)
+		}
+		if IsOperator(b.x) {
+			return u.operator(b, a, s)
+		}
+	}
+
+	if u.m.ArrayModes && (IsArrayMode(a.x) || IsArrayMode(b.x)) {
+		// Any array could have the right order and length.
+		if u.typeOf(a) == "array" && u.typeOf(b) == "array" {
+			return []subst{s}
+		}
+		return nil
+	}
+
+	switch av := fudge(a.x).(type) {
+	case map[string]interface{}:
+		bv, is := b.x.(map[string]interface{})
+		if !is {
+			return nil
+		}
+		return u.maps(a.side, av, b.side, bv, s)
+	case []interface{}:
+		if _, is := b.x.([]interface{}); !is {
+			return nil
+		}
+		return []subst{s}
+	case nil, bool, float64, string:
+		if av == fudge(b.x) {
+			return []subst{s}
+		}
+	}
+	return nil
+}
+
+func (u *unifier) operator(a, b term, s subst) []subst {
+	op := a.x.(map[string]interface{})
+	if ps, is := op[OpOr].([]interface{}); is {
+		var acc []subst
+		for _, p := range ps {
+			acc = append(acc, u.unify(term{a.side, p}, b, s)...)
+		}
+		return acc
+	}
+	if ps, is := op[OpAnd].([]interface{}); is {
+		ss := []subst{s}
+		for _, p := range ps {
+			var acc []subst
+			for _, s := range ss {
+				acc = append(acc, u.unify(term{a.side, p}, b, s)...)
+			}
+			if ss = acc; len(ss) == 0 {
+				return nil
+			}
+		}
+		return ss
+	}
+	// "$not"
+	return []subst{s}
+}
+
+func (u *unifier) maps(aside int, a map[string]interface{}, bside int, b map[string]interface{}, s subst) []subst {
+	if _, is := u.m.propertyVariable(a); is {
+		return []subst{s}
+	}
+	if _, is := u.m.propertyVariable(b); is {
+		return []subst{s}
+	}
+
+	ss := []subst{s}
+	for _, k := range sortedKeys(a) {
+		bv, have := b[k]
+		if !have {
+			continue
+		}
+		av := a[k]
+		absent := u.m.IsOptionalVariable(av) && u.m.IsOptionalVariable(bv)
+		var acc []subst
+		for _, s := range ss {
+			got := u.unify(term{aside, av}, term{bside, bv}, s)
+			if len(got) == 0 && absent {
+				got = []subst{s}
+			}
+			acc = append(acc, got...)
+		}
+		if ss = acc; len(ss) == 0 {
+			return nil
+		}
+	}
+	return ss
+}
+
+// frozen is a variable in the specific pattern given to Subsumes.
+//
+// Every use of a named variable is the same frozen, while each use of
+// the anonymous variable is a different one.
+type frozen struct {
+	// source is the variable as it appeared in the pattern.
+	source string
+
+	typ      string
+	optional bool
+}
+
+type subsumption struct {
+	m     *Matcher
+	named map[string]*frozen
+}
+
+// freeze replaces the variables in the specific pattern with frozen
+// variables.
+func (s *subsumption) freeze(x interface{}) interface{} {
+	switch vv := x.(type) {
+	case string:
+		kind, name, typ := s.m.classify(vv)
+		switch kind {
+		case notVar:
+			return vv
+		case anonVar:
+			return &frozen{source: vv, typ: typ}
+		}
+		// An inequality or string pattern variable matches a
+		// subset of what an ordinary variable matches, so it
+		// can be frozen like one.
+		f, have := s.named[name]
+		if !have {
+			f = &frozen{
+				source:   vv,
+				typ:      typ,
+				optional: s.m.IsOptionalVariable(vv),
+			}
+			s.named[name] = f
+		}
+		return f
+	case map[string]interface{}:
+		acc := make(map[string]interface{}, len(vv))
+		for k, v := range vv {
+			acc[k] = s.freeze(v)
+		}
+		return acc
+	case []interface{}:
+		acc := make([]interface{}, len(vv))
+		for i, v := range vv {
+			acc[i] = s.freeze(v)
+		}
+		return acc
+	}
+	return fudge(x)
+}
+
+// thaw undoes freeze.
+func thaw(x interface{}) interface{} {
+	switch vv := x.(type) {
+	case *frozen:
+		return vv.source
+	case map[string]interface{}:
+		acc := make(map[string]interface{}, len(vv))
+		for k, v := range vv {
+			acc[k] = thaw(v)
+		}
+		return acc
+	case []interface{}:
+		acc := make([]interface{}, len(vv))
+		for i, v := range vv {
+			acc[i] = thaw(v)
+		}
+		return acc
+	}
+	return x
+}
+
+// same reports whether two (frozen) terms always have the same
+// value.
+func same(x, y interface{}) bool {
+	switch xv := x.(type) {
+	case *frozen:
+		return x == y
+	case map[string]interface{}:
+		yv, is := y.(map[string]interface{})
+		if !is || len(xv) != len(yv) {
+			return false
+		}
+		for k, v := range xv {
+			if w, have := yv[k]; !have || !same(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		yv, is := y.([]interface{})
+		if !is || len(xv) != len(yv) {
+			return false
+		}
+		for i := range xv {
+			if !same(xv[i], yv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if _, is := y.(*frozen); is {
+		return false
+	}
+	switch y.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return x == y
+}
+
+func sameBindings(x, y Bindings) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for k, v := range x {
+		if w, have := y[k]; !have || !same(v, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// typeOf returns the JSON type of a frozen term.
+//
+// Returns the empty string if the type isn't known.
+func (s *subsumption) typeOf(x interface{}) string {
+	if f, is := x.(*frozen); is {
+		return f.typ
+	}
+	if s.m.Operators && IsOperator(x) {
+		return ""
+	}
+	if s.m.ArrayModes && IsArrayMode(x) {
+		return "array"
+	}
+	return TypeOf(x)
+}
+
+// subsumes returns the ways (if any) that the general pattern's
+// variables can be bound so that it matches every fact that the
+// (frozen) specific pattern matches.
+func (s *subsumption) subsumes(g, x interface{}, bs Bindings) []Bindings {
+	m := s.m
+
+	if kind, _, _ := m.classify(g); kind != notVar {
+		return s.variable(g.(string), x, bs)
+	}
+
+	if m.Operators {
+		if op, is := x.(map[string]interface{}); is && IsOperator(op) {
+			return s.specificOperator(g, op, bs)
+		}
+		if op, is := g.(map[string]interface{}); is && IsOperator(op) {
+			return s.generalOperator(op, x, bs)
+		}
+	}
+
+	if m.ArrayModes && (IsArrayMode(g) || IsArrayMode(x)) {
+		// Order and length are too much trouble.
+		return nil
+	}
+
+	switch gv := fudge(g).(type) {
+	case map[string]interface{}:
+		xv, is := x.(map[string]interface{})
+		if !is {
+			return nil
+		}
+		return s.maps(gv, xv, bs)
+	case []interface{}:
+		xv, is := x.([]interface{})
+		if !is {
+			return nil
+		}
+		return s.arrays(gv, xv, bs)
+	case nil, bool, float64, string:
+		if same(gv, x) {
+			return []Bindings{bs}
+		}
+	}
+	return nil
+}
+
+func (s *subsumption) variable(v string, x interface{}, bs Bindings) []Bindings {
+	kind, name, typ := s.m.classify(v)
+	if kind == specialVar {
+		return nil
+	}
+	if typ != "" && s.typeOf(x) != typ {
+		return nil
+	}
+	if kind == anonVar {
+		return []Bindings{bs}
+	}
+	if y, have := bs[name]; have {
+		if same(x, y) {
+			return []Bindings{bs}
+		}
+		return nil
+	}
+	return []Bindings{bs.Copy().Extend(name, x)}
+}
+
+func (s *subsumption) generalOperator(op map[string]interface{}, x interface{}, bs Bindings) []Bindings {
+	if ps, is := op[OpOr].([]interface{}); is {
+		var acc []Bindings
+		for _, p := range ps {
+			acc = append(acc, s.subsumes(p, x, bs)...)
+		}
+		return acc
+	}
+	if ps, is := op[OpAnd].([]interface{}); is {
+		bss := []Bindings{bs}
+		for _, p := range ps {
+			var acc []Bindings
+			for _, bs := range bss {
+				acc = append(acc, s.subsumes(p, x, bs)...)
+			}
+			if bss = acc; len(bss) == 0 {
+				return nil
+			}
+		}
+		return bss
+	}
+	// "$not" matches every fact that its pattern can't match.
+	if overlap, err := s.m.Unify(op[OpNot], thaw(x)); err != nil || overlap {
+		return nil
+	}
+	return []Bindings{bs}
+}
+
+func (s *subsumption) specificOperator(g interface{}, op map[string]interface{}, bs Bindings) []Bindings {
+	if ps, is := op[OpOr].([]interface{}); is {
+		// The general pattern must subsume each alternative
+		// with the same bindings.
+		var acc []Bindings
+		for i, p := range ps {
+			got := s.subsumes(g, p, bs)
+			if i == 0 {
+				acc = got
+				continue
+			}
+			var keep []Bindings
+			for _, x := range acc {
+				for _, y := range got {
+					if sameBindings(x, y) {
+						keep = append(keep, x)
+						break
+					}
+				}
+			}
+			acc = keep
+		}
+		return acc
+	}
+	if ps, is := op[OpAnd].([]interface{}); is {
+		// Subsuming any conjunct is enough.
+		var acc []Bindings
+		for _, p := range ps {
+			acc = append(acc, s.subsumes(g, p, bs)...)
+		}
+		return acc
+	}
+	// "$not": Only a variable (handled already) subsumes a
+	// negation.
+	return nil
+}
+
+func (s *subsumption) maps(g, x map[string]interface{}, bs Bindings) []Bindings {
+	m := s.m
+
+	if k, is := m.propertyVariable(g); is {
+		gv := g[k]
+		var acc []Bindings
+		for _, xk := range sortedKeys(x) {
+			xv := x[xk]
+			if f, is := xv.(*frozen); is && f.optional {
+				continue
+			}
+			var key interface{} = xk
+			if m.IsVariable(xk) {
+				key = s.freeze(xk)
+			}
+			for _, bs := range s.variable(k, key, bs) {
+				acc = append(acc, s.subsumes(gv, xv, bs)...)
+			}
+		}
+		return acc
+	}
+
+	bss := []Bindings{bs}
+	for _, k := range sortedKeys(g) {
+		gv := g[k]
+		optional := m.IsOptionalVariable(gv)
+		xv, have := x[k]
+		if !have {
+			if optional {
+				continue
+			}
+			return nil
+		}
+		if f, is := xv.(*frozen); is && f.optional && !optional {
+			return nil
+		}
+		var acc []Bindings
+		for _, bs := range bss {
+			acc = append(acc, s.subsumes(gv, xv, bs)...)
+		}
+		if bss = acc; len(bss) == 0 {
+			return nil
+		}
+	}
+	return bss
+}
+
+func (s *subsumption) arrays(g, x []interface{}, bs Bindings) []Bindings {
+	var (
+		gs   = make([]interface{}, 0, len(g))
+		gvar string
+		xs   = make([]interface{}, 0, len(x))
+		xvar *frozen
+	)
+	for _, y := range g {
+		if kind, _, _ := s.m.classify(y); kind != notVar {
+			gvar = y.(string)
+			continue
+		}
+		gs = append(gs, y)
+	}
+	for _, y := range x {
+		if f, is := y.(*frozen); is {
+			xvar = f
+			continue
+		}
+		xs = append(xs, y)
+	}
+
+	used := make([]bool, len(xs))
+
+	var assign func(i int, bs Bindings) []Bindings
+	assign = func(i int, bs Bindings) []Bindings {
+		if i < len(gs) {
+			var acc []Bindings
+			for j, y := range xs {
+				if used[j] {
+					continue
+				}
+				used[j] = true
+				for _, bs := range s.subsumes(gs[i], y, bs) {
+					acc = append(acc, assign(i+1, bs)...)
+				}
+				used[j] = false
+			}
+			return acc
+		}
+
+		if gvar == "" {
+			return []Bindings{bs}
+		}
+		// The general array's variable needs a leftover
+		// element.
+		var acc []Bindings
+		for j, y := range xs {
+			if !used[j] {
+				acc = append(acc, s.variable(gvar, y, bs)...)
+			}
+		}
+		if xvar != nil && !xvar.optional {
+			acc = append(acc, s.variable(gvar, xvar, bs)...)
+		}
+		if len(acc) == 0 && s.m.IsOptionalVariable(gvar) {
+			return []Bindings{bs}
+		}
+		return acc
+	}
+
+	return assign(0, bs)
+}
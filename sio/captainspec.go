@@ -29,8 +29,9 @@ var (
 // CrewOp is a crude structure for crew-level operations (such as
 // adding a machine).
 type CrewOp struct {
-	Update map[string]*crew.Machine `json:"update,omitempty"`
-	Delete []string                 `json:"delete,omitempty"`
+	Update  map[string]*crew.Machine `json:"update,omitempty"`
+	Delete  []string                 `json:"delete,omitempty"`
+	Suspend []string                 `json:"suspend,omitempty"`
 }
 
 // AsCrewOp attempts to interpret the given message (hopefully a map)
@@ -44,7 +45,7 @@ func AsCrewOp(msg interface{}) (*CrewOp, error) {
 	if err = json.Unmarshal(js, &op); err != nil {
 		return nil, err
 	}
-	if op.Update == nil && op.Delete == nil {
+	if op.Update == nil && op.Delete == nil && op.Suspend == nil {
 		// Not much of a a CrewOp.
 		return nil, nil
 	}
@@ -67,6 +68,13 @@ func (c *Crew) DoOp(ctx context.Context, op *CrewOp) error {
 		}
 	}
 
+	for _, mid := range op.Suspend {
+		c.Logf("Crew.Do Suspend %s", mid)
+		if err := c.SuspendMachine(ctx, mid); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -15,6 +15,7 @@ package sio
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -91,6 +92,15 @@ type Crew struct {
 	previous map[string]string
 	timers   *Timers
 
+	// subs indexes machines by the messages that could affect
+	// them.  See toMachines.
+	subs *crew.Subscriptions
+
+	// booted holds walks from Boots and Toobs (see
+	// core.Spec.ExecBoot) with emitted messages that ProcessMsg
+	// hasn't seen yet.
+	booted []*core.Walked
+
 	in  chan interface{}
 	out chan *Result
 
@@ -123,6 +133,7 @@ func (c *Crew) init(ctx context.Context) error {
 	c.Machines = make(map[string]*crew.Machine, 32)
 	c.changed = make(map[string]*Changed, 8)
 	c.previous = make(map[string]string, 8)
+	c.subs = crew.NewSubscriptions()
 
 	f := func(ctx context.Context, te *TimerEntry) {
 		c.in <- te.Msg
@@ -164,11 +175,35 @@ func (c *Crew) Errorf(format string, args ...interface{}) {
 	log.Printf("ERROR "+format, args...)
 }
 
+// Warnf writes a log with "WARNING" prepended.
+func (c *Crew) Warnf(format string, args ...interface{}) {
+	log.Printf("WARNING "+format, args...)
+}
+
 // SetMachine creates or updates a machine.
 //
 // When the mid is either (the variable) TimersMachine and the given
 // state is nil, the timers machine's state is reset.
+//
+// A new machine's bindings (from the given state, if any) are checked
+// against its spec's parameters, and the machine gets any defaults
+// (see core.Spec.InitialBindings).  Use RestoreMachine for a machine
+// that was loaded from storage.
 func (c *Crew) SetMachine(ctx context.Context, mid string, src *crew.SpecSource, state *core.State) error {
+	return c.setMachine(ctx, mid, src, state, false)
+}
+
+// RestoreMachine is SetMachine for a machine that was loaded from
+// storage.
+//
+// A restored machine keeps its bindings, which aren't checked against
+// its spec's parameters, and its spec's Boot (if any) gets the
+// reason core.BootRestore.
+func (c *Crew) RestoreMachine(ctx context.Context, mid string, src *crew.SpecSource, state *core.State) error {
+	return c.setMachine(ctx, mid, src, state, true)
+}
+
+func (c *Crew) setMachine(ctx context.Context, mid string, src *crew.SpecSource, state *core.State, restore bool) error {
 	m, have := c.Machines[mid]
 
 	if !have {
@@ -180,6 +215,13 @@ func (c *Crew) SetMachine(ctx context.Context, mid string, src *crew.SpecSource,
 		c.Machines[mid] = m
 	}
 
+	// Until the machine takes a walk, any message could matter.
+	defer func() {
+		if _, have := c.Machines[mid]; have {
+			c.subs.Set(mid, core.AnyInterest)
+		}
+	}()
+
 	if src != nil {
 		c.change(mid).SpecSrc = src
 	}
@@ -224,23 +266,114 @@ func (c *Crew) SetMachine(ctx context.Context, mid string, src *crew.SpecSource,
 			if err != nil {
 				return err
 			}
+			if state != nil && ss.Hash != "" && ss.Hash != spec.Id {
+				c.Warnf("SetMachine %s spec changed from %s to %s", mid, ss.Hash, spec.Id)
+			}
+			ss.Hash = spec.Id
+			c.change(mid).SpecSrc = ss
+			if !have && !restore {
+				// A new machine, so check its parameters.
+				bs, warnings, err := spec.InitialBindings(m.State.Bs)
+				for _, w := range warnings {
+					c.Warnf("SetMachine %s %s", mid, w)
+				}
+				if err != nil {
+					delete(c.Machines, mid)
+					delete(c.changed, mid)
+					return err
+				}
+				m.State.Bs = bs
+				c.change(mid).State = m.State.Copy()
+			}
+			old := m.SpecSource
 			m.SpecSource = ss
+			if have {
+				walked, err := m.SetSpec(ctx, spec, c.stepProps(ctx, m))
+				var me *core.MigrationError
+				if errors.As(err, &me) {
+					// The machine keeps its old spec.
+					m.SpecSource = old
+					c.change(mid).SpecSrc = old
+					return err
+				}
+				if walked == nil && err == nil {
+					// No Boot, but the State might have
+					// migrated.
+					c.change(mid).State = m.State.Copy()
+				}
+				return c.lifecycled(m, walked, err)
+			}
 			m.Specter = spec
+			reason := core.BootCreate
+			if restore {
+				reason = core.BootRestore
+			}
+			walked, err := m.Boot(ctx, reason, c.stepProps(ctx, m))
+			return c.lifecycled(m, walked, err)
 		}
 	}
 
 	return nil
 }
 
-// DeleteMachine removes a machine from the crew.
+// lifecycled records the results of a Boot or Toob.
+//
+// The machine's new state is recorded now, and ProcessMsg will
+// process any emitted messages along with the results of the current
+// (or next) message.
+func (c *Crew) lifecycled(m *crew.Machine, walked *core.Walked, err error) error {
+	if walked == nil {
+		return err
+	}
+	c.booted = append(c.booted, walked)
+	c.subs.Set(m.Id, core.AnyInterest)
+	if err != nil {
+		return err
+	}
+	c.change(m.Id).State = m.State.Copy()
+	return nil
+}
+
+// DeleteMachine removes a machine from the crew after running its
+// spec's Toob (if any).
 //
 // No error is returned if the machine doesn't exist.
 func (c *Crew) DeleteMachine(ctx context.Context, mid string) error {
+	if m, have := c.Machines[mid]; have {
+		walked, err := m.Toob(ctx, core.ToobDelete, c.stepProps(ctx, m))
+		if walked != nil {
+			c.booted = append(c.booted, walked)
+		}
+		if err != nil {
+			c.Errorf("DeleteMachine %s Toob %s", mid, err)
+		}
+	}
 	delete(c.Machines, mid)
+	c.subs.Remove(mid)
 	c.change(mid).Deleted = true
 	return nil
 }
 
+// SuspendMachine runs the machine's spec's Toob (if any) and then
+// removes the machine from the crew without deleting it.  The
+// machine's final state is reported as a change, so the machine can
+// be restored later via RestoreMachine.
+//
+// No error is returned if the machine doesn't exist.
+func (c *Crew) SuspendMachine(ctx context.Context, mid string) error {
+	m, have := c.Machines[mid]
+	if !have {
+		return nil
+	}
+	walked, err := m.Toob(ctx, core.ToobSuspend, c.stepProps(ctx, m))
+	if err := c.lifecycled(m, walked, err); err != nil {
+		return err
+	}
+	delete(c.Machines, mid)
+	c.subs.Remove(mid)
+	return nil
+}
+
 // ProcessMsg processes the given message and returns the results,
 // which can then be processed by the crew's Result coupling.
 func (c *Crew) ProcessMsg(ctx context.Context, msg interface{}) (*Result, error) {
@@ -273,7 +406,14 @@ func (c *Crew) ProcessMsg(ctx context.Context, msg interface{}) (*Result, error)
 			return nil, err
 		}
 
+		all := make([]*core.Walked, 0, len(walkeds)+len(c.booted))
+		all = append(all, c.booted...)
+		c.booted = nil
 		for _, walked := range walkeds {
+			all = append(all, walked)
+		}
+
+		for _, walked := range all {
 			emitted := make([]interface{}, 0, 8)
 			walked.DoEmitted(func(msg interface{}) error {
 				if m, is := msg.(map[string]interface{}); is {
@@ -407,14 +547,35 @@ func (c *Crew) allMachines() []string {
 	return acc
 }
 
+// subscribers returns the machines (except for the TimersMachine and
+// the CaptainMachine) whose current nodes could possibly consume the
+// message.  See crew.Subscriptions.
+//
+// A machine that doesn't care about the message would go nowhere
+// anyway, so presenting the message to only these machines gives the
+// same results as presenting it to allMachines.
+func (c *Crew) subscribers(msg interface{}) []string {
+	mids := c.subs.Subscribers(msg)
+	acc := mids[:0]
+	for _, mid := range mids {
+		switch mid {
+		case TimersMachine:
+		case CaptainMachine:
+		default:
+			acc = append(acc, mid)
+		}
+	}
+	return acc
+}
+
 // toMachines determines the set of machines that should see this
 // message.
 //
-// Calls allMachines if the message doesn't have a "to" property.
+// Calls subscribers if the message doesn't have a "to" property.
 func (c *Crew) toMachines(ctx context.Context, msg interface{}) ([]string, error) {
 	m, is := msg.(map[string]interface{})
 	if !is {
-		return c.allMachines(), nil
+		return c.subscribers(msg), nil
 	}
 	if x, have := m["to"]; have {
 		switch vv := x.(type) {
@@ -436,7 +597,7 @@ func (c *Crew) toMachines(ctx context.Context, msg interface{}) ([]string, error
 			return mids, nil
 		}
 	}
-	return c.allMachines(), nil
+	return c.subscribers(msg), nil
 }
 
 // RunMachines presents the message to the machines returned by
@@ -457,6 +618,7 @@ func (c *Crew) RunMachines(ctx context.Context, msg interface{}) (map[string]*co
 				c.Errorf("RunMachines %s", err)
 			} else {
 				acc[mid] = walked
+				c.spawn(ctx, m, walked, acc)
 			}
 		}
 	}
@@ -464,6 +626,37 @@ func (c *Crew) RunMachines(ctx context.Context, msg interface{}) (map[string]*co
 	return acc, nil
 }
 
+// spawn adds a machine for each State spawned during the given walk
+// (see core.Spec.FanOut).
+//
+// A new machine gets its parent's spec, and then the new machine
+// takes whatever steps it can without a message.  That walk is added
+// to the given map of walks.
+func (c *Crew) spawn(ctx context.Context, parent *crew.Machine, walked *core.Walked, acc map[string]*core.Walked) {
+	for _, st := range walked.Spawned() {
+		mid := crew.SpawnedId(parent.Id)
+		c.Logf("spawning %s from %s", mid, parent.Id)
+		m := &crew.Machine{
+			Id:         mid,
+			Specter:    parent.Specter,
+			SpecSource: parent.SpecSource,
+			State:      st,
+		}
+		c.Machines[mid] = m
+		ch := c.change(mid)
+		ch.SpecSrc = parent.SpecSource
+		ch.State = st.Copy()
+
+		walked, err := c.RunMachine(ctx, nil, m)
+		if err != nil {
+			c.Errorf("spawn %s", err)
+			continue
+		}
+		acc[mid] = walked
+		c.spawn(ctx, m, walked, acc)
+	}
+}
+
 // RunMachines presents the message to the given machine.
 func (c *Crew) RunMachine(ctx context.Context, msg interface{}, m *crew.Machine) (*core.Walked, error) {
 	if m.Specter == nil {
@@ -475,23 +668,16 @@ func (c *Crew) RunMachine(ctx context.Context, msg interface{}, m *crew.Machine)
 		return nil, fmt.Errorf("no Spectre.Spec for %s in %s", m.Id, c.Conf.Id)
 	}
 
-	props := core.StepProps{
-		"mid": m.Id,
-		"ctx": ctx,
-	}
-
-	// Only the captain can mess with the entire crew.  But our
-	// current demo captain doesn't prevent messing with the
-	// captain via itself!
-	if m.Id == "captain" {
-		props["crew"] = c
-	}
+	props := c.stepProps(ctx, m)
 
 	// if UnsafeCmd {
 	// 	props["exec"] = ecmascript.UnsafeCmd
 	// }
 
 	msgs := []interface{}{msg}
+	if msg == nil {
+		msgs = nil
+	}
 
 	walked, err := spec.Walk(ctx, m.State, msgs, c.Conf.Ctl, props)
 	if err != nil {
@@ -502,10 +688,28 @@ func (c *Crew) RunMachine(ctx context.Context, msg interface{}, m *crew.Machine)
 		m.State = to.Copy()
 		c.change(m.Id).State = to.Copy()
 	}
+	c.subs.Set(m.Id, m.Interest(walked))
 
 	return walked, err
 }
 
+// stepProps makes the StepProps for the given machine.
+func (c *Crew) stepProps(ctx context.Context, m *crew.Machine) core.StepProps {
+	props := core.StepProps{
+		"mid": m.Id,
+		"ctx": ctx,
+	}
+
+	// Only the captain can mess with the entire crew.  But our
+	// current demo captain doesn't prevent messing with the
+	// captain via itself!
+	if m.Id == "captain" {
+		props["crew"] = c
+	}
+
+	return props
+}
+
 // ResolveSpecSource attempts to find and compile a spec based o a
 // crew.SpecSource (or something that looks like one).
 //
@@ -522,17 +726,36 @@ func ResolveSpecSource(ctx context.Context, specSource interface{}) (*crew.SpecS
 		return nil, nil, err
 	}
 
+	spec, err := loadSpecSource(ctx, &src)
+	if err != nil || spec == nil {
+		return nil, nil, err
+	}
+
+	if err = crew.ResolveChildren(ctx, spec, crew.SpecProviderFunc(loadSpecSource)); err != nil {
+		return nil, nil, err
+	}
+
+	if err = spec.Compile(ctx, Interpreters, true); err != nil {
+		return nil, nil, err
+	}
+	return &src, spec, nil
+}
+
+// loadSpecSource gets the (uncompiled) Spec for the given source.
+//
+// Returns nil if the source has neither an inline Spec nor a URL.
+func loadSpecSource(ctx context.Context, src *crew.SpecSource) (*core.Spec, error) {
 	if src.Inline != nil {
-		if err = src.Inline.Compile(ctx, Interpreters, true); err != nil {
-			return nil, nil, err
-		}
-		return &src, src.Inline, nil
+		return src.Inline, nil
 	}
 
 	if src.URL != "" {
 		// Yikes.  We hate doing blocking IO. ToDo: Something better?
 
-		var body []byte
+		var (
+			body []byte
+			err  error
+		)
 		if strings.HasPrefix(src.URL, "file://") {
 			filename := src.URL[7:]
 			log.Println("ResolveSpecSource: reading file", filename)
@@ -540,7 +763,7 @@ func ResolveSpecSource(ctx context.Context, specSource interface{}) (*crew.SpecS
 		} else {
 			resp, err := http.Get(src.URL)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			body, err = ioutil.ReadAll(resp.Body)
 			resp.Body.Close()
@@ -553,15 +776,12 @@ func ResolveSpecSource(ctx context.Context, specSource interface{}) (*crew.SpecS
 			err = yaml.Unmarshal(body, &spec)
 		}
 		if err != nil {
-			return nil, nil, err
-		}
-		if err = spec.Compile(ctx, Interpreters, true); err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		return &src, &spec, nil
+		return &spec, nil
 	}
 
-	return nil, nil, nil
+	return nil, nil
 }
 
 // DefaultState returns a state at "state" with empty bindings.
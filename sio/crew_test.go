@@ -20,11 +20,15 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/Comcast/sheens/core"
+	"github.com/Comcast/sheens/crew"
+	"github.com/Comcast/sheens/match"
+	"github.com/Comcast/sheens/util/testutil"
 
 	"github.com/jsccast/yaml"
 )
@@ -90,7 +94,7 @@ quit
 		panic(err)
 	}
 	for mid, m := range ms {
-		if err := c.SetMachine(ctx, mid, m.SpecSource, m.State); err != nil {
+		if err := c.RestoreMachine(ctx, mid, m.SpecSource, m.State); err != nil {
 			panic(err)
 		}
 	}
@@ -129,6 +133,231 @@ quit
 
 }
 
+func TestCrewSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	io := NewStdio(false)
+	io.In = strings.NewReader("")
+	io.Out = ioutil.Discard
+
+	c, err := NewCrew(ctx, &CrewConf{Ctl: core.DefaultControl}, io)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specJS := `{"name":"sensors","nodes":{
+  "listen":{"branching":{"type":"message","branches":[
+    {"pattern":{"type":"motion","room":"?room"},"target":"motion"},
+    {"pattern":{"type":"door"},"target":"listen"}]}},
+  "motion":{"action":{"interpreter":"ecmascript","source":"_.out({saw:_.bindings['?room']}); return _.bindings;"},
+            "branching":{"branches":[{"target":"listen"}]}},
+  "wait":{"branching":{"branches":[{"pattern":{"ready":true},"target":"listen"}]}},
+  "done":{}}}`
+
+	var spec core.Spec
+	if err := json.Unmarshal([]byte(specJS), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for mid, node := range map[string]string{
+		"listener": "listen",
+		"waiter":   "wait",
+		"finished": "done",
+	} {
+		src := &crew.SpecSource{
+			Inline: &spec,
+		}
+		st := &core.State{
+			NodeName: node,
+			Bs:       match.NewBindings(),
+		}
+		if err := c.SetMachine(ctx, mid, src, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	route := func(msg string) string {
+		mids, err := c.toMachines(ctx, testutil.Dwimjs(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(mids)
+		return JS(mids)
+	}
+
+	// No machine has taken a walk yet, so every machine could
+	// care about any message.
+	if got := route(`{"type":"cat"}`); got != `["finished","listener","waiter"]` {
+		t.Fatal(got)
+	}
+
+	r, err := c.ProcessMsg(ctx, testutil.Dwimjs(`{"type":"motion","room":"den"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if JS(r.Emitted) != `[[{"saw":"den"}]]` {
+		t.Fatal(JS(r.Emitted))
+	}
+
+	for _, tc := range []struct {
+		msg  string
+		want string
+	}{
+		{`{"type":"cat"}`, `[]`},
+		{`{"type":"motion","room":"den"}`, `["listener"]`},
+		{`{"type":"door"}`, `["listener"]`},
+		{`{"ready":true}`, `[]`},
+		{`"motion"`, `[]`},
+		{`{"to":"*","type":"cat"}`, `["finished","listener","waiter"]`},
+		{`{"to":"waiter","ready":true}`, `["waiter"]`},
+	} {
+		if got := route(tc.msg); got != tc.want {
+			t.Fatalf("%s: %s != %s", tc.msg, got, tc.want)
+		}
+	}
+
+	// The machine's index entry follows its State.
+	if err := c.DeleteMachine(ctx, "listener"); err != nil {
+		t.Fatal(err)
+	}
+	if got := route(`{"type":"door"}`); got != `[]` {
+		t.Fatal(got)
+	}
+}
+
+func TestCrewSpawn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	io := NewStdio(false)
+	io.In = strings.NewReader("")
+	io.Out = ioutil.Discard
+
+	c, err := NewCrew(ctx, &CrewConf{Ctl: core.DefaultControl}, io)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specJS := `{"name":"fan","fanOut":true,"nodes":{
+  "start":{"branching":{"type":"message","branches":[
+    {"pattern":{"ids":["?id"]},"target":"listening"}]}},
+  "listening":{}}}`
+
+	var spec core.Spec
+	if err := json.Unmarshal([]byte(specJS), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	src := &crew.SpecSource{
+		Inline: &spec,
+	}
+	st := &core.State{
+		NodeName: "start",
+		Bs:       match.NewBindings(),
+	}
+	if err := c.SetMachine(ctx, "m", src, st); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.ProcessMsg(ctx, testutil.Dwimjs(`{"ids":["a","b","c"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The machine took one id, and two new machines took the
+	// others.
+	ids := make(map[interface{}]bool)
+	for mid, m := range c.Machines {
+		if mid == "captain" || mid == "timers" {
+			continue
+		}
+		if m.State.NodeName != "listening" {
+			t.Fatalf("%s at %s", mid, m.State.NodeName)
+		}
+		if _, have := r.Changed[mid]; !have {
+			t.Fatalf("%s didn't change", mid)
+		}
+		ids[m.State.Bs["?id"]] = true
+	}
+	if len(ids) != 3 {
+		t.Fatal(ids)
+	}
+}
+
+func TestCrewSetMachineParams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	io := NewStdio(false)
+	io.In = strings.NewReader("")
+	io.Out = ioutil.Discard
+
+	c, err := NewCrew(ctx, &CrewConf{Ctl: core.DefaultControl}, io)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specJS := `{"name":"door","paramSpecs":{
+  "door":{"primitiveType":"string"},
+  "max":{"primitiveType":"int","default":10}},
+  "nodes":{"start":{}}}`
+
+	var spec core.Spec
+	if err := json.Unmarshal([]byte(specJS), &spec); err != nil {
+		t.Fatal(err)
+	}
+	src := &crew.SpecSource{
+		Inline: &spec,
+	}
+
+	state := func(bs string) *core.State {
+		return &core.State{
+			NodeName: "start",
+			Bs:       match.Bindings(testutil.Dwimjs(bs).(map[string]interface{})),
+		}
+	}
+
+	// A new machine gets its parameters from its initial
+	// bindings, and it gets defaults for the others.
+	if err := c.SetMachine(ctx, "front", src, state(`{"door":"front"}`)); err != nil {
+		t.Fatal(err)
+	}
+	m, have := c.Machines["front"]
+	if !have {
+		t.Fatal("lost the new machine")
+	}
+	if m.State.Bs["door"] != "front" || m.State.Bs["max"] != 10.0 {
+		t.Fatal(JS(m.State.Bs))
+	}
+
+	// A new machine must have its required parameters.
+	for mid, st := range map[string]*core.State{
+		"missing": state(`{"max":3}`),
+		"bad":     state(`{"door":42}`),
+		"none":    nil,
+	} {
+		if err := c.SetMachine(ctx, mid, src, st); err == nil {
+			t.Fatalf("%s: should have complained about door", mid)
+		}
+		if _, have := c.Machines[mid]; have {
+			t.Fatalf("%s: kept a machine with bad parameters", mid)
+		}
+	}
+
+	// A restored machine keeps its bindings, even if the spec
+	// would no longer accept them.
+	if err := c.RestoreMachine(ctx, "old", src, state(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if m, have = c.Machines["old"]; !have {
+		t.Fatal("lost the restored machine")
+	}
+	if _, have := m.State.Bs["max"]; have {
+		t.Fatal(JS(m.State.Bs))
+	}
+}
+
 // yaml2json reads the file with the given name, parses the contents
 // as YAML, and returns a string of a JSON presentation of the object.
 //
@@ -212,7 +212,7 @@ func main() {
 		panic(err)
 	}
 	for mid, m := range ms {
-		if err := c.SetMachine(ctx, mid, m.SpecSource, m.State); err != nil {
+		if err := c.RestoreMachine(ctx, mid, m.SpecSource, m.State); err != nil {
 			panic(err)
 		}
 	}
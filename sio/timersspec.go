@@ -51,6 +51,7 @@ func (c *Crew) NewTimersSpec() *core.Spec {
 	spec := &core.Spec{
 		Name: "timers",
 		Doc:  "A machine that makes in-memory timers that send messages.",
+		Uses: []string{core.MatcherFeaturePrefix + "pathKeys"},
 		Nodes: map[string]*core.Node{
 			"start": {
 				Doc: "Wait to hear a request to create or delete a timer.",
@@ -58,7 +59,7 @@ func (c *Crew) NewTimersSpec() *core.Spec {
 					Type: "message",
 					Branches: []*core.Branch{
 						{
-							Pattern: mustParse(`{"makeTimer":{"in":"?in", "msg":"?msg", "id":"?id"}}`),
+							Pattern: mustParse(`{"/makeTimer/in":"?in", "/makeTimer/msg":"?msg", "/makeTimer/id":"?id"}`),
 							Target:  "make",
 						},
 						{
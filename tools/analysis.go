@@ -15,15 +15,18 @@
 package tools
 
 import (
+	"fmt"
 	"sort"
 
 	"github.com/Comcast/sheens/core"
+	"github.com/Comcast/sheens/match"
 )
 
 // SpecAnalysis embodies our endeavor to understand and critique the structure of a spec, much like examining the blueprint of a pencil, identifying every component from wood to graphite, and noting any imperfections or marvels.
 
 type SpecAnalysis struct {
-	spec *core.Spec // The blueprint itself, holding secrets of its creation.
+	spec    *core.Spec     // The blueprint itself, holding secrets of its creation.
+	matcher *match.Matcher // The sharpener the spec chose for its patterns (see core.UsesMatcher).
 
 	// Observations and findings, detailing the intricacies and potential flaws within.
 	Errors                []string
@@ -37,6 +40,15 @@ type SpecAnalysis struct {
 	MissingTargets        []string
 	BranchTargetVariables []string
 	Interpreters          []string // The artisans and their tools, bringing the spec to life.
+
+	// ShadowedBranches are branches that can never be taken because an earlier branch without a guard
+	// matches everything they match, like a pencil line drawn exactly over another.
+	ShadowedBranches []string
+
+	// Composites are the nodes that embed a child spec, like a pencil with an eraser attached.
+	Composites []string
+	Regions    []string
+	Children   map[string]*SpecAnalysis `json:",omitempty"`
 }
 
 // Analyze embarks on a journey to scrutinize the spec, seeking to uncover the harmony and discord within its design.
@@ -53,6 +65,25 @@ func Analyze(s *core.Spec) (*SpecAnalysis, error) {
 	terminal, targeted, interpreters := make([]string, 0, len(s.Nodes)), make(map[string]bool), make(map[string]bool)
 	hasEmptyTargets, missingTargets, branchTargetVariables := make(map[string]bool), make(map[string]bool), make(map[string]bool)
 
+	// Matcher features are the pencil's lead: asking for soft and hard lead at once is a conflict.
+	var problems []error
+	a.matcher, problems = core.UsesMatcher(s.Uses)
+	for _, err := range problems {
+		a.Errors = append(a.Errors, err.Error())
+	}
+
+	// Regions are like the colored pencils in a box: each marks its own line, all at once.
+	for _, r := range s.Regions {
+		if r == nil {
+			continue
+		}
+		a.Regions = append(a.Regions, r.Name)
+		targeted[r.Start] = true
+		if _, have := s.Nodes[r.Start]; !have {
+			missingTargets[r.Start] = true
+		}
+	}
+
 	// Delve into each node, akin to inspecting every component of a pencil, from its wood to the graphite core.
 	for name, n := range s.Nodes {
 		// Actions are deliberate steps, like the precise cutting of wood or molding of graphite.
@@ -63,6 +94,22 @@ func Analyze(s *core.Spec) (*SpecAnalysis, error) {
 			}
 		}
 
+		// Entry and exit actions are the sharpening before and the cap after.
+		for _, src := range []*core.ActionSource{n.EntrySource, n.ExitSource} {
+			if src != nil {
+				a.Actions++
+				interpreters[src.Interpreter] = true
+			}
+		}
+
+		// Composite nodes hold a whole other pencil inside, which gets its own inspection.
+		if n.Composite() {
+			a.Composites = append(a.Composites, name)
+			if err := a.analyzeChild(name, n); err != nil {
+				return nil, err
+			}
+		}
+
 		// Terminal nodes are like pencil ends; they signify completion or a pause.
 		if n.Branches == nil || len(n.Branches.Branches) == 0 {
 			terminal = append(terminal, name)
@@ -70,9 +117,22 @@ func Analyze(s *core.Spec) (*SpecAnalysis, error) {
 
 		// Check for nodes that make decisions, akin to choosing the path for a pencil's creation.
 		if n.Branches != nil {
-			for _, b := range n.Branches.Branches {
+			// Modes are the pencil's grade; an unknown grade is a smudge.
+			for _, mode := range n.Branches.Modes {
+				if !core.KnownBranchModes[mode] {
+					a.Errors = append(a.Errors, "node '"+name+"' has unknown branching mode '"+string(mode)+"'")
+				}
+			}
+			a.analyzeShadows(name, n.Branches)
+			if n.Branches.Fallback != "" {
+				targeted[n.Branches.Fallback] = true
+			}
+			for i, b := range n.Branches.Branches {
 				targeted[b.Target] = true
 				a.Branches++
+				if a.matcher.TypedVariables {
+					a.analyzeTypedVariables(name, i, b.Pattern) // Without typed variables, '?x:number' is just a pencil with a long name.
+				}
 				if b.Target == "" {
 					hasEmptyTargets[name] = true // Note any paths that lead nowhere, like a misdirected pencil stroke.
 				}
@@ -93,6 +153,9 @@ func Analyze(s *core.Spec) (*SpecAnalysis, error) {
 		}
 	}
 
+	sort.Strings(a.Composites)
+	sort.Strings(a.ShadowedBranches)
+
 	// Compile our findings, cataloging every detail and anomaly discovered in the spec's design.
 	a.TerminalNodes, a.EmptyTargets = terminal, keysToStringSlice(hasEmptyTargets)
 	a.Orphans = keysToStringSlice(diffKeys(s.Nodes, targeted))
@@ -104,6 +167,109 @@ func Analyze(s *core.Spec) (*SpecAnalysis, error) {
 	return &a, nil
 }
 
+// analyzeChild analyzes the child of a composite node, folding the child's errors into our own.
+func (a *SpecAnalysis) analyzeChild(name string, n *core.Node) error {
+	child := n.Child
+	if child == nil && n.ChildSource != nil {
+		child = n.ChildSource.Inline
+	}
+	if child == nil {
+		a.Errors = append(a.Errors, "node '"+name+"' has an unresolved child")
+		return nil
+	}
+	if n.Action != nil || n.ActionSource != nil {
+		a.Errors = append(a.Errors, "composite node '"+name+"' has an action")
+	}
+	start := n.ChildStart
+	if start == "" {
+		start = core.DefaultChildStart
+	}
+	if _, have := child.Nodes[start]; !have {
+		a.Errors = append(a.Errors, "node '"+name+"' child has no node '"+start+"'")
+	}
+	ca, err := Analyze(child)
+	if err != nil {
+		return err
+	}
+	for _, e := range ca.Errors {
+		a.Errors = append(a.Errors, "node '"+name+"' child: "+e)
+	}
+	if a.Children == nil {
+		a.Children = make(map[string]*SpecAnalysis)
+	}
+	a.Children[name] = ca
+	return nil
+}
+
+// analyzeShadows finds the branches that an earlier branch always beats (see match.Matcher.Subsumes) using the spec's matcher.
+// With exclusive branching every branch is tried, so nothing is shadowed; overlaps there are Compile's business.
+func (a *SpecAnalysis) analyzeShadows(name string, b *core.Branches) {
+	if b.Modes.Has(core.Exclusive) {
+		return
+	}
+	for j, later := range b.Branches {
+		if later == nil {
+			continue
+		}
+		for i, earlier := range b.Branches[:j] {
+			if earlier == nil || earlier.Guard != nil || earlier.GuardSource != nil {
+				continue
+			}
+			shadowed := earlier.Pattern == nil
+			if !shadowed && later.Pattern != nil {
+				shadowed, _ = a.matcher.Subsumes(earlier.Pattern, later.Pattern)
+			}
+			if shadowed {
+				a.ShadowedBranches = append(a.ShadowedBranches, fmt.Sprintf("node '%s' branch %d (shadowed by branch %d)", name, j, i))
+				break
+			}
+		}
+	}
+}
+
+// analyzeTypedVariables checks the typed variables (see match.Matcher.TypedVariables) in a branch's pattern,
+// like checking that every pencil in the box is labeled with a grade that actually exists.
+// An unknown type is an error, and so is a variable that's given two different types, since no value could ever match it.
+func (a *SpecAnalysis) analyzeTypedVariables(name string, i int, pattern interface{}) {
+	types := make(map[string]string)
+	var walk func(x interface{})
+	check := func(v string) {
+		base, typ := match.SplitTypedVariable(v)
+		switch {
+		case typ == "":
+		case !match.VariableTypes[typ]:
+			a.Errors = append(a.Errors, fmt.Sprintf("node '%s' branch %d has variable '%s' with unknown type '%s'", name, i, v, typ))
+		case a.matcher.IsAnonymousVariable(base):
+		default:
+			if had, have := types[base]; have && had != typ {
+				a.Errors = append(a.Errors, fmt.Sprintf("node '%s' branch %d has variable '%s' with types '%s' and '%s'", name, i, base, had, typ))
+			}
+			types[base] = typ
+		}
+	}
+	walk = func(x interface{}) {
+		switch vv := x.(type) {
+		case string:
+			check(vv)
+		case map[string]interface{}:
+			ks := make([]string, 0, len(vv))
+			for k := range vv {
+				ks = append(ks, k)
+			}
+			sort.Strings(ks) // So errors come out in the same order every time.
+			for _, k := range ks {
+				check(k)
+				walk(vv[k])
+			}
+		case []interface{}:
+			for _, v := range vv {
+				walk(v)
+			}
+		}
+	}
+	walk(pattern)
+}
+
 // keysToStringSlice converts the keys from a map into a slice of strings.
 // Optionally, it can add a default value if the map is empty.
 // A helper function to convert a map's keys to a sorted string slice, revealing the elements involved in our creation process.
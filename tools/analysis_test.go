@@ -14,6 +14,8 @@ package tools
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"testing"
 
 	"github.com/Comcast/sheens/core"
@@ -32,3 +34,203 @@ func TestAnalysis(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestAnalysisComposite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	child, err := core.TurnstileSpec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &core.Spec{
+		Name: "parent",
+		Nodes: map[string]*core.Node{
+			"start": {
+				ChildSource: &core.SpecSource{
+					Inline: child,
+				},
+				ChildStart: "locked",
+			},
+		},
+	}
+
+	if err := spec.Compile(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Analyze(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Composites) != 1 || a.Composites[0] != "start" {
+		t.Fatal(a.Composites)
+	}
+	if ca, have := a.Children["start"]; !have || ca.NodeCount != len(child.Nodes) {
+		t.Fatal(a.Children)
+	}
+
+	if err := Dot(spec, nopCloser{ioutil.Discard}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Mermaid(spec, nopCloser{ioutil.Discard}, nil, "", ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalysisTypedVariables(t *testing.T) {
+	spec := &core.Spec{
+		Name: "typed",
+		Uses: []string{"match:typedVariables"},
+		Nodes: map[string]*core.Node{
+			"start": {
+				Branches: &core.Branches{
+					Type: "message",
+					Branches: []*core.Branch{
+						{
+							Pattern: map[string]interface{}{"temp": "?temp:number", "ids": "?ids:array"},
+							Target:  "start",
+						},
+						{
+							Pattern: map[string]interface{}{"temp": "?temp:float"},
+							Target:  "start",
+						},
+						{
+							Pattern: map[string]interface{}{"a": "?x:number", "b": []interface{}{"?x:string"}},
+							Target:  "start",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	a, err := Analyze(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"node 'start' branch 1 has variable '?temp:float' with unknown type 'float'",
+		"node 'start' branch 2 has variable '?x' with types 'number' and 'string'",
+	}
+	if len(a.Errors) != len(expected) {
+		t.Fatal(a.Errors)
+	}
+	for i, e := range expected {
+		if a.Errors[i] != e {
+			t.Fatal(a.Errors[i])
+		}
+	}
+
+	// Without typed variables, these are just plain variables.
+	spec.Uses = nil
+	if a, err = Analyze(spec); err != nil {
+		t.Fatal(err)
+	}
+	if 0 < len(a.Errors) {
+		t.Fatal(a.Errors)
+	}
+}
+
+func TestAnalysisShadowedBranches(t *testing.T) {
+	spec := &core.Spec{
+		Name: "shadows",
+		Nodes: map[string]*core.Node{
+			"start": {
+				Branches: &core.Branches{
+					Type: "message",
+					Branches: []*core.Branch{
+						{
+							Pattern: map[string]interface{}{"likes": "?x"},
+							Target:  "start",
+						},
+						{
+							Pattern: map[string]interface{}{"likes": "tacos", "wants": "chips"},
+							Target:  "start",
+						},
+						{
+							Pattern: map[string]interface{}{"wants": "queso"},
+							Target:  "start",
+						},
+						{
+							Target: "start",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	a, err := Analyze(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.ShadowedBranches) != 1 || a.ShadowedBranches[0] != "node 'start' branch 1 (shadowed by branch 0)" {
+		t.Fatal(a.ShadowedBranches)
+	}
+}
+
+func TestAnalysisMatcherFeatures(t *testing.T) {
+	spec := &core.Spec{
+		Name: "features",
+		Uses: []string{"match:typedVariables", "match:-typedVariables", "match:telepathy"},
+		Nodes: map[string]*core.Node{
+			"start": {
+				Branches: &core.Branches{
+					Type: "message",
+					Branches: []*core.Branch{
+						{
+							Pattern: map[string]interface{}{"n": "?n:number"},
+							Target:  "start",
+						},
+						{
+							Pattern: map[string]interface{}{"n": "?n"},
+							Target:  "start",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	a, err := Analyze(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"conflicting matcher features 'match:typedVariables' and 'match:-typedVariables'",
+		"unknown matcher feature 'match:telepathy'",
+	}
+	if len(a.Errors) != len(expected) {
+		t.Fatal(a.Errors)
+	}
+	for i, e := range expected {
+		if a.Errors[i] != e {
+			t.Fatal(a.Errors[i])
+		}
+	}
+
+	// Without typed variables, "?n:number" is just a variable,
+	// so the first branch shadows the second.
+	if len(a.ShadowedBranches) != 1 {
+		t.Fatal(a.ShadowedBranches)
+	}
+
+	// With typed variables, it only matches numbers.
+	spec.Uses = []string{"match:typedVariables"}
+	if a, err = Analyze(spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Errors) != 0 || len(a.ShadowedBranches) != 0 {
+		t.Fatal(a.Errors, a.ShadowedBranches)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
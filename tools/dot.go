@@ -34,8 +34,29 @@ import (
 // The optional fromNode and toNode can be names of nodes during a
 // transition.  If non-zero, then the fromNode will be black and the
 // toNode will be red.  Maybe.
+//
+// The child of a composite node (see Node.ChildSource) is drawn as a
+// cluster.
 func Dot(spec *Spec, w io.WriteCloser, fromNode, toNode string) error {
 
+	fmt.Fprintf(w, "digraph G {\n")
+	// nodesep=0.3,ranksep=0.3,
+	fmt.Fprintf(w, `  graph [ordering=out,rankdir=TB,nodesep=0.3,ranksep=0.6]
+  node [shape="record" style="rounded,filled"]
+  edge [fontsize = "12"]
+`)
+
+	dotNodes(spec, w, "", fromNode, toNode)
+
+	fmt.Fprintf(w, "}\n")
+	return w.Close()
+}
+
+// dotNodes writes the nodes and edges for the given spec.  The
+// prefix, which is empty for the top-level spec, is prepended to
+// node ids.
+func dotNodes(spec *Spec, w io.Writer, prefix, fromNode, toNode string) {
+
 	yamlPatterns := true
 
 	// Use copies of states that don't have Name set.
@@ -46,13 +67,6 @@ func Dot(spec *Spec, w io.WriteCloser, fromNode, toNode string) error {
 
 	log.Printf("processing %d nodes", len(nodes))
 
-	fmt.Fprintf(w, "digraph G {\n")
-	// nodesep=0.3,ranksep=0.3,
-	fmt.Fprintf(w, `  graph [ordering=out,rankdir=TB,nodesep=0.3,ranksep=0.6]
-  node [shape="record" style="rounded,filled"]
-  edge [fontsize = "12"]
-`)
-
 	seen := make(map[string]bool)
 	node := func(name string, n *Node) error {
 		if n == nil {
@@ -111,8 +125,24 @@ func Dot(spec *Spec, w io.WriteCloser, fromNode, toNode string) error {
 		if n.Branches == nil || len(n.Branches.Branches) == 0 {
 			style += ",dashed"
 		}
+		if n.Child != nil {
+			style += ",bold"
+			fillcolor = "#f4e285"
+		}
 		fmt.Fprintf(w, "  %s [shape=\"%s\", style=\"%s\", color=\"%s\", fillcolor=\"%s\", label=<%s> ]\n",
-			name, shape, style, color, fillcolor, label)
+			prefix+name, shape, style, color, fillcolor, label)
+
+		if n.Child != nil {
+			id := prefix + name
+			fmt.Fprintf(w, "  subgraph cluster_%s {\n  label=<%s>\n  style=\"rounded,dashed\"\n", id, name)
+			dotNodes(n.Child, w, id+"__", "", "")
+			fmt.Fprintf(w, "  }\n")
+			start := n.ChildStart
+			if start == "" {
+				start = DefaultChildStart
+			}
+			fmt.Fprintf(w, "  %s -> %s [ style=\"dashed\" ]\n", id, id+"__"+start)
+		}
 
 		return nil
 	}
@@ -192,7 +222,7 @@ func Dot(spec *Spec, w io.WriteCloser, fromNode, toNode string) error {
 			// label = fmt.Sprintf("[%d/%d] %s", i+1, len(n.Branches.Branches), label)
 			label = fmt.Sprintf("%d/%d %s", i+1, len(n.Branches.Branches), label)
 			fmt.Fprintf(w, "  %s -> %s [ color=\"%s\" label = <%s> ]\n",
-				name, b.Target, color, label)
+				prefix+name, prefix+b.Target, color, label)
 		}
 
 		return nil
@@ -209,9 +239,6 @@ func Dot(spec *Spec, w io.WriteCloser, fromNode, toNode string) error {
 		}
 		process(name, n)
 	}
-
-	fmt.Fprintf(w, "}\n")
-	return w.Close()
 }
 
 // PNG generates a PNG image based on output from Dot.
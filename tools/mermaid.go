@@ -52,6 +52,43 @@ func Mermaid(spec *Spec, w io.WriteCloser, opts *MermaidOpts, fromNode, toNode s
 		}
 	}
 
+	fmt.Fprintf(w, "graph TB\n")
+
+	m := &mermaid{
+		w:    w,
+		opts: opts,
+		nids: make(map[string]string),
+	}
+
+	if err := m.nodes(spec, ""); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n")
+	log.Printf("mermaid gen done")
+
+	return w.Close()
+}
+
+// mermaid holds the state for generating a Mermaid graph, which can
+// include the children of composite nodes (see Node.ChildSource) as
+// subgraphs.
+type mermaid struct {
+	w    io.Writer
+	opts *MermaidOpts
+	nids map[string]string
+	num  int
+}
+
+// nodes writes the nodes and edges for the given spec.  The prefix,
+// which is empty for the top-level spec, distinguishes a child's
+// nodes from its parent's.
+func (m *mermaid) nodes(spec *Spec, prefix string) error {
+	var (
+		w    = m.w
+		opts = m.opts
+	)
+
 	// Use copies of states that don't have Name set.
 	nodes := make(map[string]*Node, len(spec.Nodes))
 	for name, n := range spec.Nodes {
@@ -60,19 +97,14 @@ func Mermaid(spec *Spec, w io.WriteCloser, opts *MermaidOpts, fromNode, toNode s
 
 	log.Printf("processing %d nodes", len(nodes))
 
-	fmt.Fprintf(w, "graph TB\n")
-
-	nids := make(map[string]string)
-	num := 0
-
 	node := func(name string, n *Node) (string, error) {
-		if nid, already := nids[name]; already {
+		if nid, already := m.nids[prefix+name]; already {
 			return nid, nil
 
 		}
-		num++
-		nid := fmt.Sprintf("n%d", num)
-		nids[name] = nid
+		m.num++
+		nid := fmt.Sprintf("n%d", m.num)
+		m.nids[prefix+name] = nid
 
 		if n != nil && n.Action == nil {
 			fmt.Fprintf(w, "  %s(\"%s\")\n", nid, name)
@@ -95,6 +127,18 @@ func Mermaid(spec *Spec, w io.WriteCloser, opts *MermaidOpts, fromNode, toNode s
 			log.Printf("process error with %s: %v", name, err)
 			return err
 		}
+		if n.Child != nil {
+			fmt.Fprintf(w, "  subgraph %sc [\"%s\"]\n", nid, name)
+			if err := m.nodes(n.Child, prefix+name+"/"); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "  end\n")
+			start := n.ChildStart
+			if start == "" {
+				start = DefaultChildStart
+			}
+			fmt.Fprintf(w, "  %s -.-> %s\n", nid, m.nids[prefix+name+"/"+start])
+		}
 		if n.Branches == nil {
 			return nil
 		}
@@ -142,8 +186,5 @@ func Mermaid(spec *Spec, w io.WriteCloser, opts *MermaidOpts, fromNode, toNode s
 		process(name, n)
 	}
 
-	fmt.Fprintf(w, "\n")
-	log.Printf("mermaid gen done")
-
-	return w.Close()
+	return nil
 }
@@ -38,9 +38,15 @@ func RenderSpecHTML(s *core.Spec, out io.Writer) error {
 				f(`<div class="nodeDoc doc">%s</div>`, md.Run([]byte(node.Doc)))
 			}
 			// If there's action source code, we'll show that in a <pre> tag for formatting.
+			if node.EntrySource != nil {
+				f(`<div>entry<div class="code"><pre>%s</pre></div></div>`, node.EntrySource.Source)
+			}
 			if node.ActionSource != nil {
 				f(`<div class="code"><pre>%s</pre></div>`, node.ActionSource.Source)
 			}
+			if node.ExitSource != nil {
+				f(`<div>exit<div class="code"><pre>%s</pre></div></div>`, node.ExitSource.Source)
+			}
 			// Branches are tricky. They decide where to go next based on messages. It's like a choose-your-own-adventure book!
 			if node.Branches != nil {
 				if node.Branches.Type == "message" {